@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/charm-113c/project-zero/api/middleware"
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// setUpAdminRoutes sets up the operational endpoints under /admin, guarded by the admin token
+func setUpAdminRoutes(e *echo.Echo, token string, levels *logging.Levels, logger *zap.Logger) {
+	if token == "" {
+		logger.Info("Admin token not set, admin endpoints are disabled")
+	}
+	admin := e.Group("/admin", middleware.RequireAdmin(token))
+
+	admin.GET("/log-level", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, levels.Snapshot())
+	})
+	admin.PUT("/log-level", func(c echo.Context) error {
+		return setLogLevel(c, levels, logger)
+	})
+}
+
+// logLevelRequest is the body of PUT /admin/log-level. An empty component designates
+// the root level, and an empty level makes the component follow the root level again
+type logLevelRequest struct {
+	Component string `json:"component"`
+	Level     string `json:"level"`
+}
+
+func setLogLevel(c echo.Context, levels *logging.Levels, logger *zap.Logger) error {
+	var req logLevelRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid log level request")
+	}
+
+	switch {
+	case req.Level == "" && req.Component == "":
+		return echo.NewHTTPError(http.StatusBadRequest, "the root level cannot be reset")
+	case req.Level == "":
+		levels.ResetLevel(req.Component)
+	default:
+		level, err := zapcore.ParseLevel(req.Level)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		levels.SetLevel(req.Component, level)
+	}
+
+	// Logged at warn so that the change is recorded whatever the new level
+	logger.Warn("Log level changed", zap.String("targetComponent", req.Component), zap.String("level", req.Level))
+	return c.JSON(http.StatusOK, levels.Snapshot())
+}
@@ -7,14 +7,21 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"syscall"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/logto-io/go/v2/client"
 
 	"github.com/charm-113c/project-zero/api/handlers"
+	authmw "github.com/charm-113c/project-zero/api/middleware"
 	"github.com/charm-113c/project-zero/config"
 	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/health"
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/metrics"
+	"github.com/charm-113c/project-zero/pubsub"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
@@ -27,24 +34,46 @@ type RequestHandler struct {
 	SocialReqs  SocialRequests
 	EventReqs   EventRequests
 	MapReqs     MapRequests
+	NotifReqs   NotificationRequests
+	DeviceReqs  DeviceRequests
+}
+
+// Services holds the components, other than storage, that the subhandlers rely on
+type Services struct {
+	// Bus is subscribed to by the live notifications stream, so that notifications emitted on any replica reach it
+	Bus pubsub.Bus
+	// LogLevels are exposed through the admin endpoints
+	LogLevels *logging.Levels
+	// Metrics records the router's metrics, and is exposed on the main listener (behind the admin token) unless it has its own
+	Metrics *metrics.Registry
+	// Health is exposed through the readiness probe
+	Health *health.Checker
+	// Timeouts are applied to each request, and follow the Router config when it's reloaded
+	Timeouts *authmw.Timeouts
+	// Logto makes the calls to Logto, shared with its health check
+	Logto *LogtoHTTP
 }
 
 // NewRequestHandler instantiates a RequestHandler
-func NewRequestHandler(db database.Storage, logger *zap.Logger) *RequestHandler {
+func NewRequestHandler(db database.Storage, svc Services, logger *zap.Logger) *RequestHandler {
 	return &RequestHandler{
 		handlers.NewAccountHandler(db.Conns.AccTableOps, logger),
 		handlers.NewSocialHandler(db.Conns.SocialTableOps, logger),
 		handlers.NewEventHandler(db.Conns.EvTableOps, logger),
 		handlers.NewMapHandler(db.Conns.MapTableOps, logger),
+		handlers.NewNotificationHandler(db.Conns.NotifTableOps, svc.Bus, logger),
+		handlers.NewDeviceHandler(db.Conns.DeviceTableOps, logger),
 	}
 }
 
 // InitRouter is the function responsible for instantiating a Router based on the configuration.
-func InitRouter(ctx context.Context, db database.Storage, cfg *config.Config, logger *zap.Logger) (*echo.Echo, error) {
+func InitRouter(ctx context.Context, db database.Storage, svc Services, cfg *config.Config, logger *zap.Logger) (*echo.Echo, error) {
 	// Create Echo router that will handle the requests
 	e := echo.New()
+	e.HTTPErrorHandler = newHTTPErrorHandler(logger)
+	e.Use(authmw.Deadlines(svc.Timeouts))
 
-	rh := NewRequestHandler(db, logger)
+	rh := NewRequestHandler(db, svc, logger)
 
 	// Change ulimit to maximize number of connections
 	// TODO: find optimal number of file descriiptor for given hardware
@@ -64,13 +93,28 @@ func InitRouter(ctx context.Context, db database.Storage, cfg *config.Config, lo
 
 	logger.Info("Max number of open files has been updated")
 
-	if err = initSessionStore(e, cfg.Server.DevMode); err != nil {
+	if err = initSessionStore(e, cfg.Server.DevMode, cfg.Session.Key, logger); err != nil {
 		return e, err
 	}
 
 	logtoCfg := initLogtoCfg(cfg)
 
-	if err := setUpRoutes(e, rh, logtoCfg, logger); err != nil {
+	setUpHealthRoutes(e, svc.Health)
+	setUpAdminRoutes(e, cfg.Admin.Token.Reveal(), svc.LogLevels, logger)
+
+	// On the public listener, the metrics are guarded like the admin endpoints
+	if cfg.Metrics.Enabled && cfg.Metrics.Listen == "" {
+		e.GET(cfg.Metrics.Path, echo.WrapHandler(svc.Metrics.Handler()), authmw.RequireAdmin(cfg.Admin.Token.Reveal()))
+	}
+
+	// Streams are ended as soon as the server starts shutting down, which would otherwise wait on them
+	shutdown := make(chan struct{})
+	var once sync.Once
+	endStreams := func() { once.Do(func() { close(shutdown) }) }
+	e.Server.RegisterOnShutdown(endStreams)
+	e.TLSServer.RegisterOnShutdown(endStreams)
+
+	if err := setUpRoutes(e, rh, db.Conns.AccTableOps, svc.Metrics, logtoCfg, svc.Logto, cfg.Router.StreamTimeout, shutdown, logger); err != nil {
 		err = fmt.Errorf("router failed to set up routes: %v", err)
 		return e, err
 	}
@@ -80,9 +124,14 @@ func InitRouter(ctx context.Context, db database.Storage, cfg *config.Config, lo
 
 // initSessionStore primes the router with a store middleware,
 // which will be needed to store user sessions
-func initSessionStore(e *echo.Echo, devMode bool) error {
+func initSessionStore(e *echo.Echo, devMode bool, key config.Secret, logger *zap.Logger) error {
 	if devMode {
-		store := sessions.NewFilesystemStore("/", []byte("1234567890"))
+		authKey := []byte(key.Reveal())
+		if len(authKey) == 0 {
+			logger.Warn("No session key set, sessions won't survive restarts")
+			authKey = securecookie.GenerateRandomKey(32)
+		}
+		store := sessions.NewFilesystemStore("/", authKey)
 		store.Options = &sessions.Options{
 			Path:     "/",
 			MaxAge:   86400, // 1 day
@@ -108,6 +157,6 @@ func initLogtoCfg(cfg *config.Config) *client.LogtoConfig {
 	return &client.LogtoConfig{
 		Endpoint:  cfg.Logto.Endpoint,
 		AppId:     cfg.Logto.AppID,
-		AppSecret: cfg.Logto.AppSecret,
+		AppSecret: cfg.Logto.AppSecret.Reveal(),
 	}
 }
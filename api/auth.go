@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/database"
+	"github.com/labstack/echo/v4"
+	"github.com/logto-io/go/v2/client"
+)
+
+// accountCacheTTL is how long the state of an account is trusted once checked,
+// and thus how long a disabled user may still get through
+const accountCacheTTL = 30 * time.Second
+
+// maxCachedAccounts bounds the memory used by the accounts cache
+const maxCachedAccounts = 10000
+
+// logtoAccount returns a lookup function for accountGate, which identifies the user
+// through the Logto session of the request
+func logtoAccount(logtoCfg *client.LogtoConfig, logto *LogtoHTTP) func(c echo.Context) (database.Account, bool) {
+	return func(c echo.Context) (database.Account, bool) {
+		// Calls to Logto are traced as children of the request's span
+		logtoClient := client.NewLogtoClient(logtoCfg, &echoSessionStorage{c},
+			client.WithHttpClient(logto.Client(c.Request().Context())))
+		if !logtoClient.IsAuthenticated() {
+			return database.Account{}, false
+		}
+		claims, err := logtoClient.GetIdTokenClaims()
+		if err != nil {
+			return database.Account{}, false
+		}
+		return database.Account{ID: claims.Sub, Username: claims.Username, Email: claims.Email}, true
+	}
+}
+
+type cachedAccount struct {
+	disabled  bool
+	checkedAt time.Time
+}
+
+// accountGate is the lookup function of middleware.RequireUser. It creates the account of users
+// signing in for the first time, and treats users whose account is disabled (see `backend user disable`)
+// as signed out. Accounts are checked at most every accountCacheTTL, rather than on every request
+type accountGate struct {
+	lookup   func(c echo.Context) (database.Account, bool)
+	accounts database.AccountStorageHandler
+
+	mu    sync.Mutex
+	cache map[string]cachedAccount
+}
+
+func newAccountGate(lookup func(c echo.Context) (database.Account, bool), accounts database.AccountStorageHandler) *accountGate {
+	return &accountGate{lookup: lookup, accounts: accounts, cache: make(map[string]cachedAccount)}
+}
+
+// UserID returns the ID of the user making the request, failing if their account couldn't be checked,
+// as a disabled user must not get through while the DB is unavailable
+func (g *accountGate) UserID(c echo.Context) (string, bool, error) {
+	account, ok := g.lookup(c)
+	if !ok {
+		return "", false, nil
+	}
+
+	g.mu.Lock()
+	cached, found := g.cache[account.ID]
+	g.mu.Unlock()
+	if found && time.Since(cached.checkedAt) < accountCacheTTL {
+		return account.ID, !cached.disabled, nil
+	}
+
+	disabled, err := g.check(c.Request().Context(), account)
+	if err != nil {
+		return "", false, err
+	}
+	g.store(account.ID, cachedAccount{disabled, time.Now()})
+	return account.ID, !disabled, nil
+}
+
+// check creates the account if it doesn't exist yet, and reports whether it's disabled
+func (g *accountGate) check(ctx context.Context, account database.Account) (bool, error) {
+	if err := g.accounts.CreateAccount(ctx, account); err != nil {
+		return false, err
+	}
+	return g.accounts.IsAccountDisabled(ctx, account.ID)
+}
+
+func (g *accountGate) store(userID string, account cachedAccount) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.cache) >= maxCachedAccounts {
+		for id, cached := range g.cache {
+			if time.Since(cached.checkedAt) >= accountCacheTTL {
+				delete(g.cache, id)
+			}
+		}
+		if len(g.cache) >= maxCachedAccounts {
+			// Every entry is fresh, the account will simply be checked again
+			return
+		}
+	}
+	g.cache[userID] = account
+}
@@ -40,4 +40,6 @@ type Profile struct {
 	ProfileUpgrades []string
 	FollowedEvents  []string
 	JoinedEvents    []string
+	// NotificationPrefs maps each notification type to whether the user receives it
+	NotificationPrefs map[string]bool
 }
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/charm-113c/project-zero/api/middleware"
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/diagnostics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// NewDiagnosticsRouter instantiates the router of the diagnostics listener, which serves net/http/pprof
+// and the diagnostics package's endpoints. It requires the admin token if one is set; otherwise the
+// listener must be bound to a loopback address, so that diagnostics are never left open to the network
+func NewDiagnosticsRouter(cfg *config.Config, profiler *diagnostics.Profiler, logger *zap.Logger) (*echo.Echo, error) {
+	if cfg.Admin.Token == "" && !config.IsLoopback(cfg.Diagnostics.Listen) {
+		return nil, fmt.Errorf("diagnostics listener %s is not bound to a loopback address and no admin token is set", cfg.Diagnostics.Listen)
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = newHTTPErrorHandler(logger)
+	if cfg.Admin.Token != "" {
+		e.Use(middleware.RequireAdmin(cfg.Admin.Token.Reveal()))
+	}
+
+	// pprof's Index serves the named profiles (heap, goroutine, block...) under its path
+	e.GET("/debug/pprof/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	e.GET("/debug/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+	e.GET("/debug/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+	e.GET("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	e.POST("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	e.GET("/debug/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+
+	e.GET("/debug/goroutines", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+		return diagnostics.WriteGoroutines(c.Response())
+	})
+	e.GET("/debug/gc", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, diagnostics.ReadGCStats())
+	})
+
+	// Captures run in the background and are written to files, so that they can be taken
+	// during an incident without keeping a connection open for their whole duration
+	e.POST("/debug/captures/cpu", func(c echo.Context) error {
+		return startCapture(c, profiler.CaptureCPU, 30*time.Second, logger)
+	})
+	e.POST("/debug/captures/trace", func(c echo.Context) error {
+		return startCapture(c, profiler.CaptureTrace, 5*time.Second, logger)
+	})
+
+	return e, nil
+}
+
+// captureResponse is the body of the responses of the capture endpoints
+type captureResponse struct {
+	File     string `json:"file"`
+	Duration string `json:"duration"`
+}
+
+// startCapture starts a capture lasting the number of seconds given by the "seconds" query param, or defaultDuration
+func startCapture(c echo.Context, capture func(time.Duration) (string, error), defaultDuration time.Duration, logger *zap.Logger) error {
+	d := defaultDuration
+	if s := c.QueryParam("seconds"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "seconds must be an integer")
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d <= 0 || d > diagnostics.MaxCaptureDuration {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("seconds must be between 1 and %d", int(diagnostics.MaxCaptureDuration.Seconds())))
+	}
+
+	file, err := capture(d)
+	if errors.Is(err, diagnostics.ErrCaptureRunning) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		logger.Error("Could not start capture", zap.Error(err))
+		return err
+	}
+	return c.JSON(http.StatusAccepted, captureResponse{file, d.String()})
+}
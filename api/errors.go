@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// errorResponse is the body of every error response. It carries the request ID
+// so that users can hand it over to support, who can then find the related logs
+type errorResponse struct {
+	Message   string `json:"message"`
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// newHTTPErrorHandler returns the replacement of Echo's default error handler, adding the request ID to error
+// responses. Errors other than echo.HTTPError are reported as internal server errors, without details
+func newHTTPErrorHandler(logger *zap.Logger) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+
+		code := http.StatusInternalServerError
+		message := http.StatusText(code)
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			code = httpErr.Code
+			message = http.StatusText(code)
+			if m, ok := httpErr.Message.(string); ok && m != "" {
+				message = m
+			}
+		}
+
+		ctx := c.Request().Context()
+		resp := errorResponse{message, logging.RequestIDFromContext(ctx)}
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(code)
+		} else {
+			err = c.JSON(code, resp)
+		}
+		if err != nil {
+			logging.FromContext(ctx, logger).Error("Could not write error response", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/charm-113c/project-zero/api/middleware"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/push"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// RegisterDevice registers a device token for the user to receive push notifications.
+// The body must contain the token and the device's platform ("ios" or "android")
+func (h *DeviceHandler) RegisterDevice(c echo.Context) error {
+	var device database.Device
+	if err := c.Bind(&device); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid device")
+	}
+	if device.Token == "" || !push.IsValidPlatform(device.Platform) {
+		return echo.NewHTTPError(http.StatusBadRequest, "a token and a platform (ios or android) are required")
+	}
+	device.UserID = middleware.UserID(c)
+
+	if err := h.DB.RegisterDevice(c.Request().Context(), device); err != nil {
+		requestLogger(c, h.Logger).Error("Could not register device", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not register device")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// UnregisterDevice stops push notifications to the device token given as path param
+func (h *DeviceHandler) UnregisterDevice(c echo.Context) error {
+	err := h.DB.UnregisterDevice(c.Request().Context(), middleware.UserID(c), c.Param("token"))
+	if errors.Is(err, database.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "device not found")
+	}
+	if err != nil {
+		requestLogger(c, h.Logger).Error("Could not unregister device", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not unregister device")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
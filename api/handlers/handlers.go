@@ -4,7 +4,11 @@
 package handlers
 
 import (
+
 	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/pubsub"
+	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
@@ -36,6 +40,21 @@ type MapHandler struct {
 	Logger *zap.Logger
 }
 
+// NotificationHandler implements the NotificationRequests interface and handles
+// requests relating to the user's notification inbox
+type NotificationHandler struct {
+	DB     database.NotificationStorageHandler
+	Bus    pubsub.Bus
+	Logger *zap.Logger
+}
+
+// DeviceHandler implements the DeviceRequests interface and handles
+// the registration of devices for push notifications
+type DeviceHandler struct {
+	DB     database.DeviceStorageHandler
+	Logger *zap.Logger
+}
+
 // NewAccountHandler instantiates an AccountHandler
 func NewAccountHandler(db database.AccountStorageHandler, logger *zap.Logger) *AccountHandler {
 	return &AccountHandler{
@@ -67,3 +86,25 @@ func NewMapHandler(db database.MapStorageHandler, logger *zap.Logger) *MapHandle
 		logger,
 	}
 }
+
+// NewNotificationHandler instantiates a NotificationHandler
+func NewNotificationHandler(db database.NotificationStorageHandler, bus pubsub.Bus, logger *zap.Logger) *NotificationHandler {
+	return &NotificationHandler{
+		db,
+		bus,
+		logger,
+	}
+}
+
+// NewDeviceHandler instantiates a DeviceHandler
+func NewDeviceHandler(db database.DeviceStorageHandler, logger *zap.Logger) *DeviceHandler {
+	return &DeviceHandler{
+		db,
+		logger,
+	}
+}
+
+// requestLogger returns a child of logger carrying the ID of the request being served
+func requestLogger(c echo.Context, logger *zap.Logger) *zap.Logger {
+	return logging.FromContext(c.Request().Context(), logger)
+}
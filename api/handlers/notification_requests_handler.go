@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/charm-113c/project-zero/api/middleware"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/notifications"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultNotificationsLimit = 50
+	maxNotificationsLimit     = 200
+	// liveKeepAlive is how often an idle live stream sends a comment, so that proxies don't close it
+	liveKeepAlive = 30 * time.Second
+)
+
+// ListNotifications returns the user's notifications, most recent first.
+// Query params: unread (bool) to only list unread ones, limit (int)
+func (h *NotificationHandler) ListNotifications(c echo.Context) error {
+	unreadOnly, _ := strconv.ParseBool(c.QueryParam("unread"))
+	limit := defaultNotificationsLimit
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+		limit = min(l, maxNotificationsLimit)
+	}
+
+	notifs, err := h.DB.ListNotifications(c.Request().Context(), middleware.UserID(c), unreadOnly, limit)
+	if err != nil {
+		requestLogger(c, h.Logger).Error("Could not list notifications", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not list notifications")
+	}
+
+	views := make([]notifications.View, 0, len(notifs))
+	for _, n := range notifs {
+		views = append(views, notifications.NewView(n))
+	}
+	return c.JSON(http.StatusOK, views)
+}
+
+// StreamNotifications streams the user's notifications as server-sent events ("notification" events, whose data
+// is the notification's View) as they're emitted on any replica, until the client disconnects or the request's
+// context ends (see middleware.Streaming).
+// Notifications emitted while the client isn't connected are only in the inbox
+func (h *NotificationHandler) StreamNotifications(c echo.Context) error {
+	sub, err := h.Bus.Subscribe(notifications.Topic(middleware.UserID(c)))
+	if err != nil {
+		requestLogger(c, h.Logger).Error("Could not subscribe to notifications", zap.Error(err))
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "live notifications are unavailable")
+	}
+	defer sub.Unsubscribe()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case msg, ok := <-sub.C:
+			if !ok {
+				// The bus was closed, as the server is shutting down
+				return nil
+			}
+			if _, err := fmt.Fprintf(w, "event: notification\ndata: %s\n\n", msg.Payload); err != nil {
+				return nil
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		}
+		w.Flush()
+	}
+}
+
+// MarkNotificationRead marks the notification with the id path param as read
+func (h *NotificationHandler) MarkNotificationRead(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid notification ID")
+	}
+
+	err = h.DB.MarkNotificationRead(c.Request().Context(), middleware.UserID(c), id)
+	if errors.Is(err, database.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "notification not found")
+	}
+	if err != nil {
+		requestLogger(c, h.Logger).Error("Could not mark notification as read", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not mark notification as read")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// MarkAllNotificationsRead marks all of the user's notifications as read
+func (h *NotificationHandler) MarkAllNotificationsRead(c echo.Context) error {
+	if err := h.DB.MarkAllNotificationsRead(c.Request().Context(), middleware.UserID(c)); err != nil {
+		requestLogger(c, h.Logger).Error("Could not mark notifications as read", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not mark notifications as read")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetNotificationPrefs returns whether each notification type is enabled for the user
+func (h *NotificationHandler) GetNotificationPrefs(c echo.Context) error {
+	prefs, err := h.DB.GetNotificationPrefs(c.Request().Context(), middleware.UserID(c))
+	if err != nil {
+		requestLogger(c, h.Logger).Error("Could not read notification preferences", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not read notification preferences")
+	}
+
+	// Types the user never set are enabled
+	all := make(map[string]bool, len(notifications.Types))
+	for _, t := range notifications.Types {
+		enabled, ok := prefs[t]
+		all[t] = !ok || enabled
+	}
+	return c.JSON(http.StatusOK, all)
+}
+
+// UpdateNotificationPrefs enables or disables notification types for the user.
+// The body maps notification types to whether they're enabled, e.g. {"event_updated": false};
+// types not in the body are left unchanged
+func (h *NotificationHandler) UpdateNotificationPrefs(c echo.Context) error {
+	var prefs map[string]bool
+	if err := c.Bind(&prefs); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid notification preferences")
+	}
+	for t := range prefs {
+		if !notifications.IsValidType(t) {
+			return echo.NewHTTPError(http.StatusBadRequest, "unknown notification type "+t)
+		}
+	}
+
+	if err := h.DB.SetNotificationPrefs(c.Request().Context(), middleware.UserID(c), prefs); err != nil {
+		requestLogger(c, h.Logger).Error("Could not save notification preferences", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not save notification preferences")
+	}
+	return h.GetNotificationPrefs(c)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/charm-113c/project-zero/health"
+	"github.com/labstack/echo/v4"
+)
+
+// Paths of the probes, which require no authentication
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+)
+
+// setUpHealthRoutes sets up the liveness and readiness probes. Liveness only tells that the
+// process is able to answer, while readiness reports on the dependencies of the server
+func setUpHealthRoutes(e *echo.Echo, checker *health.Checker) {
+	e.GET(livenessPath, func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": health.StatusOK})
+	})
+	e.GET(readinessPath, func(c echo.Context) error {
+		report := checker.Check(c.Request().Context())
+		if !report.Ready() {
+			return c.JSON(http.StatusServiceUnavailable, report)
+		}
+		return c.JSON(http.StatusOK, report)
+	})
+}
+
+// logtoCheckTTL is how long the result of LogtoDiscoveryCheck is reused, probes hitting every few seconds
+const logtoCheckTTL = 30 * time.Second
+
+// LogtoDiscoveryCheck returns a health check fetching the OpenID discovery document of the Logto
+// instance at endpoint, which every sign-in and token verification starts with
+func LogtoDiscoveryCheck(endpoint string, logto *LogtoHTTP) health.Check {
+	return health.Cached(func(ctx context.Context) error {
+		discoveryURL, err := url.JoinPath(endpoint, "/oidc/.well-known/openid-configuration")
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := logto.Client(ctx).Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("discovery document request responded %d", resp.StatusCode)
+		}
+		return nil
+	}, logtoCheckTTL)
+}
@@ -0,0 +1,10 @@
+package api
+
+import "github.com/labstack/echo/v4"
+
+// DeviceRequests contains the methods handling the registration of
+// the user's devices for push notifications
+type DeviceRequests interface {
+	RegisterDevice(c echo.Context) error
+	UnregisterDevice(c echo.Context) error
+}
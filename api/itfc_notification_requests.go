@@ -0,0 +1,14 @@
+package api
+
+import "github.com/labstack/echo/v4"
+
+// NotificationRequests contains the methods handling requests concerning
+// the user's notification inbox and notification preferences
+type NotificationRequests interface {
+	ListNotifications(c echo.Context) error
+	StreamNotifications(c echo.Context) error
+	MarkNotificationRead(c echo.Context) error
+	MarkAllNotificationsRead(c echo.Context) error
+	GetNotificationPrefs(c echo.Context) error
+	UpdateNotificationPrefs(c echo.Context) error
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/charm-113c/project-zero/tracing"
+	"github.com/charm-113c/project-zero/util"
+	"go.uber.org/zap"
+)
+
+// logtoRetryPolicy is short, as calls to Logto are made while serving requests
+var logtoRetryPolicy = util.RetryPolicy{
+	Attempts:  3,
+	BaseDelay: 100 * time.Millisecond,
+	MaxDelay:  time.Second,
+	Deadline:  3 * time.Second,
+}
+
+// LogtoHTTP makes the HTTP calls to Logto: transient failures of idempotent calls are retried, and calls
+// fail fast while Logto is unavailable, rather than piling up on timeouts. It's shared by every call to Logto
+type LogtoHTTP struct {
+	breaker *util.CircuitBreaker
+	logger  *zap.Logger
+}
+
+// NewLogtoHTTP instantiates a LogtoHTTP, whose circuit opens after 5 consecutive failures for 30 seconds
+func NewLogtoHTTP(parentLogger *zap.Logger) *LogtoHTTP {
+	logger := parentLogger.With(zap.String("component", "logto"))
+	return &LogtoHTTP{util.NewCircuitBreaker("logto", 5, 30*time.Second, logger), logger}
+}
+
+// Client returns an http.Client for calls to Logto, traced as children of the span carried by ctx
+func (l *LogtoHTTP) Client(ctx context.Context) *http.Client {
+	return &http.Client{Transport: &util.RetryTransport{
+		Base:    &tracing.Transport{Parent: ctx},
+		Policy:  logtoRetryPolicy,
+		Breaker: l.breaker,
+		Logger:  l.logger,
+	}}
+}
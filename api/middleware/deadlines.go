@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Timeouts are the read and write timeouts applied to each request, which can be changed at runtime
+type Timeouts struct {
+	read, write atomic.Int64 // time.Duration
+}
+
+// NewTimeouts instantiates Timeouts
+func NewTimeouts(read, write time.Duration) *Timeouts {
+	t := new(Timeouts)
+	t.Set(read, write)
+	return t
+}
+
+// Set changes the timeouts, for the requests received from then on
+func (t *Timeouts) Set(read, write time.Duration) {
+	t.read.Store(int64(read))
+	t.write.Store(int64(write))
+}
+
+// Deadlines applies t to each request, replacing the deadlines set by the server from its own timeouts,
+// which can't be changed once it's started. A timeout of 0 means no deadline
+func Deadlines(t *Timeouts) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			now := time.Now()
+			rc := http.NewResponseController(c.Response())
+			// Errors mean the connection doesn't support deadlines (e.g. in tests), in which case there's nothing to replace
+			_ = rc.SetReadDeadline(deadline(now, time.Duration(t.read.Load())))
+			_ = rc.SetWriteDeadline(deadline(now, time.Duration(t.write.Load())))
+			return next(c)
+		}
+	}
+}
+
+func deadline(now time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/charm-113c/project-zero/metrics"
+	"github.com/labstack/echo/v4"
+)
+
+// Metrics records the number, duration and status of the requests served, per route.
+// Routes are identified by their pattern (e.g. /notifications/:id/read) rather than
+// their URI, which would give each notification its own series
+func Metrics(reg *metrics.Registry) echo.MiddlewareFunc {
+	requests := reg.NewCounter("http_requests_total",
+		"Number of HTTP requests served, by method, route and status.", "method", "route", "status")
+	durations := reg.NewHistogram("http_request_duration_seconds",
+		"Duration of HTTP requests, by method and route.", metrics.DefBuckets, "method", "route")
+	inFlight := reg.NewGauge("http_requests_in_flight", "Number of HTTP requests being served.")
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			start := time.Now()
+			err := next(c)
+			// Let Echo turn the error into a response first, in order to record the actual status
+			if err != nil && !c.Response().Committed {
+				c.Error(err)
+			}
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := c.Request().Method
+			requests.Inc(method, route, strconv.Itoa(c.Response().Status))
+			durations.Observe(time.Since(start).Seconds(), method, route)
+			return err
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/labstack/echo/v4"
+)
+
+// maxRequestIDLength bounds the length of the request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// RequestID accepts the X-Request-ID header of the request, or generates a new ID if it's
+// missing or invalid. The ID is sent back in the response's X-Request-ID header and stored
+// in the request's context.Context (see logging.RequestIDFromContext)
+func RequestID() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			requestID := req.Header.Get(echo.HeaderXRequestID)
+			if !validRequestID(requestID) {
+				requestID = newRequestID()
+			}
+
+			c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+			c.SetRequest(req.WithContext(logging.ContextWithRequestID(req.Context(), requestID)))
+			return next(c)
+		}
+	}
+}
+
+// validRequestID only accepts reasonably short IDs made of printable ASCII characters,
+// so that client provided IDs cannot be used to forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
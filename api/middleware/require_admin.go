@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireAdmin rejects requests that don't carry the admin token as a bearer token.
+// An empty token disables the guarded routes altogether
+func RequireAdmin(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token == "" {
+				return echo.NewHTTPError(http.StatusNotFound)
+			}
+			given, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized, "admin authentication required")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserIDKey is the echo.Context key under which RequireUser stores the authenticated user's ID
+const UserIDKey = "userID"
+
+// RequireUser rejects unauthenticated requests, and stores the ID of the authenticated user
+// in the echo.Context for the following handlers (see UserID).
+// lookup returns the ID of the user making the request, and false if there is none. It returns
+// an error if it couldn't tell (e.g. the DB is unavailable), the request being rejected as unavailable
+func RequireUser(lookup func(c echo.Context) (string, bool, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok, err := lookup(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "could not authenticate the request, try again later").
+					SetInternal(err)
+			}
+			if !ok || userID == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+			}
+			c.Set(UserIDKey, userID)
+			return next(c)
+		}
+	}
+}
+
+// UserID returns the ID of the authenticated user stored by RequireUser,
+// or an empty string if the route isn't guarded by it
+func UserID(c echo.Context) string {
+	userID, _ := c.Get(UserIDKey).(string)
+	return userID
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Streaming lifts the server's read and write timeouts for long-lived routes (server-sent events,
+// WebSockets, profiles...), which would otherwise be cut once the server's WriteTimeout elapses.
+// Their connection is instead bounded by timeout, or not at all if it's 0; WebSocket libraries
+// manage the deadlines of hijacked connections themselves afterwards.
+// The request's context is cancelled once shutdown is closed, so that streams end rather than hold up
+// the server's graceful shutdown
+func Streaming(timeout time.Duration, shutdown <-chan struct{}) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			d := deadline(time.Now(), timeout)
+			rc := http.NewResponseController(c.Response())
+			// Errors mean the connection doesn't support deadlines (e.g. in tests), in which case there's nothing to lift
+			_ = rc.SetReadDeadline(d)
+			_ = rc.SetWriteDeadline(d)
+
+			ctx, cancel := context.WithCancel(c.Request().Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-shutdown:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/tracing"
+	"github.com/labstack/echo/v4"
+)
+
+// Tracing records a server span for each request, as a child of the caller's span if the
+// request has a valid traceparent header. The span is stored in the request's context.Context,
+// so that the spans recorded while serving the request (e.g. queries) are its children
+func Tracing() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := req.Context()
+			if parent, ok := tracing.Extract(req.Header); ok {
+				ctx = tracing.ContextWithRemoteParent(ctx, parent)
+			}
+
+			route := c.Path()
+			ctx, span := tracing.Start(ctx, req.Method+" "+route, tracing.KindServer)
+			if span == nil {
+				return next(c)
+			}
+			defer span.End()
+			span.SetAttr("http.request.method", req.Method)
+			span.SetAttr("http.route", route)
+			span.SetAttr("url.path", req.URL.Path)
+			span.SetAttr(logging.RequestIDKey, logging.RequestIDFromContext(ctx))
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+			// Let Echo turn the error into a response first, in order to record the actual status
+			if err != nil && !c.Response().Committed {
+				c.Error(err)
+			}
+			status := c.Response().Status
+			span.SetAttr("http.response.status_code", status)
+			if status >= http.StatusInternalServerError {
+				if err == nil {
+					err = fmt.Errorf("responded %d", status)
+				}
+				span.RecordError(err)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/http2"
+)
+
+// ConfigureServer sets up the http.Server of e listening on addr, over TLS if tlsConfig isn't nil.
+// Its timeouts and limits come from the Router config, so that slow clients can't hold connections
+// open indefinitely; routes that stream their responses lift them with middleware.Streaming.
+// The returned server must be started with e.StartServer, and is stopped by e.Shutdown
+func ConfigureServer(e *echo.Echo, addr string, tlsConfig *tls.Config, cfg *config.Config) (*http.Server, error) {
+	s := e.Server
+	if tlsConfig != nil {
+		s = e.TLSServer
+	}
+	s.Addr = addr
+	s.ReadTimeout = cfg.Router.ReadTimeout
+	s.ReadHeaderTimeout = cfg.Router.ReadHeaderTimeout
+	s.WriteTimeout = cfg.Router.WriteTimeout
+	s.IdleTimeout = cfg.Router.IdleTimeout
+	s.MaxHeaderBytes = cfg.Router.MaxHeaderBytes
+	if tlsConfig == nil {
+		// HTTP/2 is only negotiated over TLS
+		return s, nil
+	}
+
+	s.TLSConfig = tlsConfig.Clone()
+	if !cfg.Router.HTTP2.Enabled {
+		s.TLSConfig.NextProtos = slices.DeleteFunc(s.TLSConfig.NextProtos, func(proto string) bool { return proto == http2.NextProtoTLS })
+		// A non-nil empty map disables net/http's own HTTP/2 support
+		s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return s, nil
+	}
+	err := http2.ConfigureServer(s, &http2.Server{
+		MaxConcurrentStreams: cfg.Router.HTTP2.MaxConcurrentStreams,
+		MaxReadFrameSize:     cfg.Router.HTTP2.MaxReadFrameSize,
+		IdleTimeout:          cfg.Router.IdleTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error configuring HTTP/2: %w", err)
+	}
+	return s, nil
+}
@@ -3,16 +3,28 @@ package api
 import (
 	// echojwt "github.com/labstack/echo-jwt/v4"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+
+	authmw "github.com/charm-113c/project-zero/api/middleware"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/metrics"
 	"github.com/logto-io/go/v2/client"
 	"go.uber.org/zap"
 )
 
-func setUpRoutes(e *echo.Echo, rh *RequestHandler, logtoCfg *client.LogtoConfig, logger *zap.Logger) error {
+func setUpRoutes(e *echo.Echo, rh *RequestHandler, accounts database.AccountStorageHandler, reg *metrics.Registry, logtoCfg *client.LogtoConfig, logto *LogtoHTTP, streamTimeout time.Duration, shutdown <-chan struct{}, logger *zap.Logger) error {
+	// Generate a request ID, unless the client provided one, and carry it through the request's context
+	e.Use(authmw.RequestID())
+	// Record a span for each request, whose trace ID is added to the logs
+	e.Use(authmw.Tracing())
+	// Record the requests' metrics, once the logger below has turned errors into responses
+	e.Use(authmw.Metrics(reg))
 	useLoggerMiddleware(e, logger)
 
 	// TODO: Have a look at Echo's middleware arsenal, put in what is necessary
@@ -25,6 +37,7 @@ func setUpRoutes(e *echo.Echo, rh *RequestHandler, logtoCfg *client.LogtoConfig,
 		client := client.NewLogtoClient(
 			logtoCfg,
 			&echoSessionStorage{c},
+			client.WithHttpClient(logto.Client(c.Request().Context())),
 		)
 
 		sess, err := session.Get("session", c)
@@ -57,28 +70,71 @@ func setUpRoutes(e *echo.Echo, rh *RequestHandler, logtoCfg *client.LogtoConfig,
 	// Create sign-in route
 	e.GET("account/login", rh.AccountReqs.LoginUser)
 
+	// Routes below require a logged in user, whose account isn't disabled
+	requireUser := authmw.RequireUser(newAccountGate(logtoAccount(logtoCfg, logto), accounts).UserID)
+
+	// Notification inbox and preferences
+	notifs := e.Group("/notifications", requireUser)
+	notifs.GET("", rh.NotifReqs.ListNotifications)
+	// The live stream outlasts the server's timeouts
+	notifs.GET("/live", rh.NotifReqs.StreamNotifications, authmw.Streaming(streamTimeout, shutdown))
+	notifs.POST("/read-all", rh.NotifReqs.MarkAllNotificationsRead)
+	notifs.POST("/:id/read", rh.NotifReqs.MarkNotificationRead)
+	e.GET("/account/notification-preferences", rh.NotifReqs.GetNotificationPrefs, requireUser)
+	e.PUT("/account/notification-preferences", rh.NotifReqs.UpdateNotificationPrefs, requireUser)
+
+	// Device tokens for push notifications
+	e.POST("/account/devices", rh.DeviceReqs.RegisterDevice, requireUser)
+	e.DELETE("/account/devices/:token", rh.DeviceReqs.UnregisterDevice, requireUser)
+
 	return nil
 }
 
 // useLoggerMiddleware applies Echo's routing logger to our current logger,
-// giving it access to specific request fields
+// giving it access to specific request fields. Each request is logged once it's served,
+// at a level depending on its outcome: error for server errors, warn for client errors
 func useLoggerMiddleware(e *echo.Echo, logger *zap.Logger) {
 	// Echo middleware allows access to detailed request data
 	// See their docs for more options
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogURI:    true,
-		LogStatus: true,
-		LogError:  true,
+		LogMethod:       true,
+		LogRoutePath:    true,
+		LogURI:          true,
+		LogStatus:       true,
+		LogLatency:      true,
+		LogResponseSize: true,
+		LogError:        true,
+		// Probes are too frequent to be logged, the health checker logs readiness changes instead
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == livenessPath || c.Path() == readinessPath
+		},
+		// Errors are returned by handlers before Echo turns them into responses,
+		// so let it do so first in order to log the actual status
+		HandleError: true,
 		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			if v.Error == nil {
-				logger.Sugar().Info(zap.String("request", "successful"),
-					zap.Int("Status", v.Status),
-					zap.String("URI", v.URI))
-			} else {
-				logger.Sugar().Info(zap.String("request", "error"),
-					zap.Int("Status", v.Status),
-					zap.String("URI", v.URI))
-				zap.Error(v.Error)
+			fields := []zap.Field{
+				zap.String("method", v.Method),
+				zap.String("route", v.RoutePath),
+				zap.String("uri", v.URI),
+				zap.Int("status", v.Status),
+				zap.Duration("latency", v.Latency),
+				zap.Int64("bytes", v.ResponseSize),
+			}
+			fields = append(fields, logging.ContextFields(c.Request().Context())...)
+			if userID := authmw.UserID(c); userID != "" {
+				fields = append(fields, zap.String("userID", userID))
+			}
+			if v.Error != nil {
+				fields = append(fields, zap.Error(v.Error))
+			}
+
+			switch {
+			case v.Status >= http.StatusInternalServerError:
+				logger.Error("Request failed", fields...)
+			case v.Status >= http.StatusBadRequest:
+				logger.Warn("Request rejected", fields...)
+			default:
+				logger.Info("Request served", fields...)
 			}
 			return nil
 		},
@@ -0,0 +1,128 @@
+/* Package certs provides the TLS setup of the server's HTTPS listener: certificates are either
+* obtained from Let's Encrypt (autocert) and cached on disk, or read from files and reloaded
+* whenever they change, so that renewed certificates are picked up without a restart.
+* Client certificates can optionally be verified (mTLS), for internal callers, and plain HTTP
+* requests are redirected to HTTPS by a dedicated handler.
+ */
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/charm-113c/project-zero/config"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS modes
+const (
+	ModeAutocert = "autocert" // Certificates are obtained from Let's Encrypt
+	ModeFiles    = "files"    // Certificates are read from Server.CertFile and Server.KeyFile
+)
+
+// Client authentication policies
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional" // Client certificates are verified if presented
+	ClientAuthRequire  = "require"
+)
+
+// Setup is the TLS configuration of the HTTPS listener, and the handler of the HTTP listener redirecting to it
+type Setup struct {
+	Config *tls.Config
+	// Redirect redirects requests to HTTPS, and answers the ACME HTTP-01 challenges in autocert mode
+	Redirect http.Handler
+}
+
+// NewSetup builds the TLS setup described by cfg. In files mode, the certificate is watched for changes until ctx is done
+func NewSetup(ctx context.Context, cfg config.Config, parentLogger *zap.Logger) (*Setup, error) {
+	logger := parentLogger.With(zap.String("component", "tls"))
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The listener is wrapped before reaching net/http, which thus can't negotiate HTTP/2 itself
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	redirect := redirectHandler(cfg.Server.Port)
+
+	switch cfg.TLS.Mode {
+	case ModeAutocert:
+		if len(cfg.TLS.Domains) == 0 {
+			// Otherwise anyone could have the server request certificates for arbitrary hosts
+			return nil, fmt.Errorf("autocert mode requires the domains to request certificates for")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.TLS.CacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.TLS.Domains...),
+			Email:      cfg.TLS.Email,
+		}
+		tlsCfg.GetCertificate = manager.GetCertificate
+		tlsCfg.NextProtos = append(tlsCfg.NextProtos, acme.ALPNProto)
+		redirect = manager.HTTPHandler(redirect)
+	case ModeFiles:
+		reloader, err := NewReloader(cfg.Server.CertFile, cfg.Server.KeyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		go reloader.Watch(ctx, cfg.TLS.ReloadInterval)
+		tlsCfg.GetCertificate = reloader.GetCertificate
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", cfg.TLS.Mode)
+	}
+
+	if err := setClientAuth(tlsCfg, cfg.TLS.ClientAuth, cfg.TLS.ClientCAFile); err != nil {
+		return nil, err
+	}
+	logger.Info("TLS configured", zap.String("mode", cfg.TLS.Mode), zap.String("clientAuth", cfg.TLS.ClientAuth))
+	return &Setup{Config: tlsCfg, Redirect: redirect}, nil
+}
+
+// setClientAuth configures the verification of client certificates against the CAs of caFile
+func setClientAuth(tlsCfg *tls.Config, policy, caFile string) error {
+	switch policy {
+	case ClientAuthNone, "":
+		return nil
+	case ClientAuthOptional:
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("unknown client authentication policy %q", policy)
+	}
+
+	if caFile == "" {
+		return fmt.Errorf("client authentication requires a client CA file")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("error reading client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificate found in client CA file %s", caFile)
+	}
+	tlsCfg.ClientCAs = pool
+	return nil
+}
+
+// redirectHandler permanently redirects requests to the same URL on the HTTPS listener's port
+func redirectHandler(httpsPort uint16) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(int(httpsPort)))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
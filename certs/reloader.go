@@ -0,0 +1,108 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Reloader serves a certificate read from files, and reloads it when they change on disk
+type Reloader struct {
+	certFile, keyFile string
+	logger            *zap.Logger
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	loaded fileStamps // Stamps of the files the current certificate was read from
+	failed fileStamps // Stamps of the files that last failed to load, so that failures are only logged once
+}
+
+// fileStamps identify a version of the certificate and key files
+type fileStamps [2]struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader instantiates a Reloader, failing if the certificate can't be loaded
+func NewReloader(certFile, keyFile string, logger *zap.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, its signature matches tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload reads the certificate and key files, keeping the current certificate if they're invalid
+func (r *Reloader) Reload() error {
+	stamps, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		r.mu.Lock()
+		r.failed = stamps
+		r.mu.Unlock()
+		return fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.loaded = stamps
+	return nil
+}
+
+// Watch reloads the certificate every time its files change, checking them every interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stamps, err := r.stat()
+		r.mu.RLock()
+		unchanged := stamps == r.loaded || stamps == r.failed
+		r.mu.RUnlock()
+		if err != nil || unchanged {
+			// Missing files are most likely being replaced
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			// The key may not have been written yet, it will be retried once it changes
+			r.logger.Warn("Could not reload TLS certificate, keeping the current one", zap.Error(err))
+			continue
+		}
+		r.logger.Info("TLS certificate reloaded", zap.String("certFile", r.certFile))
+	}
+}
+
+func (r *Reloader) stat() (fileStamps, error) {
+	var stamps fileStamps
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return stamps, fmt.Errorf("error reading TLS certificate: %w", err)
+		}
+		stamps[i].modTime = info.ModTime()
+		stamps[i].size = info.Size()
+	}
+	return stamps, nil
+}
@@ -9,8 +9,10 @@ package config
 import (
 	"fmt"
 	"log"
-	"net"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -20,6 +22,9 @@ import (
 // Config holds the configurations for the server. The CfgPaths are the paths to the .env and .yaml config files,
 // and should only be read from environmental variables. The Server vals can be read both from .env and .yaml files.
 // CfgPaths is never actually used, it only serves to document the expected key for environmental variables.
+// Fields of type Secret are redacted when printed, and can be read from files (see ResolveSecrets).
+// Fields tagged `reload:"true"` are applied when the config is reloaded (see Reloader), changes to the others require a restart.
+// The `validate` tags list the rules each field is checked against (see Validate).
 type Config struct {
 	CfgPaths struct {
 		EnvPath string `env:"ENV_PATH" env-default:"config/config.env"`
@@ -27,50 +32,210 @@ type Config struct {
 	}
 	Server struct {
 		DevMode  bool   `yaml:"devMode" env:"DEV_MODE" env-default:"true"`
-		Port     uint16 `yaml:"port" env:"SRV_PORT" env-default:"7777"`
-		Host     string `yaml:"host" env:"SRV_HOST" env-default:"localhost"`
-		LogFile  string `yaml:"logFile" env:"LOG_FILE" env-default:"/var/log/junkyard.log"`
+		Port     uint16 `yaml:"port" env:"SRV_PORT" env-default:"7777" validate:"port"`
+		Host     string `yaml:"host" env:"SRV_HOST" env-default:"localhost" validate:"host"`
+		LogFile  string `yaml:"logFile" env:"LOG_FILE" env-default:"/var/log/junkyard.log" validate:"required"`
 		CertFile string `yaml:"certFile" env:"CERT_FILE" env-default:"/some/where/secure"`
 		KeyFile  string `yaml:"keyFile" env:"KEY_FILE" env-default:"/some/where/secure"`
 	} `yaml:"server"`
+	TLS struct {
+		// Mode is "autocert" (Let's Encrypt) or "files" (Server.CertFile and Server.KeyFile), outside of dev mode
+		Mode string `yaml:"mode" env:"TLS_MODE" env-default:"autocert" validate:"oneof=autocert|files"`
+		// CacheDir persists the certificates obtained in autocert mode, so that they're not requested at each start
+		CacheDir string   `yaml:"cacheDir" env:"TLS_CACHE_DIR" env-default:"autocert-cache"`
+		Domains  []string `yaml:"domains" env:"TLS_DOMAINS" env-default:"" validate:"host"` // The only hosts autocert requests certificates for
+		Email    string   `yaml:"email" env:"TLS_EMAIL" env-default:""`
+		// ReloadInterval is how often the certificate files are checked for changes in files mode
+		ReloadInterval time.Duration `yaml:"reloadInterval" env:"TLS_RELOAD_INTERVAL" env-default:"1m" validate:"positive"`
+		// ClientAuth is "none", "optional" (certificates are verified if presented) or "require",
+		// client certificates must be signed by a CA of ClientCAFile
+		ClientAuth   string `yaml:"clientAuth" env:"TLS_CLIENT_AUTH" env-default:"none" validate:"oneof=none|optional|require"`
+		ClientCAFile string `yaml:"clientCAFile" env:"TLS_CLIENT_CA_FILE" env-default:""`
+		// RedirectListen is the address of a listener redirecting HTTP requests to HTTPS (e.g. ":80"), disabled if empty
+		RedirectListen string `yaml:"redirectListen" env:"TLS_REDIRECT_LISTEN" env-default:"" validate:"addr"`
+	} `yaml:"tls"`
+	Log struct {
+		// Level is the root log level (e.g. "debug", "info", "warn"), "debug" in dev mode and "info" otherwise if empty
+		Level string `yaml:"level" env:"LOG_LEVEL" env-default:"" reload:"true" validate:"loglevel"`
+		// ErrorFile receives warn-and-above logs, on top of Server.LogFile
+		ErrorFile   string        `yaml:"errorFile" env:"LOG_ERROR_FILE" env-default:"/var/log/junkyard.error.log" validate:"required"`
+		MaxSizeMB   int           `yaml:"maxSizeMB" env:"LOG_MAX_SIZE_MB" env-default:"100" validate:"nonneg"`
+		RotateEvery time.Duration `yaml:"rotateEvery" env:"LOG_ROTATE_EVERY" env-default:"24h" validate:"nonneg"`
+		MaxAge      time.Duration `yaml:"maxAge" env:"LOG_MAX_AGE" env-default:"720h" validate:"nonneg"`
+		MaxBackups  int           `yaml:"maxBackups" env:"LOG_MAX_BACKUPS" env-default:"10" validate:"nonneg"`
+		Compress    bool          `yaml:"compress" env:"LOG_COMPRESS" env-default:"true"`
+	} `yaml:"log"`
 	Database struct {
-		Host         string `yaml:"host" env:"DB_HOST" env-default:"storage"`
-		Type         string `yaml:"type" env:"DB_TYPE" env-default:"postgres"`
-		User         string `yaml:"user" env:"DB_USER" env-default:"postgres"`
-		Password     string `yaml:"password" env:"DB_PWD" env-default:"password"`
-		DBName       string `yaml:"dbName" env:"DB_NAME" env-default:"database"`
-		ConnPoolSize int    `yaml:"poolSize" env:"DB_POOL_SIZE" env-default:"20"`
-		Port         uint16 `yaml:"port" env:"DB_PORT" env-default:"5432"`
+		Host         string `yaml:"host" env:"DB_HOST" env-default:"storage" validate:"required,host"`
+		Type         string `yaml:"type" env:"DB_TYPE" env-default:"postgres" validate:"oneof=postgres|sql"`
+		User         string `yaml:"user" env:"DB_USER" env-default:"postgres" validate:"required"`
+		Password     Secret `yaml:"password" env:"DB_PWD" env-default:"password"`
+		DBName       string `yaml:"dbName" env:"DB_NAME" env-default:"database" validate:"required"`
+		ConnPoolSize int    `yaml:"poolSize" env:"DB_POOL_SIZE" env-default:"20" validate:"min=1,max=1000"`
+		Port         uint16 `yaml:"port" env:"DB_PORT" env-default:"5432" validate:"port"`
+		// MinConns connections are kept open even when idle, so that bursts don't wait for new ones
+		MinConns int `yaml:"minConns" env:"DB_MIN_CONNS" env-default:"0" validate:"nonneg"`
+		// Connections are replaced after MaxConnLifetime, plus a random part of MaxConnLifetimeJitter so that
+		// they aren't all replaced at once, and closed once idle for MaxConnIdleTime (down to MinConns)
+		MaxConnLifetime       time.Duration `yaml:"maxConnLifetime" env:"DB_MAX_CONN_LIFETIME" env-default:"1h" validate:"positive"`
+		MaxConnLifetimeJitter time.Duration `yaml:"maxConnLifetimeJitter" env:"DB_MAX_CONN_LIFETIME_JITTER" env-default:"5m" validate:"nonneg"`
+		MaxConnIdleTime       time.Duration `yaml:"maxConnIdleTime" env:"DB_MAX_CONN_IDLE_TIME" env-default:"30m" validate:"positive"`
+		// HealthCheckPeriod is how often the pool closes broken, expired and idle connections, and tops up MinConns
+		HealthCheckPeriod time.Duration `yaml:"healthCheckPeriod" env:"DB_HEALTH_CHECK_PERIOD" env-default:"1m" validate:"positive"`
+		// ConnectTimeout bounds the establishment of each connection, 0 for none
+		ConnectTimeout time.Duration `yaml:"connectTimeout" env:"DB_CONNECT_TIMEOUT" env-default:"5s" validate:"nonneg"`
+		// StartupTimeout is how long startup waits for the DB to be reachable, retrying with backoff; 0 to try only once
+		StartupTimeout time.Duration `yaml:"startupTimeout" env:"DB_STARTUP_TIMEOUT" env-default:"1m" validate:"nonneg"`
+		// MonitorInterval is how often the DB is pinged in the background, readiness reporting the last result
+		MonitorInterval time.Duration `yaml:"monitorInterval" env:"DB_MONITOR_INTERVAL" env-default:"5s" validate:"positive"`
 	} `yaml:"database"`
 	Router struct {
 		// MaxConns     int           `yaml:"maxConns" env:"MAX_CONNS" env-default:"256*1024"` // Let OS decide this
-		ReadTimeout  time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT" env-default:"5s"`
-		WriteTimeout time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT" env-default:"5s"`
+		ReadTimeout time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT" env-default:"5s" reload:"true" validate:"positive"`
+		// ReadHeaderTimeout bounds the time to send headers, protecting against slowloris attacks
+		ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT" env-default:"2s" validate:"positive"`
+		WriteTimeout      time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT" env-default:"5s" reload:"true" validate:"positive"`
+		// IdleTimeout is how long keep-alive connections are kept open between requests
+		IdleTimeout    time.Duration `yaml:"idleTimeout" env:"IDLE_TIMEOUT" env-default:"2m" validate:"positive"`
+		MaxHeaderBytes int           `yaml:"maxHeaderBytes" env:"MAX_HEADER_BYTES" env-default:"1048576" validate:"min=1024"`
+		// StreamTimeout replaces the read and write timeouts on streaming and WebSocket routes, 0 for none
+		StreamTimeout time.Duration `yaml:"streamTimeout" env:"STREAM_TIMEOUT" env-default:"1h" validate:"nonneg"`
+		HTTP2         struct {
+			Enabled              bool   `yaml:"enabled" env:"HTTP2_ENABLED" env-default:"true"`
+			MaxConcurrentStreams uint32 `yaml:"maxConcurrentStreams" env:"HTTP2_MAX_CONCURRENT_STREAMS" env-default:"250" validate:"positive"`
+			MaxReadFrameSize     uint32 `yaml:"maxReadFrameSize" env:"HTTP2_MAX_READ_FRAME_SIZE" env-default:"1048576" validate:"min=16384,max=16777215"`
+		} `yaml:"http2"`
 	} `yaml:"router"`
+	Reload struct {
+		// WatchInterval is how often the YAML config file is checked for changes, 0 to only reload on SIGHUP
+		WatchInterval time.Duration `yaml:"watchInterval" env:"CONFIG_WATCH_INTERVAL" env-default:"0" validate:"nonneg"`
+	} `yaml:"reload"`
+	Secrets struct {
+		// Dir holds one file per secret, named after its env var (e.g. /run/secrets/DB_PWD), as mounted by Docker secrets.
+		// Secrets can also be read from the file designated by their env var suffixed with _FILE (e.g. DB_PWD_FILE)
+		Dir string `yaml:"dir" env:"SECRETS_DIR" env-default:""`
+	} `yaml:"secrets"`
+	Session struct {
+		// Key authenticates the session cookies; a random key is used if it's empty, logging users out on restart
+		Key Secret `yaml:"key" env:"SESSION_KEY" env-default:""`
+	} `yaml:"session"`
+	Admin struct {
+		// Token authenticates requests to the admin endpoints (as a bearer token), which are disabled if it's empty
+		Token Secret `yaml:"token" env:"ADMIN_TOKEN" env-default:""`
+	} `yaml:"admin"`
+	Metrics struct {
+		Enabled bool   `yaml:"enabled" env:"METRICS_ENABLED" env-default:"true"`
+		Path    string `yaml:"path" env:"METRICS_PATH" env-default:"/metrics" validate:"path"`
+		// Listen is the address of a dedicated listener for the metrics, keeping them off the public one;
+		// they're served by the main listener if it's empty, behind the admin token
+		Listen string `yaml:"listen" env:"METRICS_LISTEN" env-default:"127.0.0.1:9090" validate:"addr"`
+	} `yaml:"metrics"`
+	Tracing struct {
+		// Exporter is "none", "stdout", "file" or "otlp" (OTLP/HTTP, e.g. to an OpenTelemetry collector)
+		Exporter     string `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none" validate:"oneof=none|stdout|file|otlp"`
+		File         string `yaml:"file" env:"TRACING_FILE" env-default:"traces.log"`
+		OTLPEndpoint string `yaml:"otlpEndpoint" env:"TRACING_OTLP_ENDPOINT" env-default:"http://localhost:4318" validate:"url"`
+		ServiceName  string `yaml:"serviceName" env:"TRACING_SERVICE_NAME" env-default:"junkyard" validate:"required"`
+		// SampleRatio is the share of the traces started by the server that are recorded,
+		// traces started by a caller follow the caller's choice
+		SampleRatio float64 `yaml:"sampleRatio" env:"TRACING_SAMPLE_RATIO" env-default:"1" reload:"true" validate:"min=0,max=1"`
+	} `yaml:"tracing"`
+	Health struct {
+		// CheckTimeout is how long each dependency is given to answer readiness checks
+		CheckTimeout time.Duration `yaml:"checkTimeout" env:"HEALTH_CHECK_TIMEOUT" env-default:"2s" reload:"true" validate:"positive"`
+	} `yaml:"health"`
+	Shutdown struct {
+		// DrainPeriod is how long the server keeps serving once readiness fails, so that load balancers stop routing to it
+		DrainPeriod time.Duration `yaml:"drainPeriod" env:"SHUTDOWN_DRAIN_PERIOD" env-default:"5s" validate:"nonneg"`
+		// Timeout is how long each component is given to stop
+		Timeout time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT" env-default:"10s" validate:"positive"`
+	} `yaml:"shutdown"`
+	Diagnostics struct {
+		// Listen is the address of the diagnostics listener (pprof, goroutine dumps, GC stats, captures),
+		// which is disabled if empty. It must be a loopback address unless the admin token is set
+		Listen     string `yaml:"listen" env:"DIAGNOSTICS_LISTEN" env-default:"" validate:"addr"`
+		ProfileDir string `yaml:"profileDir" env:"DIAGNOSTICS_PROFILE_DIR" env-default:"profiles"`
+	} `yaml:"diagnostics"`
 	Logto struct {
-		Endpoint  string `yaml:"endpoint" env:"ENDPOINT" env-default:""`
+		Endpoint  string `yaml:"endpoint" env:"ENDPOINT" env-default:"" validate:"url"`
 		AppID     string `yaml:"appID" env:"APP_ID" env-default:""`
-		AppSecret string `yaml:"appSecret" env:"APP_SECRET" env-default:""`
+		AppSecret Secret `yaml:"appSecret" env:"APP_SECRET" env-default:""`
 	} `yaml:"logto"`
+	PubSub struct {
+		// Type is either "memory" (single replica) or "postgres" (LISTEN/NOTIFY, shared across replicas)
+		Type    string `yaml:"type" env:"PUBSUB_TYPE" env-default:"memory" validate:"oneof=memory|postgres"`
+		Channel string `yaml:"channel" env:"PUBSUB_CHANNEL" env-default:"junkyard_pubsub" validate:"required"`
+		// BufferSize is the number of messages a subscriber can lag behind before messages are dropped
+		BufferSize int `yaml:"bufferSize" env:"PUBSUB_BUFFER_SIZE" env-default:"64" validate:"positive"`
+	} `yaml:"pubsub"`
+	Push struct {
+		// Mode is "memory" or "file" to deliver to a local test sink, or "live" to contact APNs and FCM.
+		// The test sinks are only allowed in development mode
+		Mode     string `yaml:"mode" env:"PUSH_MODE" env-default:"memory" validate:"oneof=memory|file|live"`
+		SinkFile string `yaml:"sinkFile" env:"PUSH_SINK_FILE" env-default:"push.log"`
+		APNs     struct {
+			KeyFile string `yaml:"keyFile" env:"APNS_KEY_FILE" env-default:""` // .p8 token signing key
+			KeyID   string `yaml:"keyID" env:"APNS_KEY_ID" env-default:""`
+			TeamID  string `yaml:"teamID" env:"APNS_TEAM_ID" env-default:""`
+			Topic   string `yaml:"topic" env:"APNS_TOPIC" env-default:""` // The app's bundle ID
+			Sandbox bool   `yaml:"sandbox" env:"APNS_SANDBOX" env-default:"false"`
+		} `yaml:"apns"`
+		FCM struct {
+			CredentialsFile string `yaml:"credentialsFile" env:"FCM_CREDENTIALS_FILE" env-default:""` // Service account JSON
+			ProjectID       string `yaml:"projectID" env:"FCM_PROJECT_ID" env-default:""`
+		} `yaml:"fcm"`
+	} `yaml:"push"`
+	Scheduler struct {
+		PollInterval time.Duration `yaml:"pollInterval" env:"SCHEDULER_POLL_INTERVAL" env-default:"10s" validate:"positive"`
+		MaxAttempts  int           `yaml:"maxAttempts" env:"SCHEDULER_MAX_ATTEMPTS" env-default:"5" validate:"positive"`
+		// ReminderOffsets are how long before an event starts its participants are reminded of it
+		ReminderOffsets []time.Duration `yaml:"reminderOffsets" env:"REMINDER_OFFSETS" env-default:"24h,1h" validate:"positive"`
+	} `yaml:"scheduler"`
+	Jobs struct {
+		// Queues maps each queue to its number of concurrent workers
+		Queues       map[string]int `yaml:"queues" env:"JOB_QUEUES" env-default:"default:4,notifications:4,media:2,exports:1" validate:"required,positive"`
+		PollInterval time.Duration  `yaml:"pollInterval" env:"JOB_POLL_INTERVAL" env-default:"1s" validate:"positive"`
+		// Lease is how long a worker owns a job before other workers may claim it, it is renewed while the job runs
+		Lease       time.Duration `yaml:"lease" env:"JOB_LEASE" env-default:"1m" validate:"positive"`
+		MaxAttempts int           `yaml:"maxAttempts" env:"JOB_MAX_ATTEMPTS" env-default:"5" validate:"positive"`
+		// RetryAttempts and RetryDelay control the retries done in-process before a job is handed back to the queue
+		RetryAttempts int           `yaml:"retryAttempts" env:"JOB_RETRY_ATTEMPTS" env-default:"3" validate:"positive"`
+		RetryDelay    time.Duration `yaml:"retryDelay" env:"JOB_RETRY_DELAY" env-default:"200ms" validate:"nonneg"`
+	} `yaml:"jobs"`
+	Outbox struct {
+		PollInterval time.Duration `yaml:"pollInterval" env:"OUTBOX_POLL_INTERVAL" env-default:"1s" validate:"positive"`
+		// BatchSize is the maximum number of events handed to a consumer at each poll
+		BatchSize int `yaml:"batchSize" env:"OUTBOX_BATCH_SIZE" env-default:"100" validate:"min=1,max=10000"`
+		// Retention is how long events are kept once every consumer has handled them
+		Retention time.Duration `yaml:"retention" env:"OUTBOX_RETENTION" env-default:"168h" validate:"positive"`
+		// MaxAttempts is how many times in a row a consumer may fail to handle an event before it's dead-lettered
+		MaxAttempts int `yaml:"maxAttempts" env:"OUTBOX_MAX_ATTEMPTS" env-default:"10" validate:"min=1"`
+	} `yaml:"outbox"`
 }
 
-// LoadConfig reads the config from the config files: values from environmental variables have priority over (i.e. overwrite)
-// values from YAML config files, and values from YAML files overwrite the default values (defined in the Config struct)
+// LoadConfig reads the config from the config files. From lowest to highest priority, values come from the defaults
+// (defined in the Config struct), the YAML config file, the .env file, the process' env vars and the secret files
 func LoadConfig(servCfg *Config) error {
+	_, err := LoadConfigSources(servCfg)
+	return err
+}
+
+// LoadConfigSources reads the config like LoadConfig, and returns the source of each value
+func LoadConfigSources(servCfg *Config) (Sources, error) {
 	// Load .env file
 	envPath := os.Getenv("ENV_PATH")
 	// Default to value if envPath is empty
 	if envPath == "" {
 		envPath = "config.env"
 	}
-	if err := godotenv.Load(envPath); err != nil {
+	if err := loadEnvFile(envPath); err != nil {
 		log.Printf("Warning: could not load .env file %s: %v", envPath, err)
 	}
 
-	// Read env vars (if any)
+	// Read env vars (if any), or the default values
 	if err := cleanenv.ReadEnv(servCfg); err != nil {
 		err = fmt.Errorf("could not read env variables: %s", err.Error())
-		return err
+		return nil, err
 	}
 
 	// Populate CfgPaths should they be needed for debugging or other purposes
@@ -78,47 +243,64 @@ func LoadConfig(servCfg *Config) error {
 	cfgPath := os.Getenv("CFG_PATH")
 	servCfg.CfgPaths.CfgPath = cfgPath
 
-	// Finally read .yaml config files (if any)
+	// Then read .yaml config files (if any), whose values replace the defaults but not the env vars
+	var fromYAML Config
+	var yamlKeys map[string]bool
 	if cfgPath != "" {
-		if err := cleanenv.ReadConfig(cfgPath, servCfg); err != nil {
+		var err error
+		if yamlKeys, err = readYAML(cfgPath, &fromYAML); err != nil {
 			err = fmt.Errorf("could not read config file at %s: %w", cfgPath, err)
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
-}
+	sources := make(Sources)
+	yamlValues := reflect.ValueOf(&fromYAML).Elem()
+	visitFields(servCfg, func(f field) {
+		_, fromEnv := os.LookupEnv(f.Env)
+		switch {
+		case fromEnv && processEnv()[f.Env]:
+			sources[f.Path] = SourceEnvVar
+		case fromEnv:
+			sources[f.Path] = SourceEnvFile
+		case yamlKeys[f.YAML]:
+			f.Value.Set(yamlValues.FieldByIndex(f.Index))
+			sources[f.Path] = SourceYAML
+		default:
+			sources[f.Path] = SourceDefault
+		}
+	})
 
-// Validate checks whether the current config values are valid, returning an error if not
-func (c *Config) Validate() error {
-	if err := ValidateAddress(c.Server.Port, c.Server.Host); err != nil {
-		return err
+	// Secrets stored in files take precedence over the values above
+	if err := resolveSecrets(servCfg, sources, FileSecrets{}, DirSecrets{servCfg.Secrets.Dir}); err != nil {
+		return nil, err
 	}
-	// if err := ValidateAddress(c.Database.Port, c.Database.Host); err != nil {
-	// 	return err
-	// }
-
-	// DevMode is loaded into config by cleanenv, so if the input is not a boolean
-	// cleanenv will use the default value instead
 
-	// Logfile path should be validated by attempting to create the file
-	// But that will be done in startLogger, and would therefore be redundant here
-
-	// TODO: validate DB credentials
-
-	return nil
+	return sources, nil
 }
 
-// ValidateAddress takes as input the port and host, and returns an error if they're not valid
-func ValidateAddress(port uint16, host string) error {
-	if (port < 1024) || (port > 49151) {
-		return fmt.Errorf("port number %d is not a valid port", port)
+// processEnv holds the names of the variables set before the .env file was first loaded
+var processEnv = sync.OnceValue(func() map[string]bool {
+	names := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		names[name] = true
 	}
+	return names
+})
 
-	// Validate host IP
-	if (net.ParseIP(host) == nil) && (host != "localhost") {
-		return fmt.Errorf("given host address: %s is not valid", host)
+// loadEnvFile sets the variables of the .env file at path, except those set in the process' environment,
+// which have priority. Unlike godotenv.Load, variables set by a previous load are overwritten, so that
+// reloads pick up the file's changes
+func loadEnvFile(path string) error {
+	vars, err := godotenv.Read(path)
+	if err != nil {
+		return err
+	}
+	for name, value := range vars {
+		if !processEnv()[name] {
+			os.Setenv(name, value)
+		}
 	}
-
 	return nil
 }
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// field is a leaf of the Config struct, i.e. a setting
+type field struct {
+	Path  string // Path of the Config field, e.g. Server.Port
+	Env   string // e.g. SRV_PORT
+	YAML  string // e.g. server.port
+	Index []int  // Index of the field in Config, for reflect.Value.FieldByIndex
+	Tag   reflect.StructTag
+	Value reflect.Value
+}
+
+// visitFields calls fn with every setting of the config cfg, in declaration order. CfgPaths isn't
+// a setting, as it's only set from the process' environment
+func visitFields(cfg *Config, fn func(f field)) {
+	var walk func(section reflect.Value, index []int, path, yamlPath []string)
+	walk = func(section reflect.Value, index []int, path, yamlPath []string) {
+		for i := 0; i < section.NumField(); i++ {
+			structField := section.Type().Field(i)
+			if len(path) == 0 && structField.Name == "CfgPaths" {
+				continue
+			}
+			fieldIndex := append(index[:len(index):len(index)], i)
+			name := append(path[:len(path):len(path)], structField.Name)
+			yamlName := append(yamlPath[:len(yamlPath):len(yamlPath)], structField.Tag.Get("yaml"))
+			if structField.Type.Kind() == reflect.Struct && structField.Type.Name() == "" {
+				walk(section.Field(i), fieldIndex, name, yamlName)
+				continue
+			}
+			fn(field{
+				Path:  strings.Join(name, "."),
+				Env:   structField.Tag.Get("env"),
+				YAML:  strings.Join(yamlName, "."),
+				Index: fieldIndex,
+				Tag:   structField.Tag,
+				Value: section.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), nil, nil, nil)
+}
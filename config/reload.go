@@ -0,0 +1,160 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Reloader holds the running config, and reloads it on demand (e.g. on SIGHUP) or when the YAML
+// config file changes. Only the fields tagged `reload:"true"` are applied, changes to the others
+// are rejected until restart; subscribers are notified of the changes they're interested in
+type Reloader struct {
+	logger *zap.Logger
+
+	// reloading serializes reloads, so that subscribers are notified of changes in order
+	reloading sync.Mutex
+	// mu guards the running config and the subscribers, and is released before subscribers are notified,
+	// so that they may call Current
+	mu          sync.Mutex
+	running     Config
+	subscribers []func(old, new *Config)
+}
+
+// NewReloader instantiates a Reloader whose running config is cfg, as loaded by LoadConfig
+func NewReloader(cfg Config, parentLogger *zap.Logger) *Reloader {
+	return &Reloader{running: cfg, logger: parentLogger.With(zap.String("component", "config"))}
+}
+
+// Current returns the running config
+func (r *Reloader) Current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// Subscribe calls fn with the value selected by field whenever a reload changes it, e.g.
+//
+//	config.Subscribe(reloader, func(c *config.Config) time.Duration { return c.Health.CheckTimeout }, checker.SetTimeout)
+//
+// Only reloadable fields should be selected, as the others never change
+func Subscribe[T any](r *Reloader, field func(*Config) T, fn func(T)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, func(old, new *Config) {
+		if value := field(new); !reflect.DeepEqual(field(old), value) {
+			fn(value)
+		}
+	})
+}
+
+// Reload loads and validates the config, and applies its reloadable changes.
+// Changes to the other fields are logged and ignored
+func (r *Reloader) Reload() error {
+	var loaded Config
+	if err := LoadConfig(&loaded); err != nil {
+		return err
+	}
+	if err := loaded.Validate(); err != nil {
+		return fmt.Errorf("the new configuration is not valid: %w", err)
+	}
+
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+
+	r.mu.Lock()
+	old := r.running
+	applied, rejected := applyReloadable(&r.running, &loaded)
+	current := r.running
+	subscribers := slices.Clone(r.subscribers)
+	r.mu.Unlock()
+
+	if len(rejected) > 0 {
+		r.logger.Warn("Ignoring configuration changes that require a restart", zap.Strings("fields", rejected))
+	}
+	if len(applied) == 0 {
+		r.logger.Info("Configuration reloaded, no change to apply")
+		return nil
+	}
+
+	for _, notify := range subscribers {
+		notify(&old, &current)
+	}
+	r.logger.Info("Configuration reloaded", zap.Strings("applied", applied))
+	return nil
+}
+
+// Watch reloads the config whenever the YAML config file changes, checking it every interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	path := r.Current().CfgPaths.CfgPath
+	if path == "" || interval <= 0 {
+		return
+	}
+	lastMod := modTime(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		mod := modTime(path)
+		if mod.IsZero() || mod.Equal(lastMod) {
+			// A missing file is most likely being replaced
+			continue
+		}
+		lastMod = mod
+		r.logger.Info("Configuration file changed, reloading", zap.String("path", path))
+		if err := r.Reload(); err != nil {
+			r.logger.Error("Could not reload configuration, keeping the running one", zap.Error(err))
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// applyReloadable copies the reloadable fields of loaded that differ from running into running, returning their
+// names, along with the names of the other fields that differ
+func applyReloadable(running, loaded *Config) (applied, rejected []string) {
+	var walk func(dst, src reflect.Value, path []string)
+	walk = func(dst, src reflect.Value, path []string) {
+		for i := 0; i < dst.NumField(); i++ {
+			field := dst.Type().Field(i)
+			name := append(path[:len(path):len(path)], field.Name)
+			d, s := dst.Field(i), src.Field(i)
+			// Sections are nested anonymous structs, other structs (e.g. time.Time) are values
+			if field.Type.Kind() == reflect.Struct && field.Type.Name() == "" {
+				walk(d, s, name)
+				continue
+			}
+			if reflect.DeepEqual(d.Interface(), s.Interface()) {
+				continue
+			}
+
+			if field.Tag.Get("reload") == "true" {
+				d.Set(s)
+				applied = append(applied, strings.Join(name, "."))
+			} else {
+				rejected = append(rejected, strings.Join(name, "."))
+			}
+		}
+	}
+	walk(reflect.ValueOf(running).Elem(), reflect.ValueOf(loaded).Elem(), nil)
+	return applied, rejected
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// redacted replaces the value of secrets wherever they're printed
+const redacted = "[REDACTED]"
+
+// Secret is a sensitive config value (password, token, key...). It's redacted when printed, logged or
+// marshalled, so that its value only leaves the process where Reveal is called explicitly
+type Secret string
+
+// Reveal returns the value of the secret
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String redacts the secret, unless it's empty so that missing secrets can be told apart
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalYAML() (any, error) {
+	return s.String(), nil
+}
+
+// SecretProvider resolves secrets by name, the name of a secret being the env var of its Config field (e.g. DB_PWD)
+type SecretProvider interface {
+	// Secret returns the value of the secret name, and false if the provider doesn't hold it
+	Secret(name string) (Secret, bool, error)
+}
+
+// EnvSecrets provides the secrets set as env vars
+type EnvSecrets struct{}
+
+func (EnvSecrets) Secret(name string) (Secret, bool, error) {
+	value, ok := os.LookupEnv(name)
+	return Secret(value), ok && value != "", nil
+}
+
+// FileSecrets provides the secrets whose file is designated by the env var suffixed with _FILE
+// (e.g. DB_PWD_FILE=/run/secrets/db_password), as is the convention with Docker and Kubernetes secrets
+type FileSecrets struct{}
+
+func (FileSecrets) Secret(name string) (Secret, bool, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", false, nil
+	}
+	if os.Getenv(name) != "" {
+		return "", false, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+	return readSecretFile(path)
+}
+
+// DirSecrets provides the secrets stored in Dir, one file per secret named after it (e.g. /run/secrets/DB_PWD)
+type DirSecrets struct {
+	Dir string
+}
+
+func (d DirSecrets) Secret(name string) (Secret, bool, error) {
+	if d.Dir == "" {
+		return "", false, nil
+	}
+	secret, ok, err := readSecretFile(filepath.Join(d.Dir, name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	return secret, ok, err
+}
+
+// readSecretFile reads the secret stored in the file at path, ignoring its trailing newline
+func readSecretFile(path string) (Secret, bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	return Secret(strings.TrimRight(string(content), "\r\n")), true, nil
+}
+
+// ResolveSecrets sets every Secret field of cfg to the value of the first provider holding it,
+// fields held by no provider keeping their current value
+func ResolveSecrets(cfg *Config, providers ...SecretProvider) error {
+	return resolveSecrets(cfg, nil, providers...)
+}
+
+// resolveSecrets resolves the secrets like ResolveSecrets, recording the source of those
+// held by a provider in sources if it isn't nil
+func resolveSecrets(cfg *Config, sources Sources, providers ...SecretProvider) error {
+	secretType := reflect.TypeOf(Secret(""))
+	var err error
+	visitFields(cfg, func(f field) {
+		if err != nil || f.Value.Type() != secretType || f.Env == "" {
+			return
+		}
+		for _, provider := range providers {
+			secret, ok, providerErr := provider.Secret(f.Env)
+			if providerErr != nil {
+				err = fmt.Errorf("could not read secret %s: %w", f.Env, providerErr)
+				return
+			}
+			if ok {
+				f.Value.Set(reflect.ValueOf(secret))
+				if sources != nil {
+					sources[f.Path] = secretSource(provider)
+				}
+				return
+			}
+		}
+	})
+	return err
+}
+
+// secretSource returns the source of the secrets held by provider
+func secretSource(provider SecretProvider) Source {
+	if _, ok := provider.(EnvSecrets); ok {
+		return SourceEnvVar
+	}
+	return SourceSecretFile
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Source is where the value of a setting comes from
+type Source string
+
+// Sources of the settings, from lowest to highest priority
+const (
+	SourceDefault    Source = "default"
+	SourceYAML       Source = "yaml"
+	SourceEnvFile    Source = "env file"
+	SourceEnvVar     Source = "env var"
+	SourceSecretFile Source = "secret file"
+)
+
+// Sources maps the path of each Config field (e.g. Server.Port) to the source of its value
+type Sources map[string]Source
+
+// Setting is the value of a config field, formatted as it would be set in an env var, secrets being redacted
+type Setting struct {
+	Field  string `json:"field"`
+	Env    string `json:"env"`
+	YAML   string `json:"yaml"`
+	Value  string `json:"value"`
+	Source Source `json:"source"`
+}
+
+// Settings lists the settings of the config, in declaration order, with their source
+func (c *Config) Settings(sources Sources) []Setting {
+	var settings []Setting
+	visitFields(c, func(f field) {
+		settings = append(settings, Setting{f.Path, f.Env, f.YAML, formatValue(f.Value, f.Tag), sources[f.Path]})
+	})
+	return settings
+}
+
+// formatValue formats v as cleanenv parses it: durations as "1m30s", slices and maps as
+// lists separated by the env-separator of the field (default ","), map entries as key:value
+func formatValue(v reflect.Value, tag reflect.StructTag) string {
+	separator := tag.Get("env-separator")
+	if separator == "" {
+		separator = ","
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = formatScalar(v.Index(i))
+		}
+		return strings.Join(elems, separator)
+	case reflect.Map:
+		var entries []string
+		iter := v.MapRange()
+		for iter.Next() {
+			entries = append(entries, formatScalar(iter.Key())+":"+formatScalar(iter.Value()))
+		}
+		sort.Strings(entries)
+		return strings.Join(entries, separator)
+	}
+	return formatScalar(v)
+}
+
+func formatScalar(v reflect.Value) string {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return fmt.Sprint(v.Interface()) // Secrets are redacted by their String method
+}
+
+// readYAML decodes the YAML file at path into cfg, and returns the keys the file sets (e.g. server.port)
+func readYAML(path string, cfg *Config) (map[string]bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 { // Empty file
+		return nil, nil
+	}
+	if err := doc.Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	var walk func(node *yaml.Node, prefix string)
+	walk = func(node *yaml.Node, prefix string) {
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := prefix + node.Content[i].Value
+			keys[key] = true
+			walk(node.Content[i+1], key+".")
+		}
+	}
+	walk(doc.Content[0], "")
+	return keys, nil
+}
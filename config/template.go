@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExampleYAML generates a YAML config file setting every field to its default value,
+// each documented with its env var and the constraints on its value
+func ExampleYAML() ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{"": root}
+
+	var cfg Config
+	visitFields(&cfg, func(f field) {
+		// Find or create the mappings of the sections of the field
+		parent := root
+		keys := strings.Split(f.YAML, ".")
+		for i, key := range keys[:len(keys)-1] {
+			path := strings.Join(keys[:i+1], ".")
+			section, ok := sections[path]
+			if !ok {
+				section = &yaml.Node{Kind: yaml.MappingNode}
+				sections[path] = section
+				parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, section)
+			}
+			parent = section
+		}
+
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: keys[len(keys)-1], HeadComment: describe(f)}
+		parent.Content = append(parent.Content, key, defaultNode(f))
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by `backend config example`, values are the defaults\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
+
+// describe documents the field f from its tags
+func describe(f field) string {
+	notes := []string{f.Env}
+	if f.Value.Type() == reflect.TypeOf(Secret("")) {
+		notes = append(notes, "secret, better set in "+f.Env+"_FILE or in the secrets directory")
+	}
+	if f.Tag.Get("reload") == "true" {
+		notes = append(notes, "reloadable")
+	}
+	for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "":
+		case "oneof":
+			notes = append(notes, "one of "+strings.ReplaceAll(arg, "|", ", "))
+		case "min":
+			notes = append(notes, "at least "+arg)
+		case "max":
+			notes = append(notes, "at most "+arg)
+		case "positive":
+			notes = append(notes, "greater than 0")
+		case "nonneg":
+			notes = append(notes, "0 or more")
+		case "addr":
+			notes = append(notes, "host:port")
+		case "loglevel":
+			notes = append(notes, "log level")
+		default:
+			notes = append(notes, name)
+		}
+	}
+	return strings.Join(notes, ", ")
+}
+
+// defaultNode encodes the default value of the field f, which is in the env var format (see formatValue)
+func defaultNode(f field) *yaml.Node {
+	def := f.Tag.Get("env-default")
+	separator := f.Tag.Get("env-separator")
+	if separator == "" {
+		separator = ","
+	}
+	switch f.Value.Kind() {
+	case reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		if def != "" {
+			for _, elem := range strings.Split(def, separator) {
+				node.Content = append(node.Content, scalarNode(elem))
+			}
+		}
+		return node
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		if def != "" {
+			for _, entry := range strings.Split(def, separator) {
+				key, value, _ := strings.Cut(entry, ":")
+				node.Content = append(node.Content, scalarNode(key), scalarNode(value))
+			}
+		} else {
+			node.Style = yaml.FlowStyle
+		}
+		return node
+	case reflect.String:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: def}
+	}
+	if def == "" || (def == "0" && f.Value.Type() == reflect.TypeOf(time.Duration(0))) {
+		// Zero durations must have a unit to be decoded from YAML
+		def = fmt.Sprint(reflect.Zero(f.Value.Type()).Interface())
+	}
+	return scalarNode(def)
+}
+
+func scalarNode(value string) *yaml.Node {
+	var node yaml.Node
+	node.SetString(value)
+	node.Tag = "" // Let YAML resolve numbers and booleans
+	return &node
+}
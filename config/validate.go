@@ -0,0 +1,341 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// FieldError is a config value that failed validation. It carries both the env var
+// and the YAML key of the field, so that operators can fix it whichever way they set it
+type FieldError struct {
+	Field   string // Path of the Config field, e.g. Server.Port
+	Env     string // e.g. SRV_PORT
+	YAML    string // e.g. server.port
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", e.YAML, e.Env, e.Message)
+}
+
+// ValidationError lists every invalid config value, so that they can all be fixed in one pass
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d invalid configuration value(s):", len(e.Fields))
+	for _, field := range e.Fields {
+		b.WriteString("\n  - " + field.Error())
+	}
+	return b.String()
+}
+
+// Validate checks the config values, returning a *ValidationError listing all the invalid ones.
+// Fields are checked against the rules of their `validate` tag (see rules), and then against
+// the constraints depending on other fields (e.g. the settings required in production mode)
+func (c *Config) Validate() error {
+	v := &validator{fields: make(map[string]fieldInfo)}
+	visitFields(c, v.check)
+
+	if !c.Server.DevMode {
+		v.require("Logto.Endpoint", c.Logto.Endpoint != "", "is required in production mode")
+		v.require("Logto.AppID", c.Logto.AppID != "", "is required in production mode")
+		v.require("Logto.AppSecret", c.Logto.AppSecret != "", "is required in production mode")
+		// The test sinks would silently swallow every push
+		v.require("Push.Mode", c.Push.Mode == "live", "must be live in production mode")
+		switch c.TLS.Mode {
+		case "files":
+			v.fileExists("Server.CertFile", c.Server.CertFile)
+			v.fileExists("Server.KeyFile", c.Server.KeyFile)
+		case "autocert":
+			v.require("TLS.Domains", len(c.TLS.Domains) > 0, "is required in autocert mode")
+		}
+	}
+	v.require("Database.MinConns", c.Database.MinConns <= c.Database.ConnPoolSize, "must not exceed database.poolSize")
+	if c.TLS.ClientAuth != "" && c.TLS.ClientAuth != "none" {
+		v.fileExists("TLS.ClientCAFile", c.TLS.ClientCAFile)
+	}
+	if c.Tracing.Exporter == "file" {
+		v.require("Tracing.File", c.Tracing.File != "", "is required by the file exporter")
+	}
+	if c.Push.Mode == "live" {
+		v.require("Push.APNs.KeyFile", c.Push.APNs.KeyFile != "" || c.Push.FCM.CredentialsFile != "",
+			"live mode requires the APNs key file, the FCM credentials file, or both")
+		if c.Push.APNs.KeyFile != "" {
+			v.fileExists("Push.APNs.KeyFile", c.Push.APNs.KeyFile)
+		}
+		if c.Push.FCM.CredentialsFile != "" {
+			v.fileExists("Push.FCM.CredentialsFile", c.Push.FCM.CredentialsFile)
+		}
+	}
+	if c.Diagnostics.Listen != "" && c.Admin.Token == "" {
+		v.require("Diagnostics.Listen", IsLoopback(c.Diagnostics.Listen),
+			"must be a loopback address unless the admin token is set")
+	}
+
+	if len(v.errs) > 0 {
+		return &ValidationError{v.errs}
+	}
+	return nil
+}
+
+// ValidateAddress takes as input the port and host, and returns an error if they're not valid.
+// The host may be an IP, a hostname, or empty to designate all interfaces
+func ValidateAddress(port uint16, host string) error {
+	if port == 0 {
+		return fmt.Errorf("port number %d is not a valid port", port)
+	}
+	if host != "" && !validHost(host) {
+		return fmt.Errorf("given host address: %s is not valid", host)
+	}
+	return nil
+}
+
+type fieldInfo struct {
+	env, yaml string
+}
+
+type validator struct {
+	fields map[string]fieldInfo // By Config field path
+	errs   []FieldError
+}
+
+// check indexes the field f and checks it against the rules of its `validate` tag
+func (v *validator) check(f field) {
+	v.fields[f.Path] = fieldInfo{env: f.Env, yaml: f.YAML}
+	tag := f.Tag.Get("validate")
+	if tag == "" {
+		return
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		ruleName, arg, _ := strings.Cut(rule, "=")
+		check, ok := rules[ruleName]
+		if !ok {
+			panic("config: unknown validation rule " + ruleName + " on " + f.Path)
+		}
+		if msg := checkValue(check, f.Value, arg); msg != "" {
+			v.fail(f.Path, msg)
+			return // Further rules would most likely fail for the same reason
+		}
+	}
+}
+
+// checkValue applies check to value, or to each of its elements if it's a slice or a map
+func checkValue(check rule, value reflect.Value, arg string) string {
+	if check.elementwise {
+		switch value.Kind() {
+		case reflect.Slice:
+			for i := 0; i < value.Len(); i++ {
+				if msg := check.fn(value.Index(i), arg); msg != "" {
+					return fmt.Sprintf("element %d %s", i, msg)
+				}
+			}
+			return ""
+		case reflect.Map:
+			iter := value.MapRange()
+			for iter.Next() {
+				if msg := check.fn(iter.Value(), arg); msg != "" {
+					return fmt.Sprintf("%v %s", iter.Key(), msg)
+				}
+			}
+			return ""
+		}
+	}
+	return check.fn(value, arg)
+}
+
+func (v *validator) fail(field, msg string) {
+	info := v.fields[field]
+	v.errs = append(v.errs, FieldError{Field: field, Env: info.env, YAML: info.yaml, Message: msg})
+}
+
+func (v *validator) require(field string, ok bool, msg string) {
+	if !ok {
+		v.fail(field, msg)
+	}
+}
+
+func (v *validator) fileExists(field, path string) {
+	if path == "" {
+		v.fail(field, "is required")
+		return
+	}
+	if info, err := os.Stat(path); err != nil {
+		v.fail(field, fmt.Sprintf("file %s cannot be read: %v", path, err))
+	} else if info.IsDir() {
+		v.fail(field, fmt.Sprintf("%s is a directory", path))
+	}
+}
+
+// rule checks a field's value, returning why it's invalid or an empty string.
+// Elementwise rules are applied to each element of slices and maps
+type rule struct {
+	fn          func(value reflect.Value, arg string) string
+	elementwise bool
+}
+
+// rules are the rules available to `validate` tags, as a comma-separated list (e.g. `validate:"required,host"`).
+// Except for required, empty values are valid
+var rules = map[string]rule{
+	"required": {fn: func(v reflect.Value, _ string) string {
+		if v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+			return "is required"
+		}
+		return ""
+	}},
+	"host": {elementwise: true, fn: func(v reflect.Value, _ string) string {
+		if s := v.String(); s != "" && !validHost(s) {
+			return fmt.Sprintf("%q is neither an IP nor a hostname", s)
+		}
+		return ""
+	}},
+	"port": {fn: func(v reflect.Value, _ string) string {
+		if v.Uint() == 0 {
+			return "must be a port number between 1 and 65535"
+		}
+		return ""
+	}},
+	"addr": {fn: func(v reflect.Value, _ string) string {
+		if s := v.String(); s != "" {
+			return validListenAddr(s)
+		}
+		return ""
+	}},
+	"url": {fn: func(v reflect.Value, _ string) string {
+		if s := v.String(); s != "" {
+			if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Sprintf("%q is not an http(s) URL", s)
+			}
+		}
+		return ""
+	}},
+	"path": {fn: func(v reflect.Value, _ string) string {
+		if s := v.String(); s != "" && !strings.HasPrefix(s, "/") {
+			return fmt.Sprintf("%q must start with /", s)
+		}
+		return ""
+	}},
+	"oneof": {fn: func(v reflect.Value, arg string) string {
+		options := strings.Split(arg, "|")
+		for _, option := range options {
+			if v.String() == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%q must be one of %s", v.String(), strings.Join(options, ", "))
+	}},
+	"loglevel": {fn: func(v reflect.Value, _ string) string {
+		if s := v.String(); s != "" {
+			if _, err := zapcore.ParseLevel(s); err != nil {
+				return fmt.Sprintf("%q is not a log level", s)
+			}
+		}
+		return ""
+	}},
+	"positive": {elementwise: true, fn: func(v reflect.Value, _ string) string {
+		if number(v) <= 0 {
+			return "must be greater than " + format(v, 0)
+		}
+		return ""
+	}},
+	"nonneg": {elementwise: true, fn: func(v reflect.Value, _ string) string {
+		if number(v) < 0 {
+			return "must not be negative"
+		}
+		return ""
+	}},
+	"min": {fn: func(v reflect.Value, arg string) string {
+		bound, _ := strconv.ParseFloat(arg, 64)
+		if number(v) < bound {
+			return "must be at least " + format(v, bound)
+		}
+		return ""
+	}},
+	"max": {fn: func(v reflect.Value, arg string) string {
+		bound, _ := strconv.ParseFloat(arg, 64)
+		if number(v) > bound {
+			return "must be at most " + format(v, bound)
+		}
+		return ""
+	}},
+}
+
+// number returns the value of the numeric v as a float
+func number(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	panic("config: numeric validation rule on non-numeric field of type " + v.Type().String())
+}
+
+// format formats the bound n as a value of the type of v
+func format(v reflect.Value, n float64) string {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(n).String()
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// validHost reports whether host is an IP or an RFC 1123 hostname (e.g. localhost, storage, db.example.com)
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validListenAddr checks a listen address such as "127.0.0.1:9090" or ":80"
+func validListenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Sprintf("%q is not a host:port address", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Sprintf("%q has an invalid port", addr)
+	}
+	if host != "" && !validHost(host) {
+		return fmt.Sprintf("%q has an invalid host", addr)
+	}
+	return ""
+}
+
+// IsLoopback reports whether the listen address addr only accepts local connections
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// defaultConfig returns the config read from the env-default tags, in dev mode
+func defaultConfig(t *testing.T) Config {
+	t.Helper()
+	var cfg Config
+	if err := cleanenv.ReadEnv(&cfg); err != nil {
+		t.Fatalf("reading the default config: %v", err)
+	}
+	cfg.Server.DevMode = true
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		modify     func(cfg *Config)
+		wantFields []string // In the order they're reported
+	}{
+		{"defaults", func(cfg *Config) {}, nil},
+		{"several sections", func(cfg *Config) {
+			cfg.Server.Port = 0
+			cfg.Log.Level = "loud"
+			cfg.Database.Host = "not a host"
+			cfg.Database.ConnPoolSize = 0
+			cfg.Database.MinConns = 5
+			cfg.Router.MaxHeaderBytes = 10
+			cfg.Metrics.Listen = "127.0.0.1"
+			cfg.Tracing.Exporter = "zipkin"
+			cfg.Tracing.SampleRatio = 2
+			cfg.Scheduler.ReminderOffsets = []time.Duration{time.Hour, -time.Hour}
+			cfg.Jobs.Queues = map[string]int{"default": 0}
+			cfg.Outbox.BatchSize = 0
+		}, []string{
+			"Server.Port", "Log.Level", "Database.Host", "Database.ConnPoolSize", "Router.MaxHeaderBytes",
+			"Metrics.Listen", "Tracing.Exporter", "Tracing.SampleRatio", "Scheduler.ReminderOffsets",
+			"Jobs.Queues", "Outbox.BatchSize",
+			// Checked after the fields on their own, against other fields
+			"Database.MinConns",
+		}},
+		{"production mode", func(cfg *Config) {
+			cfg.Server.DevMode = false
+			cfg.TLS.Mode = "autocert"
+			cfg.Push.Mode = "memory"
+		}, []string{"Logto.Endpoint", "Logto.AppID", "Logto.AppSecret", "Push.Mode", "TLS.Domains"}},
+		{"cross-field constraints", func(cfg *Config) {
+			cfg.Tracing.Exporter = "file"
+			cfg.Tracing.File = ""
+			cfg.Push.Mode = "live"
+			cfg.Push.APNs.KeyFile = ""
+			cfg.Push.FCM.CredentialsFile = t.TempDir() // A directory
+			cfg.Diagnostics.Listen = ":6060"
+			cfg.Admin.Token = ""
+		}, []string{"Tracing.File", "Push.FCM.CredentialsFile", "Diagnostics.Listen"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig(t)
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() = %v, want a *ValidationError", err)
+			}
+			var fields []string
+			for _, field := range validationErr.Fields {
+				fields = append(fields, field.Field)
+				if field.Env == "" || field.YAML == "" || field.Message == "" {
+					t.Errorf("field %s reported without its env var, YAML key or message: %+v", field.Field, field)
+				}
+			}
+			if !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("Validate() reported %v, want %v\n%v", fields, tt.wantFields, err)
+			}
+		})
+	}
+}
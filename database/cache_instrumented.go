@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/charm-113c/project-zero/metrics"
+	"github.com/charm-113c/project-zero/tracing"
+)
+
+// instrumentedCache decorates a KeyValCache, recording a span for each operation and counting hits and misses
+type instrumentedCache struct {
+	KeyValCache
+	requests *metrics.Counter
+}
+
+func newInstrumentedCache(cache KeyValCache, requests *metrics.Counter) KeyValCache {
+	return &instrumentedCache{cache, requests}
+}
+
+func (c *instrumentedCache) Set(ctx context.Context, key string, value any, date time.Duration, ttl time.Duration) {
+	ctx, span := tracing.Start(ctx, "cache.set", tracing.KindClient)
+	defer span.End()
+	span.SetAttr("cache.key", key)
+	c.KeyValCache.Set(ctx, key, value, date, ttl)
+}
+
+func (c *instrumentedCache) Get(ctx context.Context, key string) (any, bool) {
+	ctx, span := tracing.Start(ctx, "cache.get", tracing.KindClient)
+	defer span.End()
+	span.SetAttr("cache.key", key)
+
+	value, ok := c.KeyValCache.Get(ctx, key)
+	span.SetAttr("cache.hit", ok)
+	if ok {
+		c.requests.Inc("hit")
+	} else {
+		c.requests.Inc("miss")
+	}
+	return value, ok
+}
+
+func (c *instrumentedCache) Invalidate(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "cache.invalidate", tracing.KindClient)
+	defer span.End()
+	span.SetAttr("cache.key", key)
+	err := c.KeyValCache.Invalidate(ctx, key)
+	span.RecordError(err)
+	return err
+}
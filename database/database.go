@@ -2,43 +2,96 @@ package database
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/metrics"
+	"github.com/charm-113c/project-zero/util"
 	"go.uber.org/zap"
 )
 
+// ErrNotFound is returned by StorageHandlers when the targeted record does not exist
+var ErrNotFound = errors.New("database: record not found")
+
+// ErrLeaseLost is returned when acting on a queued job whose lease expired and which was claimed again since
+var ErrLeaseLost = errors.New("database: job lease lost to another claim")
+
 // Storage struct is the database component; its Conns field represents the connection pool
 // it establishes with the actual DB: Conns must therefore implement all the necessary DB operations
 // and those operations are defined in the StorageHandler interfaces.
+// Every StorageHandler method takes the context of the request it serves, so that queries are
+// cancelled with it and DB logs carry its request ID (see logging.RequestIDFromContext).
 type Storage struct {
 	Conns struct {
 		Close          GracefulShutdown
+		Health         HealthChecker
+		Stats          StatsReporter
 		AccTableOps    AccountStorageHandler
 		EvTableOps     EventStorageHandler
 		SocialTableOps SocialStorageHandler
 		MapTableOps    MapStorageHandler
+		NotifTableOps  NotificationStorageHandler
+		DeviceTableOps DeviceStorageHandler
+		JobTableOps    ScheduleStorageHandler
+		QueueTableOps  QueueStorageHandler
+		OutboxTableOps OutboxStorageHandler
 	}
 	Cache  KeyValCache
 	logger *zap.Logger
+	// transactor runs the transactions of WithTx, and is bound to the transaction in the Storage it hands over
+	transactor interface {
+		withTx(ctx context.Context, stg Storage, fn func(tx Storage) error) error
+	}
+}
+
+// WithTx runs fn in a transaction, handing it a Storage whose StorageHandlers all run their queries in it,
+// e.g. so that a record and those referring to it are created atomically. The transaction is committed if fn
+// returns nil, and rolled back otherwise, fn's error being returned.
+// Transactions are serializable: should one be aborted by a concurrent one (serialization failure, deadlock),
+// fn is run again in a new transaction, up to 5 attempts. fn must therefore be safe to rerun, i.e. have no
+// side effects other than through tx. Calling WithTx on tx runs fn within the same transaction, in a savepoint.
+// tx's Conns.Close, Conns.Health and Conns.Stats are nil, as they concern the connection pool as a whole
+func (s Storage) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+	if s.transactor == nil {
+		return errors.New("database: the storage does not support transactions")
+	}
+	return s.transactor.withTx(ctx, s, fn)
 }
 
 // TODO: create Redis cache
 
 // KeyValCache caches the most read fields from the database. So doing it allows
 // leveraging the high performance of Golang routers (by reducing interactions with
-// the DB, often the bottleneck of a system)
+// the DB, often the bottleneck of a system).
+// Like StorageHandlers' methods, its operations take the context of the request they serve
 type KeyValCache interface {
-	Set(key string, value any, date time.Duration, ttl time.Duration)
-	Get(key string) (any, bool)
-	Invalidate(key string) error // Invalidate a cache entry
+	Set(ctx context.Context, key string, value any, date time.Duration, ttl time.Duration)
+	Get(ctx context.Context, key string) (any, bool)
+	Invalidate(ctx context.Context, key string) error // Invalidate a cache entry
+	Ping(ctx context.Context) error                   // Check that the cache is reachable
 	Close() error
 }
 
 // StartStorage initializes and connects to the DB and also instantiates a DB-specific logger.
-// It is designed with flexibility in mind, and abstracts away from the implementation of teh DB
-func StartStorage(ctx context.Context, cfg config.Config, storage *Storage, parentLogger *zap.Logger) error {
+// It is designed with flexibility in mind, and abstracts away from the implementation of teh DB.
+// As the DB may still be starting (e.g. alongside the server in docker compose), it is waited for
+// up to cfg.Database.StartupTimeout, unless the error can't be fixed by retrying (e.g. wrong password).
+// The connection pool and cache statistics are registered in reg
+func StartStorage(ctx context.Context, cfg config.Config, storage *Storage, reg *metrics.Registry, parentLogger *zap.Logger) error {
+	return startStorage(ctx, cfg, storage, reg, parentLogger, true)
+}
+
+// ConnectStorage connects to the DB like StartStorage, but leaves its schema untouched, for the tools
+// which inspect the DB and mustn't change it (e.g. `backend db ping`). Should the schema be outdated,
+// the StorageHandlers relying on the missing tables fail
+func ConnectStorage(ctx context.Context, cfg config.Config, storage *Storage, reg *metrics.Registry, parentLogger *zap.Logger) error {
+	return startStorage(ctx, cfg, storage, reg, parentLogger, false)
+}
+
+func startStorage(ctx context.Context, cfg config.Config, storage *Storage, reg *metrics.Registry, parentLogger *zap.Logger, migrate bool) error {
 	// var storage Storage
 
 	// Launch DB logger
@@ -53,7 +106,10 @@ func StartStorage(ctx context.Context, cfg config.Config, storage *Storage, pare
 	dbLogger.Info("Database type: " + cfg.Database.Type)
 	switch cfg.Database.Type {
 	case "postgres", "sql":
-		if err := startPostgres(ctx, cfg, storage); err != nil {
+		err := util.Retry(ctx, dbLogger, startupPolicy(cfg.Database.StartupTimeout), func(ctx context.Context) error {
+			return startPostgres(ctx, cfg, storage, reg, migrate)
+		})
+		if err != nil {
 			return fmt.Errorf("could not start the DB: %w", err)
 		}
 	default:
@@ -62,33 +118,313 @@ func StartStorage(ctx context.Context, cfg config.Config, storage *Storage, pare
 
 	// TODO: implement cache
 	dbLogger.Warn("Cache has not yet been implemented")
+	// The cache's metrics are only exposed once there's one, rather than as a counter stuck at 0
+	if storage.Cache != nil {
+		cacheRequests := reg.NewCounter("cache_requests_total", "Number of cache lookups, by result (hit or miss).", "result")
+		storage.Cache = newInstrumentedCache(storage.Cache, cacheRequests)
+	}
 
 	return nil
 }
 
+// startupPolicy retries the DB startup until timeout, or only tries once if it's 0
+func startupPolicy(timeout time.Duration) util.RetryPolicy {
+	policy := util.RetryPolicy{BaseDelay: 500 * time.Millisecond, MaxDelay: 10 * time.Second, Deadline: timeout}
+	if timeout == 0 {
+		policy.Attempts = 1
+	}
+	return policy
+}
+
 // GracefulShutdown is the interface for closing connections with the Storage (e.g. with postgres)
 type GracefulShutdown interface {
 	CloseConns() error
 }
 
+// HealthChecker is the interface for checking that the Storage is reachable, e.g. for readiness probes
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+}
+
+// StatsReporter is the interface for reporting the state of the connection pool and of the DB, e.g. to operators
+type StatsReporter interface {
+	PoolStats() PoolStats
+	// ServerStats queries the DB for the state of its connections and tables, as seen by the DB server
+	ServerStats(ctx context.Context) (ServerStats, error)
+}
+
+// PoolStats is a snapshot of the connection pool
+type PoolStats struct {
+	MaxConns          int32 `json:"maxConns"`
+	TotalConns        int32 `json:"totalConns"`
+	IdleConns         int32 `json:"idleConns"`
+	AcquiredConns     int32 `json:"acquiredConns"`
+	ConstructingConns int32 `json:"constructingConns"`
+	// Acquisitions since the pool was created; empty ones had to wait for a connection to be released or created
+	AcquireCount         int64         `json:"acquireCount"`
+	EmptyAcquireCount    int64         `json:"emptyAcquireCount"`
+	CanceledAcquireCount int64         `json:"canceledAcquireCount"`
+	AcquireDuration      time.Duration `json:"acquireDuration"`
+	NewConnsCount        int64         `json:"newConnsCount"`
+}
+
+// ServerStats is a snapshot of the DB, as seen by the DB server. As such, it includes the connections of every replica
+type ServerStats struct {
+	Version        string           `json:"version"`
+	MaxConnections int              `json:"maxConnections"`
+	Connections    map[string]int   `json:"connections"` // To this DB, by state (e.g. "active", "idle")
+	SizeBytes      int64            `json:"sizeBytes"`
+	TableRows      map[string]int64 `json:"tableRows"` // Estimated
+}
+
 // AccountStorageHandler is responsible for defining the operations on the User table
 type AccountStorageHandler interface {
-	CreateAccount()
+	// CreateAccount stores the account, unless one with the same ID exists, which is left untouched
+	CreateAccount(ctx context.Context, a Account) error
+	// ListAccounts returns up to limit accounts ordered by ID, starting after afterID (empty for the first page)
+	ListAccounts(ctx context.Context, afterID string, limit int) ([]Account, error)
+	// SetAccountDisabled disables the account, or enables it again; disabled users are treated as signed out
+	SetAccountDisabled(ctx context.Context, userID string, disabled bool) error
+	// IsAccountDisabled reports whether the account is disabled, users without an account not being disabled
+	IsAccountDisabled(ctx context.Context, userID string) (bool, error)
+}
+
+// Account is a user's account; its ID is the user's ID at the identity provider (Logto)
+type Account struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	DisabledAt *time.Time `json:"disabledAt,omitempty"`
 }
 
 // EventStorageHandler is responsible for defining the operations on the Event table
 type EventStorageHandler interface {
-	CreateEvent()
+	// CreateEvent stores the event, generating its ID if it's empty, and returns it as stored.
+	// Should an event with the same ID exist, it's left untouched and returned instead
+	CreateEvent(ctx context.Context, ev Event) (Event, error)
+	GetEvent(ctx context.Context, eventID string) (Event, error)
+	// UpdateEvent replaces the title, description, start time and location of the event, returning it as stored
+	UpdateEvent(ctx context.Context, ev Event) (Event, error)
+	// JoinEvent adds the user to the participants of the event, doing nothing if they already are
+	JoinEvent(ctx context.Context, eventID, userID string) error
+	ListEventParticipants(ctx context.Context, eventID string) ([]string, error)
+}
+
+// Event is an event created by a user, which other users can join
+type Event struct {
+	ID          string    `json:"id"`
+	CreatorID   string    `json:"creatorID"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	StartsAt    time.Time `json:"startsAt"`
+	Latitude    float64   `json:"latitude"`
+	Longitude   float64   `json:"longitude"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Participation is a user taking part in an event
+type Participation struct {
+	EventID  string    `json:"eventID"`
+	UserID   string    `json:"userID"`
+	JoinedAt time.Time `json:"joinedAt"`
 }
 
 // SocialStoragesHandler is responsible for defining the operations on the tables that
 // relate to social interactions between users
 type SocialStorageHandler interface {
-	FollowUser()
+	// FollowUser makes the follower follow the followee, doing nothing if it already does
+	FollowUser(ctx context.Context, followerID, followeeID string) error
+}
+
+// Follow is a user following another
+type Follow struct {
+	FollowerID string    `json:"followerID"`
+	FolloweeID string    `json:"followeeID"`
+	CreatedAt  time.Time `json:"createdAt"`
 }
 
 // MapStorageHandler is responsible for defining the operations on the tables that
 // relate to social interactions between users
 type MapStorageHandler interface {
-	GetMap()
+	GetMap(ctx context.Context)
+}
+
+// NotificationStorageHandler is responsible for defining the operations on the tables that
+// relate to users' notification inbox and notification preferences
+type NotificationStorageHandler interface {
+	// InsertNotification stores n in its recipient's inbox. Should an unread notification with the same
+	// group key already exist, n is merged into it instead; the stored notification is returned either way,
+	// along with false if n's actors were all part of it already (e.g. n was inserted before)
+	InsertNotification(ctx context.Context, n Notification) (Notification, bool, error)
+	ListNotifications(ctx context.Context, recipientID string, unreadOnly bool, limit int) ([]Notification, error)
+	MarkNotificationRead(ctx context.Context, recipientID string, notificationID int64) error
+	MarkAllNotificationsRead(ctx context.Context, recipientID string) error
+	// GetNotificationPrefs returns the notification types the user has explicitly set, mapped to whether they're enabled
+	GetNotificationPrefs(ctx context.Context, userID string) (map[string]bool, error)
+	// SetNotificationPrefs saves whether each of the given notification types is enabled, all of them or none
+	SetNotificationPrefs(ctx context.Context, userID string, prefs map[string]bool) error
+}
+
+// Notification is a single entry of a user's notification inbox. Similar notifications
+// (same recipient and group key) are grouped while unread, so that a single entry can
+// represent multiple actors, e.g. several users joining the same event ("5 people joined your event")
+type Notification struct {
+	ID          int64      `json:"id"`
+	RecipientID string     `json:"recipientID"`
+	Type        string     `json:"type"`
+	GroupKey    string     `json:"-"`
+	SubjectID   string     `json:"subjectID,omitempty"` // What the notification is about, e.g. an event ID
+	ActorIDs    []string   `json:"actorIDs"`            // Who triggered the notification
+	Count       int        `json:"count"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// DeviceStorageHandler is responsible for defining the operations on the table
+// holding the device tokens that push notifications are sent to
+type DeviceStorageHandler interface {
+	// RegisterDevice saves the token for the user, taking it over if it was registered by another user
+	RegisterDevice(ctx context.Context, d Device) error
+	UnregisterDevice(ctx context.Context, userID, token string) error
+	ListDevices(ctx context.Context, userID string) ([]Device, error)
+	// DeleteDevice removes the token whoever it belongs to, e.g. when the push service reports it as dead
+	DeleteDevice(ctx context.Context, token string) error
+}
+
+// Device is a device token registered by a user to receive push notifications
+type Device struct {
+	UserID    string    `json:"-"`
+	Platform  string    `json:"platform"` // "ios" or "android"
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ScheduleStorageHandler is responsible for defining the operations on the table of scheduled jobs.
+// Jobs are persisted so that they survive restarts, and are run by a single replica each
+type ScheduleStorageHandler interface {
+	// ReplaceJobs atomically replaces all the jobs of kind in groupKey with jobs, e.g. to reschedule an event's reminders
+	ReplaceJobs(ctx context.Context, kind, groupKey string, jobs []ScheduledJob) error
+	// DueJobs returns up to limit jobs whose RunAt is before now, earliest first
+	DueJobs(ctx context.Context, now time.Time, limit int) ([]ScheduledJob, error)
+	// RunJobExclusively runs fn on the job, provided no other replica is running it and it's still due.
+	// The job is removed if fn succeeds, and pushed back to retryAt otherwise (or removed after
+	// maxAttempts). fn is handed a ScheduleStorageHandler running its queries in the job's transaction, so that
+	// the jobs it (re)schedules are committed along with the job's outcome, or rolled back if it fails.
+	// It returns false if the job was skipped
+	RunJobExclusively(ctx context.Context, jobID int64, maxAttempts int, retryAt func(attempts int) time.Time, fn func(ctx context.Context, job ScheduledJob, tx ScheduleStorageHandler) error) (bool, error)
+}
+
+// ScheduledJob is a job to run at a given time. Jobs are grouped (e.g. by event)
+// so that all the jobs concerning the same thing can be rescheduled at once
+type ScheduledJob struct {
+	ID        int64
+	Kind      string
+	GroupKey  string
+	RunAt     time.Time
+	Payload   []byte // JSON, its content depends on Kind
+	Attempts  int
+	LastError string
+}
+
+// QueueStorageHandler is responsible for defining the operations on the durable job queue.
+// Workers claim jobs by leasing them: a job whose lease expires (e.g. its worker crashed)
+// can be claimed again by any worker
+type QueueStorageHandler interface {
+	EnqueueJob(ctx context.Context, job QueuedJob) (int64, error)
+	// ClaimJob leases the next available job of queue for lease, returning false if there is none
+	ClaimJob(ctx context.Context, queue string, lease time.Duration) (QueuedJob, bool, error)
+	// The methods below take the lease token of the claim, and return ErrLeaseLost if the lease expired
+	// and the job was claimed again since, the new claim owning the job
+	ExtendJobLease(ctx context.Context, jobID int64, leaseToken string, lease time.Duration) error
+	CompleteJob(ctx context.Context, jobID int64, leaseToken string) error
+	// RetryJob releases the job and makes it available again at runAt, with args replacing its args
+	RetryJob(ctx context.Context, jobID int64, leaseToken string, runAt time.Time, args []byte, lastError string) error
+	// DeadLetterJob moves the job to the dead-letter table, where it's kept for inspection with args replacing its args
+	DeadLetterJob(ctx context.Context, jobID int64, leaseToken string, args []byte, lastError string) error
+}
+
+// QueuedJob is a job waiting in, or claimed from, the durable job queue
+type QueuedJob struct {
+	ID          int64
+	Queue       string
+	Kind        string
+	Args        []byte // JSON, its content depends on Kind
+	Attempts    int    // Number of times the job was handed back to the queue after failing
+	MaxAttempts int
+	RunAt       time.Time
+	LastError   string
+	CreatedAt   time.Time
+	LeaseToken  string // Identifies the claim, set by ClaimJob
+}
+
+// OutboxStorageHandler is responsible for defining the operations on the outbox, to which the other
+// StorageHandlers record a DomainEvent in the same transaction as each change they make, so that
+// changes and their events are either both saved or neither is. Events are read back by consumers,
+// each keeping its offset in the outbox (see outbox.Relay)
+type OutboxStorageHandler interface {
+	// ConsumeOutbox hands up to limit events following the consumer's offset to handle, in order,
+	// and moves the offset past those handled, stopping at the first error (which is returned).
+	// An event that failed maxAttempts times in a row, or whose error is marked util.Permanent, is instead
+	// moved to the consumer's dead letters and the offset past it, so that it doesn't block the consumer.
+	// Consumers are created at their first call. Each consumer is run by one replica at a time:
+	// should another be running it, nothing is handed over
+	ConsumeOutbox(ctx context.Context, consumer string, limit, maxAttempts int, handle func(ctx context.Context, ev DomainEvent) error) (OutboxBatch, error)
+	// OutboxOffsets returns the offset of every consumer, along with its lag
+	OutboxOffsets(ctx context.Context) ([]OutboxOffset, error)
+	// PruneOutbox deletes the events older than retention which every consumer has handled
+	PruneOutbox(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// Types of the domain events recorded in the outbox, with the type of their payload
+const (
+	AccountCreated  = "account.created"  // Account
+	AccountDisabled = "account.disabled" // Account
+	AccountEnabled  = "account.enabled"  // Account
+	EventCreated    = "event.created"    // Event
+	EventUpdated    = "event.updated"    // Event
+	EventJoined     = "event.joined"     // Participation
+	UserFollowed    = "user.followed"    // Follow
+)
+
+// DomainEvent is a change recorded in the outbox. Events are ordered by their TxID, and then by their ID
+type DomainEvent struct {
+	ID          int64           `json:"id"`
+	TxID        int64           `json:"txID"` // The transaction that recorded the event
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregateID"` // The ID of what changed, e.g. the account's
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// Decode decodes the payload of the event into v, whose type depends on the event's
+func (ev DomainEvent) Decode(v any) error {
+	return json.Unmarshal(ev.Payload, v)
+}
+
+// OutboxOffset is the position of a consumer in the outbox: the last event it handled
+type OutboxOffset struct {
+	Consumer    string    `json:"consumer"`
+	TxID        int64     `json:"txID"`
+	EventID     int64     `json:"eventID"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Lag         int64     `json:"lag"`                 // Number of events left to handle
+	Failures    int       `json:"failures"`            // Number of times in a row the next event failed
+	LastError   string    `json:"lastError,omitempty"` // The last failure of the next event
+	DeadLetters int64     `json:"deadLetters"`         // Number of events the consumer gave up on
+}
+
+// OutboxBatch is the outcome of ConsumeOutbox
+type OutboxBatch struct {
+	Handled      int                // Number of events the offset moved past, dead letters included
+	DeadLettered []OutboxDeadLetter // The events given up on
+}
+
+// OutboxDeadLetter is an event a consumer gave up on, and the error it last failed with
+type OutboxDeadLetter struct {
+	Event    DomainEvent
+	Attempts int
+	Error    string
 }
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/metrics"
+	"go.uber.org/zap"
+)
+
+// Monitor pings the DB in the background, so that a lost connection is noticed and logged
+// even when no queries are made, rather than when requests start failing.
+// Readiness reports the result of the last ping (see Check), so that probes don't wait on the DB
+type Monitor struct {
+	db       HealthChecker
+	interval time.Duration
+	up       *metrics.Gauge
+	logger   *zap.Logger
+
+	mu        sync.Mutex
+	lastErr   error
+	downSince time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewMonitor instantiates a Monitor pinging db every interval; the DB is deemed up until the first ping
+func NewMonitor(db HealthChecker, interval time.Duration, reg *metrics.Registry, parentLogger *zap.Logger) *Monitor {
+	m := &Monitor{
+		db:       db,
+		interval: interval,
+		up:       reg.NewGauge("database_up", "Whether the last background ping of the DB succeeded (1) or not (0)."),
+		logger:   parentLogger.With(zap.String("component", "database monitor")),
+	}
+	m.up.Set(1)
+	return m
+}
+
+// Start pings the DB until Stop is called or ctx is cancelled
+func (m *Monitor) Start(ctx context.Context) {
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		m.logger.Info("Database monitor started", zap.Duration("interval", m.interval))
+		for {
+			select {
+			case <-ctx.Done():
+				m.logger.Info("Database monitor stopped")
+				return
+			case <-ticker.C:
+				m.ping(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops pinging the DB, waiting for the ongoing ping to complete
+func (m *Monitor) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+// ping checks the DB, each ping being given up to an interval to complete
+func (m *Monitor) ping(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, m.interval)
+	err := m.db.Ping(pingCtx)
+	cancel()
+	if ctx.Err() != nil {
+		// Interrupted by Stop, which says nothing about the DB
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wasUp := m.lastErr == nil
+	m.lastErr = err
+
+	// Log transitions only, the DB being pinged every few seconds
+	switch {
+	case err != nil && wasUp:
+		m.downSince = time.Now()
+		m.up.Set(0)
+		m.logger.Error("Connection to DB lost", zap.Error(err))
+	case err == nil && !wasUp:
+		m.up.Set(1)
+		m.logger.Info("Connection to DB restored", zap.Duration("downtime", time.Since(m.downSince).Round(time.Millisecond)))
+	}
+}
+
+// Check implements health.Check, failing if the last ping did
+func (m *Monitor) Check(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lastErr != nil {
+		return fmt.Errorf("DB unreachable since %s: %w", m.downSince.Format(time.RFC3339), m.lastErr)
+	}
+	return nil
+}
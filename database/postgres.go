@@ -3,24 +3,22 @@ package database
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
 
 	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/metrics"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // startPostgres establishes a connection pool with the DB designated through the
 // config, and then populates the Conns field of the Storage struct, essentially
-// implementing the Storage.Conns StorageHandler interfaces
-func startPostgres(ctx context.Context, cfg config.Config, stg *Storage) error {
-	// Construct DB URL for connection
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%d/%s",
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.DBName,
-	)
-	poolCfg, err := pgxpool.ParseConfig(connStr)
+// implementing the Storage.Conns StorageHandler interfaces. The schema is migrated if migrate is set
+func startPostgres(ctx context.Context, cfg config.Config, stg *Storage, reg *metrics.Registry, migrate bool) error {
+	poolCfg, err := pgxpool.ParseConfig(PostgresURL(cfg))
 	if err != nil {
 		return fmt.Errorf("error parsing connection string: %w", err)
 	}
@@ -31,6 +29,18 @@ func startPostgres(ctx context.Context, cfg config.Config, stg *Storage) error {
 	} else {
 		poolCfg.MaxConns = int32(cfg.Database.ConnPoolSize)
 	}
+	// Broken connections are replaced transparently: idle ones are pinged when acquired,
+	// and closed by the periodic health check
+	poolCfg.MinConns = int32(cfg.Database.MinConns)
+	poolCfg.MaxConnLifetime = cfg.Database.MaxConnLifetime
+	poolCfg.MaxConnLifetimeJitter = cfg.Database.MaxConnLifetimeJitter
+	poolCfg.MaxConnIdleTime = cfg.Database.MaxConnIdleTime
+	poolCfg.HealthCheckPeriod = cfg.Database.HealthCheckPeriod
+	poolCfg.ConnConfig.ConnectTimeout = cfg.Database.ConnectTimeout
+
+	// Record a span for every query, log it along with the request and trace IDs of its context,
+	// and measure the time spent waiting for connections
+	poolCfg.ConnConfig.Tracer = pgTracers{pgSpanTracer{}, &pgLogTracer{logger: stg.logger}, newPgMetricsTracer(reg)}
 
 	// Create connection pool
 	connPool, err := pgxpool.NewWithConfig(ctx, poolCfg)
@@ -39,43 +49,165 @@ func startPostgres(ctx context.Context, cfg config.Config, stg *Storage) error {
 	}
 
 	stg.logger.Info("DB connection pool created")
+	registerPoolMetrics(reg, connPool)
+	// The pool is closed on failure, as the startup may be retried with a new one
 	if err = connPool.Ping(ctx); err != nil {
+		connPool.Close()
 		return fmt.Errorf("connection to DB not established, ping query to DB failed: %w", err)
 	}
 	stg.logger.Info("Connection to DB established")
 
+	if migrate {
+		if err = migratePostgres(ctx, connPool); err != nil {
+			connPool.Close()
+			return fmt.Errorf("could not create DB schema: %w", err)
+		}
+		stg.logger.Info("DB schema is up to date")
+	}
+
 	// Finally, assign the different handlers to Storage
-	stg.Conns.AccTableOps = &pgAccountHandler{pool: connPool}
-	stg.Conns.EvTableOps = &pgEventHandler{pool: connPool}
-	stg.Conns.SocialTableOps = &pgSocialHandler{pool: connPool}
-	stg.Conns.MapTableOps = &pgMapHandler{pool: connPool}
+	stg.Conns.Close = &pgPoolHandler{pool: connPool}
+	stg.Conns.Health = &pgPoolHandler{pool: connPool}
+	stg.Conns.Stats = &pgPoolHandler{pool: connPool}
+	stg.bindPostgres(connPool)
+	stg.transactor = &pgTransactor{pool: connPool, logger: stg.logger}
 
 	return nil
 }
 
+// PostgresURL constructs the connection URL of the Postgres DB designated through the config.
+// It is exported for components that need their own dedicated connection (e.g. LISTEN/NOTIFY)
+func PostgresURL(cfg config.Config) string {
+	// Credentials are escaped, as secrets may contain any character
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.Database.User, cfg.Database.Password.Reveal()),
+		Host:   net.JoinHostPort(cfg.Database.Host, strconv.Itoa(int(cfg.Database.Port))),
+		Path:   "/" + cfg.Database.DBName,
+	}
+	return u.String()
+}
+
+// pgQuerier is implemented by both the connection pool and its transactions, so that the
+// StorageHandlers can run their queries through either (see Storage.WithTx)
+type pgQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	// Begin starts a transaction, or a savepoint when called on a transaction
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// bindPostgres assigns to stg the StorageHandlers running their queries through db
+func (stg *Storage) bindPostgres(db pgQuerier) {
+	stg.Conns.AccTableOps = &pgAccountHandler{db: db}
+	stg.Conns.EvTableOps = &pgEventHandler{db: db}
+	stg.Conns.SocialTableOps = &pgSocialHandler{db: db}
+	stg.Conns.MapTableOps = &pgMapHandler{db: db}
+	stg.Conns.NotifTableOps = &pgNotificationHandler{db: db}
+	stg.Conns.DeviceTableOps = &pgDeviceHandler{db: db}
+	stg.Conns.JobTableOps = &pgScheduleHandler{db: db}
+	stg.Conns.QueueTableOps = &pgQueueHandler{db: db}
+	stg.Conns.OutboxTableOps = &pgOutboxHandler{db: db}
+}
+
+// pgPoolHandler populates the Storage.Conns.Close, Storage.Conns.Health and Storage.Conns.Stats fields,
+// and its methods concern the connection pool as a whole
+type pgPoolHandler struct {
+	pool *pgxpool.Pool
+}
+
+// Ping acquires a connection and checks that the DB answers through it
+func (p *pgPoolHandler) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+// PoolStats returns a snapshot of the connection pool
+func (p *pgPoolHandler) PoolStats() PoolStats {
+	stat := p.pool.Stat()
+	return PoolStats{
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		IdleConns:            stat.IdleConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDuration:      stat.AcquireDuration(),
+		NewConnsCount:        stat.NewConnsCount(),
+	}
+}
+
+// ServerStats queries the statistics views of Postgres
+func (p *pgPoolHandler) ServerStats(ctx context.Context) (ServerStats, error) {
+	stats := ServerStats{Connections: make(map[string]int), TableRows: make(map[string]int64)}
+	err := p.pool.QueryRow(ctx, `
+		SELECT current_setting('server_version'), current_setting('max_connections')::INTEGER,
+			pg_database_size(current_database())`,
+	).Scan(&stats.Version, &stats.MaxConnections, &stats.SizeBytes)
+	if err != nil {
+		return stats, fmt.Errorf("error reading DB settings: %w", err)
+	}
+
+	rows, err := p.pool.Query(ctx, `
+		SELECT COALESCE(state, 'unknown'), count(*) FROM pg_stat_activity
+		WHERE datname = current_database() GROUP BY 1`)
+	if err != nil {
+		return stats, fmt.Errorf("error reading DB connections: %w", err)
+	}
+	var state string
+	var count int
+	_, err = pgx.ForEachRow(rows, []any{&state, &count}, func() error {
+		stats.Connections[state] = count
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("error reading DB connections: %w", err)
+	}
+
+	rows, err = p.pool.Query(ctx, `SELECT relname, n_live_tup FROM pg_stat_user_tables`)
+	if err != nil {
+		return stats, fmt.Errorf("error reading table statistics: %w", err)
+	}
+	var table string
+	var live int64
+	_, err = pgx.ForEachRow(rows, []any{&table, &live}, func() error {
+		stats.TableRows[table] = live
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("error reading table statistics: %w", err)
+	}
+	return stats, nil
+}
+
+// CloseConns closes the connection pool, waiting for the connections in use to be released
+func (p *pgPoolHandler) CloseConns() error {
+	p.pool.Close()
+	return nil
+}
+
 // pgAccountHandler populates the Storage.Conns.AccountStorageHandler field,
 // and its methods implement the AccountStorageHandler interface
 type pgAccountHandler struct {
-	pool *pgxpool.Pool
+	db pgQuerier
 }
 
 // pgEventHandler populates the Storage.Conns.EventStorageHandler field,
 // and its methods implement the EventStorageHandler interface
 type pgEventHandler struct {
-	pool *pgxpool.Pool
+	db pgQuerier
 }
 
 // pgSocialHandler populates the Storage.Conns.SocialStoragesHandler field,
 // and its methods implement the SocialStoragesHandler interface
 type pgSocialHandler struct {
-	pool *pgxpool.Pool
+	db pgQuerier
 }
 
 type pgMapHandler struct {
-	pool *pgxpool.Pool
+	db pgQuerier
 }
 
-func (usrTable *pgAccountHandler) CreateAccount() {}
-func (evTable *pgEventHandler) CreateEvent()      {}
-func (socTable *pgSocialHandler) FollowUser()     {}
-func (socTable *pgMapHandler) GetMap()            {}
+func (socTable *pgMapHandler) GetMap(ctx context.Context) {}
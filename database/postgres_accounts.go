@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const accountColumns = `id, username, email, created_at, disabled_at`
+
+func (usrTable *pgAccountHandler) CreateAccount(ctx context.Context, a Account) error {
+	return pgx.BeginFunc(ctx, usrTable.db, func(tx pgx.Tx) error {
+		var created Account
+		err := tx.QueryRow(ctx, `
+			INSERT INTO accounts (id, username, email) VALUES ($1, $2, $3)
+			ON CONFLICT (id) DO NOTHING
+			RETURNING `+accountColumns, a.ID, a.Username, a.Email,
+		).Scan(&created.ID, &created.Username, &created.Email, &created.CreatedAt, &created.DisabledAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The account already exists
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error creating account: %w", err)
+		}
+		return appendOutbox(ctx, tx, AccountCreated, created.ID, created)
+	})
+}
+
+func (usrTable *pgAccountHandler) ListAccounts(ctx context.Context, afterID string, limit int) ([]Account, error) {
+	rows, err := usrTable.db.Query(ctx, `
+		SELECT `+accountColumns+` FROM accounts
+		WHERE id > $1 ORDER BY id LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.ID, &a.Username, &a.Email, &a.CreatedAt, &a.DisabledAt); err != nil {
+			return nil, fmt.Errorf("error reading account: %w", err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+func (usrTable *pgAccountHandler) SetAccountDisabled(ctx context.Context, userID string, disabled bool) error {
+	return pgx.BeginFunc(ctx, usrTable.db, func(tx pgx.Tx) error {
+		var wasDisabled bool
+		err := tx.QueryRow(ctx,
+			`SELECT disabled_at IS NOT NULL FROM accounts WHERE id = $1 FOR UPDATE`, userID).Scan(&wasDisabled)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error reading account: %w", err)
+		}
+		if wasDisabled == disabled {
+			// Accounts already in the requested state keep their original disabling time
+			return nil
+		}
+
+		var a Account
+		err = tx.QueryRow(ctx, `
+			UPDATE accounts SET disabled_at = CASE WHEN $2 THEN now() END
+			WHERE id = $1
+			RETURNING `+accountColumns, userID, disabled,
+		).Scan(&a.ID, &a.Username, &a.Email, &a.CreatedAt, &a.DisabledAt)
+		if err != nil {
+			return fmt.Errorf("error updating account: %w", err)
+		}
+		eventType := AccountEnabled
+		if disabled {
+			eventType = AccountDisabled
+		}
+		return appendOutbox(ctx, tx, eventType, a.ID, a)
+	})
+}
+
+func (usrTable *pgAccountHandler) IsAccountDisabled(ctx context.Context, userID string) (bool, error) {
+	var disabled bool
+	err := usrTable.db.QueryRow(ctx,
+		`SELECT disabled_at IS NOT NULL FROM accounts WHERE id = $1`, userID).Scan(&disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading account: %w", err)
+	}
+	return disabled, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// pgDeviceHandler populates the Storage.Conns.DeviceTableOps field,
+// and its methods implement the DeviceStorageHandler interface
+type pgDeviceHandler struct {
+	db pgQuerier
+}
+
+func (devTable *pgDeviceHandler) RegisterDevice(ctx context.Context, d Device) error {
+	// A token identifies a device, so a device that changes user moves its token along
+	_, err := devTable.db.Exec(ctx, `
+		INSERT INTO device_tokens (token, user_id, platform) VALUES ($1, $2, $3)
+		ON CONFLICT (token) DO UPDATE SET user_id = EXCLUDED.user_id, platform = EXCLUDED.platform`,
+		d.Token, d.UserID, d.Platform,
+	)
+	if err != nil {
+		return fmt.Errorf("error registering device: %w", err)
+	}
+	return nil
+}
+
+func (devTable *pgDeviceHandler) UnregisterDevice(ctx context.Context, userID, token string) error {
+	tag, err := devTable.db.Exec(ctx,
+		`DELETE FROM device_tokens WHERE token = $1 AND user_id = $2`, token, userID)
+	if err != nil {
+		return fmt.Errorf("error unregistering device: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (devTable *pgDeviceHandler) ListDevices(ctx context.Context, userID string) ([]Device, error) {
+	rows, err := devTable.db.Query(ctx,
+		`SELECT user_id, platform, token, created_at FROM device_tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.UserID, &d.Platform, &d.Token, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error reading device: %w", err)
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing devices: %w", err)
+	}
+	return devices, nil
+}
+
+func (devTable *pgDeviceHandler) DeleteDevice(ctx context.Context, token string) error {
+	if _, err := devTable.db.Exec(ctx, `DELETE FROM device_tokens WHERE token = $1`, token); err != nil {
+		return fmt.Errorf("error deleting device: %w", err)
+	}
+	return nil
+}
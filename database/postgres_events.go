@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const eventColumns = `id, creator_id, title, description, starts_at, latitude, longitude, created_at, updated_at`
+
+func scanEvent(row pgx.Row) (Event, error) {
+	var ev Event
+	err := row.Scan(&ev.ID, &ev.CreatorID, &ev.Title, &ev.Description, &ev.StartsAt,
+		&ev.Latitude, &ev.Longitude, &ev.CreatedAt, &ev.UpdatedAt)
+	return ev, err
+}
+
+func (evTable *pgEventHandler) CreateEvent(ctx context.Context, ev Event) (Event, error) {
+	var created Event
+	err := pgx.BeginFunc(ctx, evTable.db, func(tx pgx.Tx) error {
+		var err error
+		created, err = scanEvent(tx.QueryRow(ctx, `
+			INSERT INTO events (id, creator_id, title, description, starts_at, latitude, longitude)
+			VALUES (COALESCE(NULLIF($1, ''), gen_random_uuid()::TEXT), $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (id) DO NOTHING
+			RETURNING `+eventColumns,
+			ev.ID, ev.CreatorID, ev.Title, ev.Description, ev.StartsAt, ev.Latitude, ev.Longitude,
+		))
+		if err != nil {
+			return err
+		}
+		return appendOutbox(ctx, tx, EventCreated, created.ID, created)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The event already exists
+		return evTable.GetEvent(ctx, ev.ID)
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("error creating event: %w", err)
+	}
+	return created, nil
+}
+
+func (evTable *pgEventHandler) GetEvent(ctx context.Context, eventID string) (Event, error) {
+	ev, err := scanEvent(evTable.db.QueryRow(ctx, `SELECT `+eventColumns+` FROM events WHERE id = $1`, eventID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Event{}, ErrNotFound
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("error reading event: %w", err)
+	}
+	return ev, nil
+}
+
+func (evTable *pgEventHandler) UpdateEvent(ctx context.Context, ev Event) (Event, error) {
+	var updated Event
+	err := pgx.BeginFunc(ctx, evTable.db, func(tx pgx.Tx) error {
+		var err error
+		updated, err = scanEvent(tx.QueryRow(ctx, `
+			UPDATE events SET title = $2, description = $3, starts_at = $4, latitude = $5, longitude = $6,
+				updated_at = now()
+			WHERE id = $1
+			RETURNING `+eventColumns,
+			ev.ID, ev.Title, ev.Description, ev.StartsAt, ev.Latitude, ev.Longitude,
+		))
+		if err != nil {
+			return err
+		}
+		return appendOutbox(ctx, tx, EventUpdated, updated.ID, updated)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Event{}, ErrNotFound
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("error updating event: %w", err)
+	}
+	return updated, nil
+}
+
+func (evTable *pgEventHandler) JoinEvent(ctx context.Context, eventID, userID string) error {
+	return pgx.BeginFunc(ctx, evTable.db, func(tx pgx.Tx) error {
+		p := Participation{EventID: eventID, UserID: userID}
+		err := tx.QueryRow(ctx, `
+			INSERT INTO event_participants (event_id, user_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+			RETURNING joined_at`, eventID, userID,
+		).Scan(&p.JoinedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Already participating
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error joining event: %w", err)
+		}
+		return appendOutbox(ctx, tx, EventJoined, eventID, p)
+	})
+}
+
+func (evTable *pgEventHandler) ListEventParticipants(ctx context.Context, eventID string) ([]string, error) {
+	rows, err := evTable.db.Query(ctx,
+		`SELECT user_id FROM event_participants WHERE event_id = $1`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing event participants: %w", err)
+	}
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("error reading event participants: %w", err)
+	}
+	return userIDs, nil
+}
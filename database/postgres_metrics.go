@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/charm-113c/project-zero/metrics"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// registerPoolMetrics exposes the statistics of the connection pool, read when the metrics are scraped
+func registerPoolMetrics(reg *metrics.Registry, pool *pgxpool.Pool) {
+	reg.NewGaugeFunc("pgxpool_acquired_conns", "Number of connections currently in use.",
+		func() float64 { return float64(pool.Stat().AcquiredConns()) })
+	reg.NewGaugeFunc("pgxpool_idle_conns", "Number of idle connections in the pool.",
+		func() float64 { return float64(pool.Stat().IdleConns()) })
+	reg.NewGaugeFunc("pgxpool_total_conns", "Number of connections in the pool, including those being established.",
+		func() float64 { return float64(pool.Stat().TotalConns()) })
+	reg.NewGaugeFunc("pgxpool_max_conns", "Maximum size of the pool.",
+		func() float64 { return float64(pool.Stat().MaxConns()) })
+	reg.NewCounterFunc("pgxpool_acquires_total", "Number of connections acquired from the pool.",
+		func() float64 { return float64(pool.Stat().AcquireCount()) })
+	reg.NewCounterFunc("pgxpool_empty_acquires_total", "Number of acquisitions that had to wait because the pool had no idle connection.",
+		func() float64 { return float64(pool.Stat().EmptyAcquireCount()) })
+	reg.NewCounterFunc("pgxpool_canceled_acquires_total", "Number of acquisitions cancelled by their context.",
+		func() float64 { return float64(pool.Stat().CanceledAcquireCount()) })
+	reg.NewCounterFunc("pgxpool_acquire_seconds_total", "Total time spent acquiring connections.",
+		func() float64 { return pool.Stat().AcquireDuration().Seconds() })
+}
+
+// pgMetricsTracer implements pgxpool.AcquireTracer, measuring how many queries are
+// waiting for a connection and for how long: a growing number signals pool exhaustion
+type pgMetricsTracer struct {
+	waiting   *metrics.Gauge
+	durations *metrics.Histogram
+}
+
+func newPgMetricsTracer(reg *metrics.Registry) *pgMetricsTracer {
+	return &pgMetricsTracer{
+		waiting: reg.NewGauge("pgxpool_waiting_acquires", "Number of queries currently waiting for a connection."),
+		durations: reg.NewHistogram("pgxpool_acquire_duration_seconds", "Time spent waiting for a connection.",
+			[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}),
+	}
+}
+
+type acquireStartCtxKey struct{}
+
+func (t *pgMetricsTracer) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireStartData) context.Context {
+	t.waiting.Add(1)
+	return context.WithValue(ctx, acquireStartCtxKey{}, time.Now())
+}
+
+func (t *pgMetricsTracer) TraceAcquireEnd(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	t.waiting.Add(-1)
+	if start, ok := ctx.Value(acquireStartCtxKey{}).(time.Time); ok {
+		t.durations.Observe(time.Since(start).Seconds())
+	}
+}
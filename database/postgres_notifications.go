@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pgNotificationHandler populates the Storage.Conns.NotifTableOps field,
+// and its methods implement the NotificationStorageHandler interface
+type pgNotificationHandler struct {
+	db pgQuerier
+}
+
+const notificationColumns = `id, recipient_id, type, group_key, subject_id, actor_ids, count, read_at, created_at, updated_at`
+
+func scanNotification(row pgx.Row) (Notification, error) {
+	var n Notification
+	err := row.Scan(&n.ID, &n.RecipientID, &n.Type, &n.GroupKey, &n.SubjectID,
+		&n.ActorIDs, &n.Count, &n.ReadAt, &n.CreatedAt, &n.UpdatedAt)
+	return n, err
+}
+
+func (notifTable *pgNotificationHandler) InsertNotification(ctx context.Context, n Notification) (Notification, bool, error) {
+	// An actor triggering the same notification twice (e.g. follow, unfollow, follow)
+	// is only counted once within a group. Notifications without actors (sent by the server) count as one,
+	// and are always new
+	row := notifTable.db.QueryRow(ctx, `
+		WITH existing AS (
+			SELECT actor_ids FROM notifications
+			WHERE recipient_id = $1 AND group_key = $3 AND read_at IS NULL
+		)
+		INSERT INTO notifications (recipient_id, type, group_key, subject_id, actor_ids, count)
+		VALUES ($1, $2, $3, $4, $5, GREATEST(cardinality($5::TEXT[]), 1))
+		ON CONFLICT (recipient_id, group_key) WHERE read_at IS NULL DO UPDATE SET
+			actor_ids = notifications.actor_ids || ARRAY(
+				SELECT a FROM unnest(EXCLUDED.actor_ids) a WHERE a <> ALL(notifications.actor_ids)),
+			count = GREATEST(cardinality(notifications.actor_ids || ARRAY(
+				SELECT a FROM unnest(EXCLUDED.actor_ids) a WHERE a <> ALL(notifications.actor_ids))), 1),
+			updated_at = now()
+		RETURNING `+notificationColumns+`,
+			cardinality($5::TEXT[]) = 0 OR NOT EXISTS (SELECT 1 FROM existing WHERE $5::TEXT[] <@ existing.actor_ids)`,
+		n.RecipientID, n.Type, n.GroupKey, n.SubjectID, n.ActorIDs,
+	)
+	var stored Notification
+	var added bool
+	err := row.Scan(&stored.ID, &stored.RecipientID, &stored.Type, &stored.GroupKey, &stored.SubjectID,
+		&stored.ActorIDs, &stored.Count, &stored.ReadAt, &stored.CreatedAt, &stored.UpdatedAt, &added)
+	if err != nil {
+		return Notification{}, false, fmt.Errorf("error inserting notification: %w", err)
+	}
+	return stored, added, nil
+}
+
+func (notifTable *pgNotificationHandler) ListNotifications(ctx context.Context, recipientID string, unreadOnly bool, limit int) ([]Notification, error) {
+	rows, err := notifTable.db.Query(ctx, `
+		SELECT `+notificationColumns+` FROM notifications
+		WHERE recipient_id = $1 AND ($2 = false OR read_at IS NULL)
+		ORDER BY updated_at DESC
+		LIMIT $3`,
+		recipientID, unreadOnly, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifs := []Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading notification: %w", err)
+		}
+		notifs = append(notifs, n)
+	}
+	return notifs, rows.Err()
+}
+
+func (notifTable *pgNotificationHandler) MarkNotificationRead(ctx context.Context, recipientID string, notificationID int64) error {
+	// Filtering on the recipient prevents users from marking other users' notifications
+	tag, err := notifTable.db.Exec(ctx, `
+		UPDATE notifications SET read_at = COALESCE(read_at, now())
+		WHERE id = $1 AND recipient_id = $2`,
+		notificationID, recipientID,
+	)
+	if err != nil {
+		return fmt.Errorf("error marking notification as read: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (notifTable *pgNotificationHandler) MarkAllNotificationsRead(ctx context.Context, recipientID string) error {
+	_, err := notifTable.db.Exec(ctx, `
+		UPDATE notifications SET read_at = now()
+		WHERE recipient_id = $1 AND read_at IS NULL`,
+		recipientID,
+	)
+	if err != nil {
+		return fmt.Errorf("error marking notifications as read: %w", err)
+	}
+	return nil
+}
+
+func (notifTable *pgNotificationHandler) GetNotificationPrefs(ctx context.Context, userID string) (map[string]bool, error) {
+	rows, err := notifTable.db.Query(ctx,
+		`SELECT type, enabled FROM notification_preferences WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	prefs := make(map[string]bool)
+	for rows.Next() {
+		var notifType string
+		var enabled bool
+		if err := rows.Scan(&notifType, &enabled); err != nil {
+			return nil, fmt.Errorf("error reading notification preference: %w", err)
+		}
+		prefs[notifType] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading notification preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+func (notifTable *pgNotificationHandler) SetNotificationPrefs(ctx context.Context, userID string, prefs map[string]bool) error {
+	return pgx.BeginFunc(ctx, notifTable.db, func(tx pgx.Tx) error {
+		for notifType, enabled := range prefs {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO notification_preferences (user_id, type, enabled) VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled`,
+				userID, notifType, enabled,
+			)
+			if err != nil {
+				return fmt.Errorf("error saving notification preference %s: %w", notifType, err)
+			}
+		}
+		return nil
+	})
+}
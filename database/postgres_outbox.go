@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charm-113c/project-zero/util"
+	"github.com/jackc/pgx/v5"
+)
+
+// pgOutboxHandler populates the Storage.Conns.OutboxTableOps field,
+// and its methods implement the OutboxStorageHandler interface
+type pgOutboxHandler struct {
+	db pgQuerier
+}
+
+// withoutOutboxKey is the context key set by WithoutOutbox
+type withoutOutboxKey struct{}
+
+// WithoutOutbox returns a copy of ctx with which the changes made aren't recorded in the outbox,
+// for bulk writes whose consumers mustn't react to (e.g. seeding fake data)
+func WithoutOutbox(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutOutboxKey{}, true)
+}
+
+// appendOutbox records a domain event, and must be called in the transaction making the change it describes
+func appendOutbox(ctx context.Context, tx pgx.Tx, eventType, aggregateID string, payload any) error {
+	if skip, _ := ctx.Value(withoutOutboxKey{}).(bool); skip {
+		return nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding %s event: %w", eventType, err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO outbox (type, aggregate_id, payload) VALUES ($1, $2, $3)`,
+		eventType, aggregateID, data)
+	if err != nil {
+		return fmt.Errorf("error recording %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+// ConsumeOutbox only reads the events of transactions older than every transaction still running.
+// IDs are drawn when events are recorded, not when they're committed, so a transaction may still commit
+// an event with an ID lower than those already handled; once older than every running transaction, it
+// can't, and its events are handed over in order. A long-running transaction therefore delays the delivery
+// of the events recorded after it started, until it completes
+func (outboxTable *pgOutboxHandler) ConsumeOutbox(ctx context.Context, consumer string, limit, maxAttempts int, handle func(ctx context.Context, ev DomainEvent) error) (OutboxBatch, error) {
+	var batch OutboxBatch
+	var handleErr error
+	err := pgx.BeginFunc(ctx, outboxTable.db, func(tx pgx.Tx) error {
+		// New consumers start at the head of the outbox, rather than replaying the events kept for retention
+		_, err := tx.Exec(ctx, `
+			INSERT INTO outbox_offsets (consumer, txid, event_id)
+			SELECT $1, COALESCE(head.txid, 0), COALESCE(head.id, 0)
+			FROM (SELECT 1) AS origin LEFT JOIN LATERAL (
+				SELECT txid, id FROM outbox
+				WHERE txid < pg_snapshot_xmin(pg_current_snapshot())::TEXT::BIGINT
+				ORDER BY txid DESC, id DESC
+				LIMIT 1
+			) AS head ON true
+			ON CONFLICT DO NOTHING`, consumer)
+		if err != nil {
+			return fmt.Errorf("error creating outbox consumer: %w", err)
+		}
+
+		// The consumer's offset stays locked until the batch is handled, so replicas take turns
+		var txID, eventID int64
+		var failures int
+		err = tx.QueryRow(ctx, `
+			SELECT txid, event_id, failures FROM outbox_offsets WHERE consumer = $1
+			FOR UPDATE SKIP LOCKED`, consumer,
+		).Scan(&txID, &eventID, &failures)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Being consumed by another replica
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading outbox offset: %w", err)
+		}
+
+		rows, err := tx.Query(ctx, `
+			SELECT id, txid, type, aggregate_id, payload, created_at FROM outbox
+			WHERE (txid, id) > ($1, $2) AND txid < pg_snapshot_xmin(pg_current_snapshot())::TEXT::BIGINT
+			ORDER BY txid, id
+			LIMIT $3`, txID, eventID, limit)
+		if err != nil {
+			return fmt.Errorf("error reading outbox: %w", err)
+		}
+		events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (DomainEvent, error) {
+			var ev DomainEvent
+			err := row.Scan(&ev.ID, &ev.TxID, &ev.Type, &ev.AggregateID, &ev.Payload, &ev.CreatedAt)
+			return ev, err
+		})
+		if err != nil {
+			return fmt.Errorf("error reading outbox: %w", err)
+		}
+
+		for _, ev := range events {
+			if failure := handle(ctx, ev); failure != nil {
+				failures++
+				if !util.IsPermanent(failure) && failures < maxAttempts {
+					handleErr = failure
+					break
+				}
+				// Given up on, so that the events following it aren't held up forever
+				_, err := tx.Exec(ctx, `
+					INSERT INTO outbox_dead_letters (consumer, event_id, txid, type, aggregate_id, payload, created_at, attempts, last_error)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+					ON CONFLICT DO NOTHING`,
+					consumer, ev.ID, ev.TxID, ev.Type, ev.AggregateID, ev.Payload, ev.CreatedAt, failures, failure.Error())
+				if err != nil {
+					return fmt.Errorf("error dead-lettering outbox event: %w", err)
+				}
+				batch.DeadLettered = append(batch.DeadLettered, OutboxDeadLetter{ev, failures, failure.Error()})
+			}
+			txID, eventID, failures = ev.TxID, ev.ID, 0
+			batch.Handled++
+		}
+		if batch.Handled == 0 && handleErr == nil {
+			return nil
+		}
+		// The events handled so far are committed even if one failed, so that they're not handed over again,
+		// along with the failures of the next one
+		lastError := ""
+		if handleErr != nil {
+			lastError = handleErr.Error()
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE outbox_offsets SET txid = $2, event_id = $3, failures = $4, last_error = $5, updated_at = now()
+			WHERE consumer = $1`, consumer, txID, eventID, failures, lastError)
+		if err != nil {
+			return fmt.Errorf("error updating outbox offset: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return OutboxBatch{}, err
+	}
+	return batch, handleErr
+}
+
+func (outboxTable *pgOutboxHandler) OutboxOffsets(ctx context.Context) ([]OutboxOffset, error) {
+	rows, err := outboxTable.db.Query(ctx, `
+		SELECT o.consumer, o.txid, o.event_id, o.updated_at,
+			(SELECT count(*) FROM outbox e WHERE (e.txid, e.id) > (o.txid, o.event_id)),
+			o.failures, o.last_error,
+			(SELECT count(*) FROM outbox_dead_letters d WHERE d.consumer = o.consumer)
+		FROM outbox_offsets o ORDER BY o.consumer`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox offsets: %w", err)
+	}
+	offsets, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (OutboxOffset, error) {
+		var o OutboxOffset
+		err := row.Scan(&o.Consumer, &o.TxID, &o.EventID, &o.UpdatedAt, &o.Lag, &o.Failures, &o.LastError, &o.DeadLetters)
+		return o, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox offsets: %w", err)
+	}
+	return offsets, nil
+}
+
+func (outboxTable *pgOutboxHandler) PruneOutbox(ctx context.Context, retention time.Duration) (int64, error) {
+	// Without consumers, the subquery yields NULL and nothing is deleted
+	tag, err := outboxTable.db.Exec(ctx, `
+		DELETE FROM outbox
+		WHERE created_at < now() - $1::INTERVAL
+			AND (txid, id) <= (SELECT txid, event_id FROM outbox_offsets ORDER BY txid, event_id LIMIT 1)`,
+		retention)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning outbox: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
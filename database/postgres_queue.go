@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pgQueueHandler populates the Storage.Conns.QueueTableOps field,
+// and its methods implement the QueueStorageHandler interface
+type pgQueueHandler struct {
+	db pgQuerier
+}
+
+func (queueTable *pgQueueHandler) EnqueueJob(ctx context.Context, job QueuedJob) (int64, error) {
+	args := job.Args
+	if args == nil {
+		args = []byte("{}")
+	}
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	var id int64
+	err := queueTable.db.QueryRow(ctx, `
+		INSERT INTO queued_jobs (queue, kind, args, max_attempts, run_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		job.Queue, job.Kind, args, job.MaxAttempts, runAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error enqueueing job: %w", err)
+	}
+	return id, nil
+}
+
+func (queueTable *pgQueueHandler) ClaimJob(ctx context.Context, queue string, lease time.Duration) (QueuedJob, bool, error) {
+	// SKIP LOCKED lets concurrent workers each claim a different job instead of queueing up on the same row
+	var job QueuedJob
+	err := queueTable.db.QueryRow(ctx, `
+		UPDATE queued_jobs SET locked_until = now() + $2::INTERVAL, lease_token = gen_random_uuid()
+		WHERE id = (
+			SELECT id FROM queued_jobs
+			WHERE queue = $1 AND run_at <= now() AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY run_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, queue, kind, args, attempts, max_attempts, run_at, last_error, created_at, lease_token::TEXT`,
+		queue, lease,
+	).Scan(&job.ID, &job.Queue, &job.Kind, &job.Args, &job.Attempts, &job.MaxAttempts,
+		&job.RunAt, &job.LastError, &job.CreatedAt, &job.LeaseToken)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueuedJob{}, false, nil
+	}
+	if err != nil {
+		return QueuedJob{}, false, fmt.Errorf("error claiming job: %w", err)
+	}
+	return job, true, nil
+}
+
+// The statements below only apply while the caller holds the job's lease: once it expired,
+// the job may have been claimed again by another worker, which then owns it
+
+func (queueTable *pgQueueHandler) ExtendJobLease(ctx context.Context, jobID int64, leaseToken string, lease time.Duration) error {
+	tag, err := queueTable.db.Exec(ctx, `
+		UPDATE queued_jobs SET locked_until = now() + $3::INTERVAL
+		WHERE id = $1 AND lease_token = $2`, jobID, leaseToken, lease)
+	if err != nil {
+		return fmt.Errorf("error extending job lease: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (queueTable *pgQueueHandler) CompleteJob(ctx context.Context, jobID int64, leaseToken string) error {
+	tag, err := queueTable.db.Exec(ctx, `DELETE FROM queued_jobs WHERE id = $1 AND lease_token = $2`, jobID, leaseToken)
+	if err != nil {
+		return fmt.Errorf("error completing job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (queueTable *pgQueueHandler) RetryJob(ctx context.Context, jobID int64, leaseToken string, runAt time.Time, args []byte, lastError string) error {
+	tag, err := queueTable.db.Exec(ctx, `
+		UPDATE queued_jobs SET attempts = attempts + 1, run_at = $3, locked_until = NULL, lease_token = NULL,
+			args = $4, last_error = $5
+		WHERE id = $1 AND lease_token = $2`,
+		jobID, leaseToken, runAt, args, lastError,
+	)
+	if err != nil {
+		return fmt.Errorf("error releasing job for retry: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (queueTable *pgQueueHandler) DeadLetterJob(ctx context.Context, jobID int64, leaseToken string, args []byte, lastError string) error {
+	return pgx.BeginFunc(ctx, queueTable.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO queued_jobs_dead (id, queue, kind, args, attempts, last_error, created_at)
+			SELECT id, queue, kind, $3::JSONB, attempts + 1, $4, created_at FROM queued_jobs
+			WHERE id = $1 AND lease_token = $2`,
+			jobID, leaseToken, args, lastError,
+		)
+		if err != nil {
+			return fmt.Errorf("error dead-lettering job: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrLeaseLost
+		}
+		if _, err = tx.Exec(ctx, `DELETE FROM queued_jobs WHERE id = $1`, jobID); err != nil {
+			return fmt.Errorf("error dead-lettering job: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pgScheduleHandler populates the Storage.Conns.JobTableOps field,
+// and its methods implement the ScheduleStorageHandler interface
+type pgScheduleHandler struct {
+	db pgQuerier
+}
+
+const scheduledJobColumns = `id, kind, group_key, run_at, payload, attempts, last_error`
+
+func scanScheduledJob(row pgx.Row) (ScheduledJob, error) {
+	var job ScheduledJob
+	err := row.Scan(&job.ID, &job.Kind, &job.GroupKey, &job.RunAt, &job.Payload, &job.Attempts, &job.LastError)
+	return job, err
+}
+
+func (jobTable *pgScheduleHandler) ReplaceJobs(ctx context.Context, kind, groupKey string, jobs []ScheduledJob) error {
+	return pgx.BeginFunc(ctx, jobTable.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM scheduled_jobs WHERE kind = $1 AND group_key = $2`, kind, groupKey)
+		if err != nil {
+			return fmt.Errorf("error removing scheduled jobs: %w", err)
+		}
+		for _, job := range jobs {
+			payload := job.Payload
+			if payload == nil {
+				payload = []byte("{}")
+			}
+			_, err = tx.Exec(ctx, `
+				INSERT INTO scheduled_jobs (kind, group_key, run_at, payload) VALUES ($1, $2, $3, $4)`,
+				kind, groupKey, job.RunAt, payload,
+			)
+			if err != nil {
+				return fmt.Errorf("error scheduling job: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (jobTable *pgScheduleHandler) DueJobs(ctx context.Context, now time.Time, limit int) ([]ScheduledJob, error) {
+	rows, err := jobTable.db.Query(ctx, `
+		SELECT `+scheduledJobColumns+` FROM scheduled_jobs
+		WHERE run_at <= $1 ORDER BY run_at LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing due jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []ScheduledJob{}
+	for rows.Next() {
+		job, err := scanScheduledJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading scheduled job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing due jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// scheduleLockNamespace is the first key of the advisory locks taken on scheduled jobs, keeping them apart
+// from the advisory locks taken on other IDs. The second key is the job's ID, truncated to 32 bits: two jobs
+// whose IDs differ by a multiple of 2^32 share a lock, one of them merely waiting for the next poll
+const scheduleLockNamespace int32 = 0x5343_4844 // "SCHD"
+
+// errJobSkipped rolls back the transaction of a job that must not be run by this replica
+var errJobSkipped = errors.New("job skipped")
+
+func (jobTable *pgScheduleHandler) RunJobExclusively(ctx context.Context, jobID int64, maxAttempts int, retryAt func(attempts int) time.Time, fn func(ctx context.Context, job ScheduledJob, tx ScheduleStorageHandler) error) (bool, error) {
+	err := pgx.BeginFunc(ctx, jobTable.db, func(tx pgx.Tx) error {
+		// The advisory lock is released with the transaction, so a replica crashing
+		// mid-job lets the others pick the job up again
+		var locked bool
+		err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1, $2)`, scheduleLockNamespace, int32(jobID)).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("error acquiring job lock: %w", err)
+		}
+		if !locked {
+			return errJobSkipped
+		}
+
+		// Another replica may have run (or rescheduled) the job between listing and locking it
+		job, err := scanScheduledJob(tx.QueryRow(ctx, `
+			SELECT `+scheduledJobColumns+` FROM scheduled_jobs WHERE id = $1 AND run_at <= now()`, jobID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errJobSkipped
+		}
+		if err != nil {
+			return fmt.Errorf("error reading scheduled job: %w", err)
+		}
+
+		// fn runs in a savepoint: should it fail, the jobs it scheduled are rolled back with it. The jobs it
+		// replaces, this one included, are deleted within this transaction rather than from one of their own
+		jobErr := pgx.BeginFunc(ctx, tx, func(sp pgx.Tx) error {
+			return fn(ctx, job, &pgScheduleHandler{db: sp})
+		})
+		if jobErr != nil && job.Attempts+1 < maxAttempts {
+			_, err = tx.Exec(ctx, `
+				UPDATE scheduled_jobs SET attempts = attempts + 1, run_at = $2, last_error = $3 WHERE id = $1`,
+				jobID, retryAt(job.Attempts+1), jobErr.Error(),
+			)
+			if err != nil {
+				return fmt.Errorf("error rescheduling failed job: %w", err)
+			}
+			return nil
+		}
+
+		if _, err = tx.Exec(ctx, `DELETE FROM scheduled_jobs WHERE id = $1`, jobID); err != nil {
+			return fmt.Errorf("error removing completed job: %w", err)
+		}
+		return nil
+	})
+	if errors.Is(err, errJobSkipped) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
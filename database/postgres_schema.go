@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgSchema lists the statements creating the tables needed by the pg*Handlers.
+// Every statement must be idempotent, as they are all run at each startup
+var pgSchema = []string{
+	`CREATE TABLE IF NOT EXISTS accounts (
+		id          TEXT PRIMARY KEY,
+		username    TEXT NOT NULL DEFAULT '',
+		email       TEXT NOT NULL DEFAULT '',
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+		disabled_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS follows (
+		follower_id TEXT NOT NULL,
+		followee_id TEXT NOT NULL,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (follower_id, followee_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS follows_followee_idx ON follows (followee_id)`,
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id           BIGSERIAL PRIMARY KEY,
+		recipient_id TEXT NOT NULL,
+		type         TEXT NOT NULL,
+		group_key    TEXT NOT NULL,
+		subject_id   TEXT NOT NULL DEFAULT '',
+		actor_ids    TEXT[] NOT NULL DEFAULT '{}',
+		count        INTEGER NOT NULL DEFAULT 1,
+		read_at      TIMESTAMPTZ,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// Only one unread notification per group, so that new ones are merged into it
+	`CREATE UNIQUE INDEX IF NOT EXISTS notifications_unread_group_idx
+		ON notifications (recipient_id, group_key) WHERE read_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS notifications_recipient_idx
+		ON notifications (recipient_id, updated_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id TEXT NOT NULL,
+		type    TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		PRIMARY KEY (user_id, type)
+	)`,
+	`CREATE TABLE IF NOT EXISTS device_tokens (
+		token      TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		platform   TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS device_tokens_user_idx ON device_tokens (user_id)`,
+	`CREATE TABLE IF NOT EXISTS events (
+		id          TEXT PRIMARY KEY DEFAULT gen_random_uuid()::TEXT,
+		creator_id  TEXT NOT NULL,
+		title       TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		starts_at   TIMESTAMPTZ NOT NULL,
+		latitude    DOUBLE PRECISION NOT NULL DEFAULT 0,
+		longitude   DOUBLE PRECISION NOT NULL DEFAULT 0,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS event_participants (
+		event_id  TEXT NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+		user_id   TEXT NOT NULL,
+		joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (event_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS scheduled_jobs (
+		id         BIGSERIAL PRIMARY KEY,
+		kind       TEXT NOT NULL,
+		group_key  TEXT NOT NULL,
+		run_at     TIMESTAMPTZ NOT NULL,
+		payload    JSONB NOT NULL DEFAULT '{}',
+		attempts   INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS scheduled_jobs_run_at_idx ON scheduled_jobs (run_at)`,
+	`CREATE INDEX IF NOT EXISTS scheduled_jobs_group_idx ON scheduled_jobs (kind, group_key)`,
+	`CREATE TABLE IF NOT EXISTS queued_jobs (
+		id           BIGSERIAL PRIMARY KEY,
+		queue        TEXT NOT NULL,
+		kind         TEXT NOT NULL,
+		args         JSONB NOT NULL DEFAULT '{}',
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL,
+		run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+		locked_until TIMESTAMPTZ,
+		lease_token  UUID,
+		last_error   TEXT NOT NULL DEFAULT '',
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// Added after the table, for DBs created before
+	`ALTER TABLE queued_jobs ADD COLUMN IF NOT EXISTS lease_token UUID`,
+	`CREATE INDEX IF NOT EXISTS queued_jobs_claim_idx ON queued_jobs (queue, run_at)`,
+	`CREATE TABLE IF NOT EXISTS queued_jobs_dead (
+		id         BIGINT PRIMARY KEY,
+		queue      TEXT NOT NULL,
+		kind       TEXT NOT NULL,
+		args       JSONB NOT NULL,
+		attempts   INTEGER NOT NULL,
+		last_error TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		failed_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// txid is the ID of the transaction that recorded the event, see ConsumeOutbox
+	`CREATE TABLE IF NOT EXISTS outbox (
+		id           BIGSERIAL PRIMARY KEY,
+		txid         BIGINT NOT NULL DEFAULT pg_current_xact_id()::TEXT::BIGINT,
+		type         TEXT NOT NULL,
+		aggregate_id TEXT NOT NULL,
+		payload      JSONB NOT NULL,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS outbox_position_idx ON outbox (txid, id)`,
+	`CREATE TABLE IF NOT EXISTS outbox_offsets (
+		consumer   TEXT PRIMARY KEY,
+		txid       BIGINT NOT NULL DEFAULT 0,
+		event_id   BIGINT NOT NULL DEFAULT 0,
+		failures   INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// Added after the table, for DBs created before
+	`ALTER TABLE outbox_offsets ADD COLUMN IF NOT EXISTS failures INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE outbox_offsets ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT ''`,
+	// Copies of the events consumers gave up on, kept after the outbox is pruned
+	`CREATE TABLE IF NOT EXISTS outbox_dead_letters (
+		consumer     TEXT NOT NULL,
+		event_id     BIGINT NOT NULL,
+		txid         BIGINT NOT NULL,
+		type         TEXT NOT NULL,
+		aggregate_id TEXT NOT NULL,
+		payload      JSONB NOT NULL,
+		created_at   TIMESTAMPTZ NOT NULL,
+		attempts     INTEGER NOT NULL,
+		last_error   TEXT NOT NULL,
+		failed_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (consumer, event_id)
+	)`,
+}
+
+// migratePostgres creates the tables and indexes the server relies on, should they not exist yet
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	for _, stmt := range pgSchema {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("error applying schema statement %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (socTable *pgSocialHandler) FollowUser(ctx context.Context, followerID, followeeID string) error {
+	return pgx.BeginFunc(ctx, socTable.db, func(tx pgx.Tx) error {
+		f := Follow{FollowerID: followerID, FolloweeID: followeeID}
+		err := tx.QueryRow(ctx, `
+			INSERT INTO follows (follower_id, followee_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+			RETURNING created_at`, followerID, followeeID,
+		).Scan(&f.CreatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Already following
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error following user: %w", err)
+		}
+		return appendOutbox(ctx, tx, UserFollowed, followeeID, f)
+	})
+}
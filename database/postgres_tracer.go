@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// pgLogTracer implements pgx.QueryTracer, logging every query with its duration and the
+// request ID of its context: successful queries at debug level, failed ones at error level
+type pgLogTracer struct {
+	logger *zap.Logger
+}
+
+type queryStartCtxKey struct{}
+
+type queryStart struct {
+	sql  string
+	time time.Time
+}
+
+func (t *pgLogTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartCtxKey{}, queryStart{data.SQL, time.Now()})
+}
+
+func (t *pgLogTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, _ := ctx.Value(queryStartCtxKey{}).(queryStart)
+	logger := logging.FromContext(ctx, t.logger)
+	fields := []zap.Field{
+		zap.String("sql", start.sql),
+		zap.Duration("duration", time.Since(start.time)),
+		zap.String("commandTag", data.CommandTag.String()),
+	}
+
+	if data.Err != nil {
+		// Cancellations are the caller's doing (e.g. the client went away), not DB errors
+		if ctx.Err() != nil {
+			logger.Debug("Query cancelled", append(fields, zap.Error(data.Err))...)
+			return
+		}
+		logger.Error("Query failed", append(fields, zap.Error(data.Err))...)
+		return
+	}
+	logger.Debug("Query executed", fields...)
+}
+
+// pgSpanTracer implements pgx.QueryTracer, recording a span for each query
+// as a child of the span of its context (e.g. the request's)
+type pgSpanTracer struct{}
+
+type querySpanCtxKey struct{}
+
+func (pgSpanTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, span := tracing.Start(ctx, "db.query", tracing.KindClient)
+	span.SetAttr("db.system", "postgresql")
+	span.SetAttr("db.statement", data.SQL)
+	// The query's span is stored under its own key, so that the end of an
+	// untraced query is never mistaken for the end of its parent's span
+	return context.WithValue(ctx, querySpanCtxKey{}, span)
+}
+
+func (pgSpanTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, _ := ctx.Value(querySpanCtxKey{}).(*tracing.Span)
+	span.SetAttr("db.rows_affected", data.CommandTag.RowsAffected())
+	span.RecordError(data.Err)
+	span.End()
+}
+
+// pgTracers combines several tracers into the single one a pool accepts. Besides queries,
+// it forwards pool acquisitions to the tracers implementing pgxpool.AcquireTracer
+type pgTracers []any
+
+func (t pgTracers) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	for _, tracer := range t {
+		if qt, ok := tracer.(pgx.QueryTracer); ok {
+			ctx = qt.TraceQueryStart(ctx, conn, data)
+		}
+	}
+	return ctx
+}
+
+func (t pgTracers) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	for _, tracer := range t {
+		if qt, ok := tracer.(pgx.QueryTracer); ok {
+			qt.TraceQueryEnd(ctx, conn, data)
+		}
+	}
+}
+
+func (t pgTracers) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireStartData) context.Context {
+	for _, tracer := range t {
+		if at, ok := tracer.(pgxpool.AcquireTracer); ok {
+			ctx = at.TraceAcquireStart(ctx, pool, data)
+		}
+	}
+	return ctx
+}
+
+func (t pgTracers) TraceAcquireEnd(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	for _, tracer := range t {
+		if at, ok := tracer.(pgxpool.AcquireTracer); ok {
+			at.TraceAcquireEnd(ctx, pool, data)
+		}
+	}
+}
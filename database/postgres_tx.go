@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// txRetryPolicy reruns the transactions aborted because of concurrent ones, as Postgres expects
+// its clients to do. Delays are kept short, the transactions they conflict with being short-lived too
+var txRetryPolicy = util.RetryPolicy{
+	Attempts:  5,
+	BaseDelay: 10 * time.Millisecond,
+	MaxDelay:  250 * time.Millisecond,
+	Retryable: isTxConflict,
+}
+
+// isTxConflict reports whether the transaction was aborted by a serialization failure or a deadlock,
+// which rerunning it resolves. Other errors aren't retried, e.g. a lost connection, as the
+// transaction may have been committed before the connection was lost
+func isTxConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// pgTransactor runs transactions on the pool, or savepoints within the transaction tx
+type pgTransactor struct {
+	pool   *pgxpool.Pool
+	tx     pgx.Tx
+	logger *zap.Logger
+}
+
+func (t *pgTransactor) withTx(ctx context.Context, stg Storage, fn func(tx Storage) error) error {
+	run := func(tx pgx.Tx) error {
+		// The pool isn't the transaction's to close nor to report on
+		stg.Conns.Close, stg.Conns.Health, stg.Conns.Stats = nil, nil, nil
+		stg.bindPostgres(tx)
+		stg.transactor = &pgTransactor{tx: tx, logger: t.logger}
+		return fn(stg)
+	}
+	if t.tx != nil {
+		// A conflict aborts the whole transaction, so it's only rerun by the outermost WithTx
+		return pgx.BeginFunc(ctx, t.tx, run)
+	}
+
+	logger := logging.FromContext(ctx, t.logger).With(zap.String("operation", "transaction"))
+	return util.Retry(ctx, logger, txRetryPolicy, func(ctx context.Context) error {
+		return pgx.BeginTxFunc(ctx, t.pool, pgx.TxOptions{IsoLevel: pgx.Serializable}, run)
+	})
+}
@@ -0,0 +1,154 @@
+/* Package diagnostics provides the runtime diagnostics used to investigate production
+* incidents: profiles and execution traces captured to files on demand, goroutine dumps
+* and garbage collector statistics. They're served on a dedicated listener, see api.NewDiagnosticsRouter.
+ */
+package diagnostics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MaxCaptureDuration bounds how long a capture may last
+const MaxCaptureDuration = 5 * time.Minute
+
+// ErrCaptureRunning is returned when starting a capture while another one is running
+var ErrCaptureRunning = errors.New("diagnostics: a capture is already running")
+
+// Profiler captures CPU profiles and execution traces to files, in the background and one at a time,
+// as the runtime only supports a single CPU profile or trace at once
+type Profiler struct {
+	dir    string
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	running bool
+}
+
+// NewProfiler instantiates a Profiler writing its captures in dir
+func NewProfiler(dir string, parentLogger *zap.Logger) *Profiler {
+	return &Profiler{dir: dir, logger: parentLogger.With(zap.String("component", "diagnostics"))}
+}
+
+// CaptureCPU starts capturing a CPU profile for d, returning the file it's written to.
+// The file can be analysed with `go tool pprof` once the capture is complete
+func (p *Profiler) CaptureCPU(d time.Duration) (string, error) {
+	return p.capture("cpu", ".pprof", d, pprof.StartCPUProfile, pprof.StopCPUProfile)
+}
+
+// CaptureTrace starts capturing an execution trace for d, returning the file it's written to.
+// The file can be analysed with `go tool trace` once the capture is complete
+func (p *Profiler) CaptureTrace(d time.Duration) (string, error) {
+	return p.capture("trace", ".out", d, trace.Start, trace.Stop)
+}
+
+func (p *Profiler) capture(kind, ext string, d time.Duration, start func(io.Writer) error, stop func()) (string, error) {
+	if d <= 0 || d > MaxCaptureDuration {
+		return "", fmt.Errorf("capture duration must be between 0 and %s", MaxCaptureDuration)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running {
+		return "", ErrCaptureRunning
+	}
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating profile directory: %w", err)
+	}
+	path := filepath.Join(p.dir, kind+"-"+time.Now().Format("2006-01-02T15-04-05")+ext)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error creating profile file: %w", err)
+	}
+	if err := start(file); err != nil {
+		// Most likely a profile requested through /debug/pprof is running
+		file.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("%w: %v", ErrCaptureRunning, err)
+	}
+	p.running = true
+	p.logger.Info("Capture started", zap.String("kind", kind), zap.Duration("duration", d), zap.String("file", path))
+
+	go func() {
+		time.Sleep(d)
+		stop()
+		err := file.Close()
+
+		p.mu.Lock()
+		p.running = false
+		p.mu.Unlock()
+		if err != nil {
+			p.logger.Error("Could not write capture", zap.String("file", path), zap.Error(err))
+			return
+		}
+		p.logger.Info("Capture complete", zap.String("kind", kind), zap.String("file", path))
+	}()
+	return path, nil
+}
+
+// WriteGoroutines writes the stack traces of all the goroutines to w
+func WriteGoroutines(w io.Writer) error {
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// GCStats is a summary of the garbage collector's activity and of the heap
+type GCStats struct {
+	NumGC         int64     `json:"numGC"`
+	LastGC        time.Time `json:"lastGC"`
+	PauseTotal    string    `json:"pauseTotal"`
+	RecentPauses  []string  `json:"recentPauses"` // Most recent first
+	HeapAlloc     uint64    `json:"heapAllocBytes"`
+	HeapInuse     uint64    `json:"heapInuseBytes"`
+	HeapObjects   uint64    `json:"heapObjects"`
+	NextGC        uint64    `json:"nextGCBytes"`
+	Sys           uint64    `json:"sysBytes"`
+	GCCPUFraction float64   `json:"gcCPUFraction"`
+	NumGoroutine  int       `json:"numGoroutine"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	MemoryLimit   int64     `json:"memoryLimitBytes"`
+	GoVersion     string    `json:"goVersion"`
+	CollectedAt   time.Time `json:"collectedAt"`
+}
+
+// ReadGCStats collects the GC statistics; it briefly stops the world
+func ReadGCStats() GCStats {
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	// ReadGCStats returns up to 256 pauses whatever the length of gc.Pause, only the 10 most recent are reported
+	gc.Pause = gc.Pause[:min(len(gc.Pause), 10)]
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	stats := GCStats{
+		NumGC:         gc.NumGC,
+		LastGC:        gc.LastGC,
+		PauseTotal:    gc.PauseTotal.String(),
+		HeapAlloc:     ms.HeapAlloc,
+		HeapInuse:     ms.HeapInuse,
+		HeapObjects:   ms.HeapObjects,
+		NextGC:        ms.NextGC,
+		Sys:           ms.Sys,
+		GCCPUFraction: ms.GCCPUFraction,
+		NumGoroutine:  runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		MemoryLimit:   debug.SetMemoryLimit(-1),
+		GoVersion:     runtime.Version(),
+		CollectedAt:   time.Now().UTC(),
+	}
+	for _, pause := range gc.Pause {
+		stats.RecentPauses = append(stats.RecentPauses, pause.String())
+	}
+	return stats
+}
@@ -0,0 +1,189 @@
+/* Package health reports whether the server is ready to serve traffic, so that
+* orchestrators and load balancers only route requests to replicas able to handle them.
+* Dependencies (database, cache, identity provider...) register a check, and readiness
+* is the combination of their results; it also fails once shutdown has begun, so that
+* traffic is drained away from the replica before it stops.
+ */
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Statuses of a check, and of the server's readiness
+const (
+	StatusOK           = "ok"
+	StatusFailing      = "failing"
+	StatusDisabled     = "disabled" // The dependency is not configured
+	StatusReady        = "ready"
+	StatusUnready      = "unready"
+	StatusShuttingDown = "shutting down"
+)
+
+// Check returns an error if the dependency it checks is unavailable
+type Check func(ctx context.Context) error
+
+// Cached returns a check reusing the result of check for ttl, so that frequent probes don't hit
+// the dependency each time. Checks cut short by their caller going away are not cached
+func Cached(check Check, ttl time.Duration) Check {
+	var (
+		mu        sync.Mutex
+		err       error
+		checkedAt time.Time
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			defer mu.Unlock()
+			return err
+		}
+		mu.Unlock()
+
+		result := check(ctx)
+		if errors.Is(context.Cause(ctx), context.Canceled) {
+			return result
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		err, checkedAt = result, time.Now()
+		return result
+	}
+}
+
+type registeredCheck struct {
+	name     string
+	check    Check
+	critical bool
+}
+
+// Checker runs the registered checks to determine the server's readiness
+type Checker struct {
+	timeout atomic.Int64 // time.Duration
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	checks   []registeredCheck
+	disabled []string
+
+	shuttingDown atomic.Bool
+	lastReady    atomic.Bool
+}
+
+// NewChecker instantiates a Checker; each check is given timeout to complete
+func NewChecker(timeout time.Duration, parentLogger *zap.Logger) *Checker {
+	c := &Checker{logger: parentLogger.With(zap.String("component", "health"))}
+	c.timeout.Store(int64(timeout))
+	c.lastReady.Store(true)
+	return c
+}
+
+// SetTimeout changes the time given to each check to complete
+func (c *Checker) SetTimeout(timeout time.Duration) {
+	c.timeout.Store(int64(timeout))
+}
+
+// Register adds a check of the dependency name. A failing critical check makes the server unready,
+// while non-critical ones are only reported (e.g. third-party services all replicas depend on alike,
+// which would otherwise take every replica out of rotation at once)
+func (c *Checker) Register(name string, check Check, critical bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, registeredCheck{name, check, critical})
+}
+
+// Disable reports the dependency name as not configured, rather than omitting it from the report
+func (c *Checker) Disable(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disabled = append(c.disabled, name)
+}
+
+// SetShuttingDown makes the server unready for good, whatever its checks' results
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Latency  string `json:"latency,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report is the server's readiness, with the breakdown of its checks
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Ready reports whether the server is ready
+func (r Report) Ready() bool {
+	return r.Status == StatusReady
+}
+
+// Check runs all the checks concurrently and combines their results
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append([]registeredCheck(nil), c.checks...)
+	disabled := append([]string(nil), c.disabled...)
+	c.mu.Unlock()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(checks)+len(disabled))}
+	for _, name := range disabled {
+		report.Checks[name] = CheckResult{Status: StatusDisabled}
+	}
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, rc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, rc)
+		}()
+	}
+	wg.Wait()
+
+	var failing []string
+	for i, rc := range checks {
+		report.Checks[rc.name] = results[i]
+		if results[i].Status == StatusFailing && rc.critical {
+			report.Status = StatusUnready
+			failing = append(failing, rc.name)
+		}
+	}
+	if c.shuttingDown.Load() {
+		report.Status = StatusShuttingDown
+	}
+
+	// Log transitions only, as readiness is probed every few seconds
+	if ready := report.Ready(); c.lastReady.Swap(ready) != ready {
+		if ready {
+			c.logger.Info("Server is ready again")
+		} else if report.Status == StatusUnready {
+			c.logger.Warn("Server is not ready", zap.Strings("failingChecks", failing))
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, rc registeredCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.timeout.Load()))
+	defer cancel()
+
+	start := time.Now()
+	err := rc.check(ctx)
+	result := CheckResult{Status: StatusOK, Critical: rc.critical, Latency: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,292 @@
+/* Package jobs implements a durable background job queue on top of the
+* database.QueueStorageHandler (Postgres, using SELECT ... FOR UPDATE SKIP LOCKED).
+* Work that mustn't hold up requests nor be lost on restart (imports, media processing,
+* notifications, data exports...) is enqueued as a typed job, and run by a pool of
+* workers per queue.
+ */
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/util"
+	"go.uber.org/zap"
+)
+
+// DefaultQueue is the queue jobs are enqueued in when none is specified
+const DefaultQueue = "default"
+
+// ErrNoHandler is returned when enqueueing a job whose kind has no registered handler
+var ErrNoHandler = errors.New("jobs: no handler registered for job kind")
+
+// JobArgs is implemented by the arguments of each type of job, binding them to a job kind.
+// Args are stored as JSON, so their fields must be exported
+type JobArgs interface {
+	Kind() string
+}
+
+// EnqueueOpts customizes how a job is enqueued, its zero value using the defaults
+type EnqueueOpts struct {
+	Queue       string    // DefaultQueue if empty
+	RunAt       time.Time // Now if zero
+	MaxAttempts int       // Config's Jobs.MaxAttempts if zero
+}
+
+// remainingError is returned by handlers which did part of their job, see Remaining
+type remainingError struct {
+	args JobArgs
+	err  error
+}
+
+func (e *remainingError) Error() string { return e.err.Error() }
+func (e *remainingError) Unwrap() error { return e.err }
+
+// Remaining is returned by handlers which did part of their job and failed to do the rest, args describing
+// the rest: the job is then retried (in-process, and through the queue) with args instead of its original args,
+// so that the part already done isn't done again. err is the failure, as handlers would otherwise return it
+func Remaining(args JobArgs, err error) error {
+	return &remainingError{args, err}
+}
+
+// handlerFunc runs a job from its raw JSON args
+type handlerFunc func(ctx context.Context, args []byte) error
+
+// Manager enqueues jobs and runs the workers consuming them
+type Manager struct {
+	db       database.QueueStorageHandler
+	handlers map[string]handlerFunc
+	cfg      config.Config
+	logger   *zap.Logger
+
+	// stopClaiming stops workers from claiming new jobs, cancelJobs interrupts the jobs being run
+	stopClaiming context.CancelFunc
+	cancelJobs   context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewManager instantiates a Manager; handlers must be added before calling Start
+func NewManager(cfg config.Config, db database.QueueStorageHandler, parentLogger *zap.Logger) *Manager {
+	if cfg.Jobs.Lease <= 0 {
+		cfg.Jobs.Lease = time.Minute
+	}
+	if cfg.Jobs.PollInterval <= 0 {
+		cfg.Jobs.PollInterval = time.Second
+	}
+	return &Manager{
+		db:       db,
+		handlers: make(map[string]handlerFunc),
+		cfg:      cfg,
+		logger:   parentLogger.With(zap.String("component", "jobs")),
+	}
+}
+
+// AddHandler registers h as the handler of the jobs whose args are of type T
+func AddHandler[T JobArgs](m *Manager, h func(ctx context.Context, args T) error) {
+	var zero T
+	m.handlers[zero.Kind()] = func(ctx context.Context, raw []byte) error {
+		var args T
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return fmt.Errorf("malformed args for job of kind %s: %w", zero.Kind(), err)
+		}
+		return h(ctx, args)
+	}
+}
+
+// Enqueue stores a job with args to be run by a worker of the chosen queue
+func (m *Manager) Enqueue(ctx context.Context, args JobArgs, opts EnqueueOpts) (int64, error) {
+	if _, ok := m.handlers[args.Kind()]; !ok {
+		return 0, fmt.Errorf("%w %s", ErrNoHandler, args.Kind())
+	}
+	if opts.Queue == "" {
+		opts.Queue = DefaultQueue
+	}
+	if _, ok := m.cfg.Jobs.Queues[opts.Queue]; !ok {
+		return 0, fmt.Errorf("queue %s is not configured", opts.Queue)
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = max(m.cfg.Jobs.MaxAttempts, 1)
+	}
+
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding job args: %w", err)
+	}
+	return m.db.EnqueueJob(ctx, database.QueuedJob{
+		Queue:       opts.Queue,
+		Kind:        args.Kind(),
+		Args:        raw,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       opts.RunAt,
+	})
+}
+
+// Start launches the configured number of workers for each queue. Workers stop claiming
+// jobs once ctx is cancelled (e.g. on shutdown signal); Shutdown waits for them
+func (m *Manager) Start(ctx context.Context) {
+	claimCtx, stopClaiming := context.WithCancel(ctx)
+	// Jobs being run must not be interrupted by the shutdown signal, only by Shutdown's deadline
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	m.stopClaiming, m.cancelJobs = stopClaiming, cancelJobs
+
+	for queue, concurrency := range m.cfg.Jobs.Queues {
+		m.logger.Info("Starting queue workers", zap.String("queue", queue), zap.Int("workers", concurrency))
+		for range concurrency {
+			m.wg.Add(1)
+			go m.work(claimCtx, jobCtx, queue)
+		}
+	}
+}
+
+// Shutdown stops workers from claiming jobs and waits for the running ones to complete.
+// Should ctx expire first, running jobs are cancelled: their lease then expires and
+// they're picked up again after restart
+func (m *Manager) Shutdown(ctx context.Context) error {
+	if m.stopClaiming == nil {
+		return nil
+	}
+	m.stopClaiming()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		m.cancelJobs()
+		return nil
+	case <-ctx.Done():
+		m.cancelJobs()
+		<-done
+		return fmt.Errorf("job workers did not stop in time, running jobs were interrupted: %w", ctx.Err())
+	}
+}
+
+// work claims and runs jobs from queue until claimCtx is cancelled
+func (m *Manager) work(claimCtx, jobCtx context.Context, queue string) {
+	defer m.wg.Done()
+	for {
+		if claimCtx.Err() != nil {
+			return
+		}
+
+		job, ok, err := m.db.ClaimJob(claimCtx, queue, m.cfg.Jobs.Lease)
+		if err != nil && claimCtx.Err() == nil {
+			m.logger.Error("Could not claim job", zap.String("queue", queue), zap.Error(err))
+		}
+		if err != nil || !ok {
+			select {
+			case <-claimCtx.Done():
+				return
+			case <-time.After(m.cfg.Jobs.PollInterval):
+			}
+			continue
+		}
+
+		m.run(jobCtx, job)
+	}
+}
+
+// run runs a claimed job, renewing its lease meanwhile, and then completes, retries or dead-letters it
+func (m *Manager) run(ctx context.Context, job database.QueuedJob) {
+	logger := m.logger.With(zap.String("queue", job.Queue), zap.String("kind", job.Kind), zap.Int64("jobID", job.ID))
+
+	// Losing the lease interrupts the job, another worker having claimed it
+	runCtx, lostLease := context.WithCancelCause(ctx)
+	defer lostLease(nil)
+	leaseCtx, stopLease := context.WithCancel(runCtx)
+	defer stopLease()
+	go m.renewLease(leaseCtx, job, lostLease, logger)
+
+	var err error
+	args := job.Args
+	handler, ok := m.handlers[job.Kind]
+	if !ok {
+		err = fmt.Errorf("%w %s", ErrNoHandler, job.Kind)
+	} else {
+		// Transient failures are retried in-process with exponential backoff first
+		err = util.RetryOperation(runCtx, logger, func() error {
+			err := handler(runCtx, args)
+			var remaining *remainingError
+			if errors.As(err, &remaining) {
+				raw, encodeErr := json.Marshal(remaining.args)
+				if encodeErr != nil {
+					return util.Permanent(fmt.Errorf("error encoding remaining job args: %w", encodeErr))
+				}
+				args = raw
+			}
+			return err
+		}, max(m.cfg.Jobs.RetryAttempts, 1), m.cfg.Jobs.RetryDelay)
+	}
+	stopLease()
+
+	// The job's outcome must be recorded even if the jobs' context was cancelled
+	storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	var storeErr error
+	switch {
+	case err == nil:
+		storeErr = m.db.CompleteJob(storeCtx, job.ID, job.LeaseToken)
+	case errors.Is(context.Cause(runCtx), database.ErrLeaseLost):
+		// The job now belongs to the worker which claimed it again
+		logger.Warn("Job lease lost to another worker, giving up the job", zap.Error(err))
+		return
+	case ctx.Err() != nil:
+		// Interrupted by shutdown: leave it to expire its lease rather than count it as a failed attempt
+		logger.Warn("Job interrupted by shutdown, it will run again", zap.Error(err))
+		return
+	case job.Attempts+1 >= job.MaxAttempts || !ok || util.IsPermanent(err):
+		logger.Error("Job failed for the last time, moving it to the dead-letter table", zap.Error(err))
+		storeErr = m.db.DeadLetterJob(storeCtx, job.ID, job.LeaseToken, args, err.Error())
+	default:
+		runAt := time.Now().Add(backoff(job.Attempts + 1))
+		logger.Warn("Job failed, handing it back to the queue", zap.Time("runAt", runAt), zap.Error(err))
+		storeErr = m.db.RetryJob(storeCtx, job.ID, job.LeaseToken, runAt, args, err.Error())
+	}
+	switch {
+	case errors.Is(storeErr, database.ErrLeaseLost):
+		// The lease expired before the outcome was recorded, and the job was claimed again
+		logger.Warn("Job lease lost to another worker, its outcome is discarded", zap.NamedError("outcome", err))
+	case storeErr != nil:
+		logger.Error("Could not record the job's outcome, it may run again", zap.Error(storeErr))
+	}
+}
+
+// renewLease extends the job's lease periodically until ctx is cancelled, so that long-running
+// jobs aren't claimed by other workers. Should the job have been claimed again meanwhile,
+// lostLease is called with database.ErrLeaseLost
+func (m *Manager) renewLease(ctx context.Context, job database.QueuedJob, lostLease context.CancelCauseFunc, logger *zap.Logger) {
+	ticker := time.NewTicker(m.cfg.Jobs.Lease / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := m.db.ExtendJobLease(ctx, job.ID, job.LeaseToken, m.cfg.Jobs.Lease)
+			if errors.Is(err, database.ErrLeaseLost) {
+				lostLease(err)
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Could not renew job lease", zap.Error(err))
+			}
+		}
+	}
+}
+
+// backoff is the delay before a job handed back to the queue is run again:
+// it doubles with each attempt, from 30 seconds up to 1 hour
+func backoff(attempts int) time.Duration {
+	delay := 30 * time.Second << min(attempts-1, 7)
+	return min(delay, time.Hour)
+}
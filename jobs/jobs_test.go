@@ -0,0 +1,274 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/util"
+	"go.uber.org/zap"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// testArgs are the args of the jobs run in tests, Items standing for the units of work of the job
+type testArgs struct {
+	Items []string `json:"items"`
+}
+
+func (testArgs) Kind() string { return "test" }
+
+// memoryQueue is a database.QueueStorageHandler holding a single claimed job, which records its outcome.
+// Like the Postgres queue, it fences the calls whose lease token isn't the job's current one
+type memoryQueue struct {
+	mu        sync.Mutex
+	job       database.QueuedJob
+	outcome   string // "completed", "retried" or "dead", empty until recorded
+	runAt     time.Time
+	args      []byte
+	lastError string
+}
+
+func (q *memoryQueue) EnqueueJob(ctx context.Context, job database.QueuedJob) (int64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (q *memoryQueue) ClaimJob(ctx context.Context, queue string, lease time.Duration) (database.QueuedJob, bool, error) {
+	return database.QueuedJob{}, false, nil
+}
+
+// reclaim has the job claimed again by another worker, as happens once a lease expires
+func (q *memoryQueue) reclaim() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.job.LeaseToken = "other-claim"
+}
+
+func (q *memoryQueue) fence(jobID int64, leaseToken string) error {
+	if jobID != q.job.ID || leaseToken != q.job.LeaseToken {
+		return database.ErrLeaseLost
+	}
+	return nil
+}
+
+func (q *memoryQueue) ExtendJobLease(ctx context.Context, jobID int64, leaseToken string, lease time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.fence(jobID, leaseToken)
+}
+
+func (q *memoryQueue) CompleteJob(ctx context.Context, jobID int64, leaseToken string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.fence(jobID, leaseToken); err != nil {
+		return err
+	}
+	q.outcome = "completed"
+	return nil
+}
+
+func (q *memoryQueue) RetryJob(ctx context.Context, jobID int64, leaseToken string, runAt time.Time, args []byte, lastError string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.fence(jobID, leaseToken); err != nil {
+		return err
+	}
+	q.outcome, q.runAt, q.args, q.lastError = "retried", runAt, args, lastError
+	return nil
+}
+
+func (q *memoryQueue) DeadLetterJob(ctx context.Context, jobID int64, leaseToken string, args []byte, lastError string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.fence(jobID, leaseToken); err != nil {
+		return err
+	}
+	q.outcome, q.args, q.lastError = "dead", args, lastError
+	return nil
+}
+
+// newTestManager returns a Manager running jobs through handler (if not nil) with 3 in-process
+// attempts, and the queue holding job, claimed with the lease token "claim"
+func newTestManager(t *testing.T, handler func(ctx context.Context, args testArgs) error, job database.QueuedJob) (*Manager, *memoryQueue) {
+	t.Helper()
+	var cfg config.Config
+	cfg.Jobs.Lease = 20 * time.Millisecond
+	cfg.Jobs.RetryAttempts = 3
+
+	raw, err := json.Marshal(testArgs{Items: []string{"a", "b", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.ID, job.Queue, job.Kind, job.Args, job.LeaseToken = 1, DefaultQueue, testArgs{}.Kind(), raw, "claim"
+	queue := &memoryQueue{job: job}
+
+	m := NewManager(cfg, queue, zap.NewNop())
+	if handler != nil {
+		AddHandler(m, handler)
+	}
+	return m, queue
+}
+
+func TestRun(t *testing.T) {
+	transient := syscall.ECONNREFUSED
+	tests := []struct {
+		name         string
+		attempts     int     // Of the job before this run, out of 5
+		errs         []error // Returned by the successive calls to the handler, nil once exhausted
+		noHandler    bool
+		wantCalls    int
+		wantOutcome  string
+		wantArgs     []string // Recorded with the outcome, unless it's "completed"
+		wantRetryErr bool     // Whether the recorded error is the transient one, after the in-process attempts
+	}{
+		{name: "success", wantCalls: 1, wantOutcome: "completed"},
+		{name: "transient then success", errs: []error{transient, transient}, wantCalls: 3, wantOutcome: "completed"},
+		{name: "transient", errs: []error{transient, transient, transient}, wantCalls: 3, wantOutcome: "retried",
+			wantArgs: []string{"a", "b", "c"}, wantRetryErr: true},
+		{name: "last attempt", attempts: 4, errs: []error{transient, transient, transient}, wantCalls: 3, wantOutcome: "dead",
+			wantArgs: []string{"a", "b", "c"}, wantRetryErr: true},
+		{name: "permanent", errs: []error{util.Permanent(errors.New("malformed"))}, wantCalls: 1, wantOutcome: "dead",
+			wantArgs: []string{"a", "b", "c"}},
+		{name: "no handler", noHandler: true, wantOutcome: "dead", wantArgs: []string{"a", "b", "c"}},
+		{name: "remaining", errs: []error{
+			Remaining(testArgs{Items: []string{"b", "c"}}, transient),
+			Remaining(testArgs{Items: []string{"c"}}, transient),
+			Remaining(testArgs{Items: []string{"c"}}, transient),
+		}, wantCalls: 3, wantOutcome: "retried", wantArgs: []string{"c"}, wantRetryErr: true},
+		{name: "remaining permanent", errs: []error{
+			Remaining(testArgs{Items: []string{"c"}}, util.Permanent(errors.New("unreachable"))),
+		}, wantCalls: 1, wantOutcome: "dead", wantArgs: []string{"c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls [][]string
+			handler := func(ctx context.Context, args testArgs) error {
+				calls = append(calls, args.Items)
+				if len(calls) <= len(tt.errs) {
+					return tt.errs[len(calls)-1]
+				}
+				return nil
+			}
+			if tt.noHandler {
+				handler = nil
+			}
+			m, queue := newTestManager(t, handler, database.QueuedJob{Attempts: tt.attempts, MaxAttempts: 5})
+
+			start := time.Now()
+			m.run(context.Background(), queue.job)
+
+			if len(calls) != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", len(calls), tt.wantCalls)
+			}
+			// Each call is handed the args remaining after the previous one
+			for i := 1; i < len(calls) && i < len(tt.errs); i++ {
+				var remaining *remainingError
+				if errors.As(tt.errs[i-1], &remaining) && !slices.Equal(calls[i], remaining.args.(testArgs).Items) {
+					t.Errorf("call %d handed %v, want %v", i+1, calls[i], remaining.args.(testArgs).Items)
+				}
+			}
+			if queue.outcome != tt.wantOutcome {
+				t.Fatalf("outcome = %q, want %q", queue.outcome, tt.wantOutcome)
+			}
+			if tt.wantOutcome == "completed" {
+				return
+			}
+
+			var args testArgs
+			if err := json.Unmarshal(queue.args, &args); err != nil {
+				t.Fatalf("recorded args %s: %v", queue.args, err)
+			}
+			if !slices.Equal(args.Items, tt.wantArgs) {
+				t.Errorf("recorded args = %v, want %v", args.Items, tt.wantArgs)
+			}
+			if want := "operation failed after 3 attempts: " + transient.Error(); tt.wantRetryErr && queue.lastError != want {
+				t.Errorf("recorded error = %q, want %q", queue.lastError, want)
+			}
+			if tt.wantOutcome == "retried" {
+				wantRunAt := start.Add(backoff(tt.attempts + 1))
+				if queue.runAt.Before(wantRunAt) || queue.runAt.After(wantRunAt.Add(time.Second)) {
+					t.Errorf("retried at %s, want %s", queue.runAt, wantRunAt)
+				}
+			}
+		})
+	}
+}
+
+func TestRunLeaseLost(t *testing.T) {
+	t.Run("while running", func(t *testing.T) {
+		// The lease renewal finds the job claimed again, which interrupts it
+		var queue *memoryQueue
+		m, queue := newTestManager(t, func(ctx context.Context, args testArgs) error {
+			queue.reclaim()
+			<-ctx.Done()
+			return ctx.Err()
+		}, database.QueuedJob{MaxAttempts: 5})
+
+		done := make(chan struct{})
+		go func() {
+			m.run(context.Background(), queue.job)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("job not interrupted after losing its lease")
+		}
+		if queue.outcome != "" {
+			t.Errorf("outcome = %q, want none", queue.outcome)
+		}
+	})
+
+	t.Run("before recording the outcome", func(t *testing.T) {
+		// The job completes, but was claimed again meanwhile: its outcome is fenced off
+		var queue *memoryQueue
+		m, queue := newTestManager(t, func(ctx context.Context, args testArgs) error {
+			queue.reclaim()
+			return nil
+		}, database.QueuedJob{MaxAttempts: 5})
+		m.cfg.Jobs.Lease = time.Hour // Not renewed before the job completes
+
+		m.run(context.Background(), queue.job)
+		if queue.outcome != "" {
+			t.Errorf("outcome = %q, want none", queue.outcome)
+		}
+	})
+}
+
+func TestRunInterruptedByShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m, queue := newTestManager(t, func(ctx context.Context, args testArgs) error {
+		cancel()
+		return ctx.Err()
+	}, database.QueuedJob{MaxAttempts: 5})
+
+	m.run(ctx, queue.job)
+	// Neither completed nor counted as a failed attempt: the job runs again once its lease expires
+	if queue.outcome != "" {
+		t.Errorf("outcome = %q, want none", queue.outcome)
+	}
+}
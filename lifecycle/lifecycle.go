@@ -0,0 +1,198 @@
+/* Package lifecycle starts and stops the server's components in order. Components
+* register start and stop hooks along with the components they depend on: they're started
+* after their dependencies, and stopped before them. Shutdown first fails readiness and waits
+* for a drain period, so that load balancers stop routing requests to the replica while it
+* still serves them, and then stops each component within its own deadline.
+ */
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/health"
+	"go.uber.org/zap"
+)
+
+// Hook starts or stops a component
+type Hook func(ctx context.Context) error
+
+// Component is a part of the server whose lifetime is managed by the Manager
+type Component struct {
+	Name string
+	// Start is given a context cancelled right before Stop is called, so that background work can be tied to it.
+	// Components without a Start hook are considered running as soon as they're registered
+	Start Hook
+	// Stop is given a context expiring after StopTimeout
+	Stop Hook
+	// DependsOn are the names of the components that must be started before, and stopped after, this one
+	DependsOn []string
+	// StopTimeout overrides the Shutdown.Timeout config for this component
+	StopTimeout time.Duration
+}
+
+// Manager starts and stops the registered components
+type Manager struct {
+	drainPeriod time.Duration
+	stopTimeout time.Duration
+	health      *health.Checker
+	logger      *zap.Logger
+
+	mu         sync.Mutex
+	components []*component
+}
+
+type component struct {
+	Component
+	running bool
+	cancel  context.CancelFunc
+}
+
+// NewManager instantiates a Manager, which fails the readiness of checker on shutdown
+func NewManager(cfg config.Config, checker *health.Checker, parentLogger *zap.Logger) *Manager {
+	return &Manager{
+		drainPeriod: cfg.Shutdown.DrainPeriod,
+		stopTimeout: cfg.Shutdown.Timeout,
+		health:      checker,
+		logger:      parentLogger.With(zap.String("component", "lifecycle")),
+	}
+}
+
+// Register adds c to the managed components; it must be called before Start
+func (m *Manager) Register(c Component) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.components = append(m.components, &component{Component: c, running: c.Start == nil})
+}
+
+// Start runs the start hooks of the components, dependencies first. Should one fail,
+// the components already running are stopped and its error is returned
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, err := m.order()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range order {
+		if c.running {
+			continue
+		}
+		// Components must keep running until they're stopped, whatever happens to ctx
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		m.logger.Info("Starting component", zap.String("name", c.Name))
+		if err := c.Start(runCtx); err != nil {
+			cancel()
+			m.stop(order)
+			return fmt.Errorf("could not start %s: %w", c.Name, err)
+		}
+		c.running, c.cancel = true, cancel
+	}
+	return nil
+}
+
+// Shutdown fails readiness and, if drain is set, waits for the drain period before stopping
+// the running components, dependents first. Errors are logged and returned together.
+// It can be called at any time, including before Start, and only stops each component once
+func (m *Manager) Shutdown(drain bool) error {
+	m.health.SetShuttingDown()
+	if drain && m.drainPeriod > 0 {
+		m.logger.Info("Draining before shutdown", zap.Duration("drainPeriod", m.drainPeriod))
+		time.Sleep(m.drainPeriod)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, err := m.order()
+	if err != nil {
+		// The dependencies are broken, stopping in reverse registration order is the best we can do
+		order = m.components
+	}
+	return m.stop(order)
+}
+
+// stop stops the running components of order, in reverse
+func (m *Manager) stop(order []*component) error {
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		c := order[i]
+		if !c.running {
+			continue
+		}
+		c.running = false
+		if c.cancel != nil {
+			c.cancel()
+		}
+		if c.Stop == nil {
+			continue
+		}
+
+		timeout := c.StopTimeout
+		if timeout == 0 {
+			timeout = m.stopTimeout
+		}
+		m.logger.Info("Stopping component", zap.String("name", c.Name), zap.Duration("timeout", timeout))
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := c.Stop(ctx)
+		cancel()
+		if err != nil {
+			m.logger.Error("Could not stop component cleanly", zap.String("name", c.Name), zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// order sorts the components so that each comes after its dependencies,
+// components being otherwise kept in registration order
+func (m *Manager) order() ([]*component, error) {
+	byName := make(map[string]*component, len(m.components))
+	for _, c := range m.components {
+		if _, ok := byName[c.Name]; ok {
+			return nil, fmt.Errorf("component %s is registered twice", c.Name)
+		}
+		byName[c.Name] = c
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(m.components))
+	order := make([]*component, 0, len(m.components))
+	var visit func(c *component) error
+	visit = func(c *component) error {
+		switch state[c.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("component %s is part of a dependency cycle", c.Name)
+		}
+		state[c.Name] = visiting
+		for _, name := range c.DependsOn {
+			dep, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("component %s depends on unknown component %s", c.Name, name)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[c.Name] = visited
+		order = append(order, c)
+		return nil
+	}
+
+	for _, c := range m.components {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,152 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/health"
+	"go.uber.org/zap"
+)
+
+// recorder registers components recording their starts and stops, in order
+type recorder struct {
+	m      *Manager
+	events []string
+}
+
+func newRecorder(t *testing.T) *recorder {
+	t.Helper()
+	var cfg config.Config
+	cfg.Shutdown.Timeout = time.Second
+	return &recorder{m: NewManager(cfg, health.NewChecker(time.Second, zap.NewNop()), zap.NewNop())}
+}
+
+// register adds the component name, whose hooks fail with startErr and stopErr
+func (r *recorder) register(name string, startErr, stopErr error, dependsOn ...string) {
+	r.m.Register(Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			r.events = append(r.events, "start "+name)
+			return startErr
+		},
+		Stop: func(ctx context.Context) error {
+			r.events = append(r.events, "stop "+name)
+			return stopErr
+		},
+		DependsOn: dependsOn,
+	})
+}
+
+func TestStartStopOrder(t *testing.T) {
+	r := newRecorder(t)
+	// Registered before their dependencies, which are started first nonetheless
+	r.register("router", nil, nil, "storage", "jobs")
+	r.register("jobs", nil, nil, "storage")
+	r.register("storage", nil, nil)
+
+	if err := r.m.Start(context.Background()); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if err := r.m.Shutdown(false); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	// Stopping again is a no-op
+	if err := r.m.Shutdown(false); err != nil {
+		t.Fatalf("second Shutdown() = %v", err)
+	}
+
+	want := []string{"start storage", "start jobs", "start router", "stop router", "stop jobs", "stop storage"}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("events = %v, want %v", r.events, want)
+	}
+}
+
+func TestShutdownWithFailingComponent(t *testing.T) {
+	r := newRecorder(t)
+	failure := errors.New("flush failed")
+	r.register("storage", nil, nil)
+	r.register("jobs", nil, failure, "storage")
+	r.register("router", nil, nil, "jobs")
+
+	if err := r.m.Start(context.Background()); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	err := r.m.Shutdown(false)
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "jobs") {
+		t.Errorf("Shutdown() = %v, want the failure of jobs", err)
+	}
+
+	// The components after the failing one are stopped all the same, in order
+	want := []string{"start storage", "start jobs", "start router", "stop router", "stop jobs", "stop storage"}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("events = %v, want %v", r.events, want)
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	r := newRecorder(t)
+	failure := errors.New("port in use")
+	r.register("storage", nil, nil)
+	r.register("jobs", nil, nil, "storage")
+	r.register("router", failure, nil, "jobs")
+	r.register("admin", nil, nil, "router")
+
+	err := r.m.Start(context.Background())
+	if !errors.Is(err, failure) {
+		t.Fatalf("Start() = %v, want %v", err, failure)
+	}
+	// The components already running are stopped, the failed one and those after it never having started
+	want := []string{"start storage", "start jobs", "start router", "stop jobs", "stop storage"}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("events = %v, want %v", r.events, want)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	r := newRecorder(t)
+	r.m.Register(Component{
+		Name:        "slow",
+		Start:       func(ctx context.Context) error { return nil },
+		Stop:        func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() },
+		StopTimeout: 10 * time.Millisecond,
+	})
+	r.register("fast", nil, nil, "slow")
+
+	if err := r.m.Start(context.Background()); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if err := r.m.Shutdown(false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() = %v, want the deadline of slow exceeded", err)
+	}
+	if want := []string{"start fast", "stop fast"}; !slices.Equal(r.events, want) {
+		t.Errorf("events = %v, want %v", r.events, want)
+	}
+}
+
+func TestInvalidDependencies(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []Component
+		want       string
+	}{
+		{"unknown", []Component{{Name: "jobs", DependsOn: []string{"storage"}}}, "unknown component storage"},
+		{"cycle", []Component{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}, "dependency cycle"},
+		{"duplicate", []Component{{Name: "a"}, {Name: "a"}}, "registered twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRecorder(t)
+			for _, c := range tt.components {
+				r.m.Register(c)
+			}
+			if err := r.m.Start(context.Background()); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Start() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/charm-113c/project-zero/tracing"
+	"go.uber.org/zap"
+)
+
+// Field keys under which the request and trace IDs are logged
+const (
+	RequestIDKey = "requestID"
+	TraceIDKey   = "traceID"
+	SpanIDKey    = "spanID"
+)
+
+type requestIDCtxKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return requestID
+}
+
+// FromContext returns a child of logger carrying the request ID and the trace and span IDs
+// of ctx, if any, so that every log line written while serving a request can be traced back to it
+func FromContext(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	if fields := ContextFields(ctx); len(fields) > 0 {
+		return logger.With(fields...)
+	}
+	return logger
+}
+
+// ContextFields returns the fields identifying the request and span carried by ctx
+func ContextFields(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		fields = append(fields, zap.String(RequestIDKey, requestID))
+	}
+	if sc := tracing.SpanFromContext(ctx).Context(); sc.IsValid() {
+		fields = append(fields, zap.String(TraceIDKey, sc.TraceID.String()), zap.String(SpanIDKey, sc.SpanID.String()))
+	}
+	return fields
+}
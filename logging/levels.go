@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ComponentKey is the field key identifying the component a logger belongs to,
+// as in parentLogger.With(zap.String("component", "database"))
+const ComponentKey = "component"
+
+// Levels holds the log levels of the server, which can be changed at runtime:
+// a root level, and optionally a level per component. Components without
+// their own level follow the root level.
+type Levels struct {
+	mu         sync.Mutex
+	root       zap.AtomicLevel
+	components map[string]zap.AtomicLevel
+	overridden map[string]bool
+}
+
+// NewLevels instantiates Levels with the given root level
+func NewLevels(root zapcore.Level) *Levels {
+	return &Levels{
+		root:       zap.NewAtomicLevelAt(root),
+		components: make(map[string]zap.AtomicLevel),
+		overridden: make(map[string]bool),
+	}
+}
+
+// Component returns the level of component, an empty name designating the root level
+func (l *Levels) Component(name string) zap.AtomicLevel {
+	if name == "" {
+		return l.root
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level, ok := l.components[name]
+	if !ok {
+		level = zap.NewAtomicLevelAt(l.root.Level())
+		l.components[name] = level
+	}
+	return level
+}
+
+// SetLevel sets the level of component, an empty name designating the root level.
+// Setting the root level also sets the level of the components following it
+func (l *Levels) SetLevel(component string, level zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if component == "" {
+		l.root.SetLevel(level)
+		for name, lvl := range l.components {
+			if !l.overridden[name] {
+				lvl.SetLevel(level)
+			}
+		}
+		return
+	}
+
+	lvl, ok := l.components[component]
+	if !ok {
+		lvl = zap.NewAtomicLevelAt(level)
+		l.components[component] = lvl
+	}
+	lvl.SetLevel(level)
+	l.overridden[component] = true
+}
+
+// ResetLevel makes component follow the root level again
+func (l *Levels) ResetLevel(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.overridden, component)
+	if lvl, ok := l.components[component]; ok {
+		lvl.SetLevel(l.root.Level())
+	}
+}
+
+// LevelsSnapshot is the state of Levels at a given time
+type LevelsSnapshot struct {
+	Root       string            `json:"root"`
+	Components map[string]string `json:"components"` // Only the components with their own level
+}
+
+// Snapshot returns the current levels
+func (l *Levels) Snapshot() LevelsSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	snap := LevelsSnapshot{Root: l.root.Level().String(), Components: make(map[string]string)}
+	names := make([]string, 0, len(l.overridden))
+	for name := range l.overridden {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		snap.Components[name] = l.components[name].Level().String()
+	}
+	return snap
+}
+
+// Core wraps core so that entries are filtered by the level of the component of the logger
+// writing them (see ComponentKey), or by the root level for loggers without a component.
+// The cores wrapped should not filter below the lowest level that may be set at runtime
+func (l *Levels) Core(core zapcore.Core) zapcore.Core {
+	return &levelCore{core, l, l.root}
+}
+
+// levelCore filters entries by a component's level before handing them to the wrapped core
+type levelCore struct {
+	zapcore.Core
+	levels *Levels
+	level  zap.AtomicLevel
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.level.Enabled(lvl)
+}
+
+func (c *levelCore) Level() zapcore.Level {
+	return c.level.Level()
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	level := c.level
+	for _, f := range fields {
+		if f.Key == ComponentKey && f.Type == zapcore.StringType {
+			level = c.levels.Component(f.String)
+		}
+	}
+	return &levelCore{c.Core.With(fields), c.levels, level}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(ent.Level) {
+		return ce
+	}
+	// The wrapped core adds itself (or, for a tee, the cores enabled for this entry)
+	return c.Core.Check(ent, ce)
+}
@@ -0,0 +1,327 @@
+/* Package logging provides the building blocks of the server's zap loggers,
+* starting with RotatingFile, a log file that rotates itself.
+ */
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// backupTimeFormat is the timestamp appended to rotated files' names
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotateOpts controls when a RotatingFile rotates and how long its backups are kept.
+// Zero values disable the corresponding limit
+type RotateOpts struct {
+	MaxSize     int64         // Rotate once the file exceeds this many bytes
+	RotateEvery time.Duration // Rotate once the file has been written to for this long
+	MaxAge      time.Duration // Remove backups older than this
+	MaxBackups  int           // Keep at most this many backups
+	Compress    bool          // Gzip backups
+}
+
+// RotatingFile is a zapcore.WriteSyncer writing to a file which is rotated by size and age:
+// the current file is renamed with a timestamp suffix, optionally compressed, and a new file
+// is opened in its place. Old backups are pruned according to the retention limits.
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	opts     RotateOpts
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// Compression and pruning are done in the background, one rotation at a time
+	mill     chan struct{}
+	millDone chan struct{}
+	// logger reports the mill's failures, the logger writing to the file being built after it
+	logger atomic.Pointer[zap.Logger]
+}
+
+// OpenRotatingFile opens (or creates) the file at path, appending to it
+func OpenRotatingFile(path string, opts RotateOpts) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:     path,
+		opts:     opts,
+		mill:     make(chan struct{}, 1),
+		millDone: make(chan struct{}),
+	}
+	r.logger.Store(zap.NewNop())
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	go r.runMill()
+	return r, nil
+}
+
+// SetLogger sets the logger reporting failures to compress backups, which are otherwise ignored
+func (r *RotatingFile) SetLogger(logger *zap.Logger) {
+	r.logger.Store(logger)
+}
+
+// open opens the file at r.path, the caller must hold r.mu
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %w", err)
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading log file info: %w", err)
+	}
+	r.file, r.size, r.openedAt = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would exceed its size or age limit
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	sizeExceeded := r.opts.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.opts.MaxSize
+	ageExceeded := r.opts.RotateEvery > 0 && time.Since(r.openedAt) >= r.opts.RotateEvery
+	if sizeExceeded || ageExceeded {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Sync flushes the file to disk
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Close closes the file and waits for pending compression and pruning to complete
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	if r.file == nil {
+		r.mu.Unlock()
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	close(r.mill)
+	r.mu.Unlock()
+
+	<-r.millDone
+	return err
+}
+
+// Rotate rotates the file immediately
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	return r.rotate()
+}
+
+// Reopen is meant to be called on SIGHUP, for compatibility with logrotate: should the file
+// have been moved away (by logrotate's default "create" mode), a new one is opened at the
+// original path; otherwise the file is rotated like with Rotate
+func (r *RotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+
+	current, err := r.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading log file info: %w", err)
+	}
+	onDisk, err := os.Stat(r.path)
+	if err == nil && os.SameFile(current, onDisk) {
+		return r.rotate()
+	}
+
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %w", err)
+	}
+	return r.open()
+}
+
+// rotate renames the current file to a timestamped backup and opens a new one, the caller must hold r.mu
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %w", err)
+	}
+	if err := os.Rename(r.path, r.backupName(time.Now())); err != nil && !os.IsNotExist(err) {
+		// Keep logging to the current file rather than losing logs
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("error renaming log file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	select {
+	case r.mill <- struct{}{}:
+	default:
+		// The mill already has work pending, which will take this backup into account
+	}
+	return nil
+}
+
+// backupName returns the name of the backup of the file rotated at t, e.g. app-2025-07-28T10-00-00.000.log
+func (r *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	return base + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// runMill compresses and prunes backups each time the file is rotated, until the file is closed
+func (r *RotatingFile) runMill() {
+	defer close(r.millDone)
+	for range r.mill {
+		r.millBackups()
+	}
+}
+
+type backup struct {
+	path string
+	time time.Time
+}
+
+func (r *RotatingFile) millBackups() {
+	backups := r.listBackups()
+
+	// Newest first, so that the ones beyond MaxBackups are at the end
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+
+	var keep []backup
+	for i, b := range backups {
+		tooMany := r.opts.MaxBackups > 0 && i >= r.opts.MaxBackups
+		tooOld := r.opts.MaxAge > 0 && time.Since(b.time) > r.opts.MaxAge
+		if tooMany || tooOld {
+			os.Remove(b.path)
+			continue
+		}
+		keep = append(keep, b)
+	}
+
+	if r.opts.Compress {
+		for _, b := range keep {
+			if !strings.HasSuffix(b.path, ".gz") {
+				if err := compressFile(b.path); err != nil {
+					r.logger.Load().Warn("Could not compress log backup", zap.String("path", b.path), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+// listBackups returns the backups of the file, compressed or not, with their rotation time
+func (r *RotatingFile) listBackups() []backup {
+	ext := filepath.Ext(r.path)
+	prefix := filepath.Base(strings.TrimSuffix(r.path, ext)) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return nil
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{filepath.Join(filepath.Dir(r.path), name), t})
+	}
+	return backups
+}
+
+// compressFile gzips the file at path into path.gz and removes the original
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Files is the set of RotatingFiles a logger writes to, so that they can be rotated and closed together
+type Files []*RotatingFile
+
+// Reopen calls Reopen on every file, e.g. on SIGHUP
+func (f Files) Reopen() error {
+	var errs []error
+	for _, file := range f {
+		if err := file.Reopen(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetLogger calls SetLogger on every file
+func (f Files) SetLogger(logger *zap.Logger) {
+	for _, file := range f {
+		file.SetLogger(logger)
+	}
+}
+
+// Close closes every file
+func (f Files) Close() error {
+	var errs []error
+	for _, file := range f {
+		if err := file.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
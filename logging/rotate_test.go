@@ -0,0 +1,186 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readBackups returns the contents of the backups of the file at path, oldest first, decompressing them
+func readBackups(t *testing.T, path string) []string {
+	t.Helper()
+	ext := filepath.Ext(path)
+	names, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names) // The timestamps sort chronologically
+
+	var contents []string
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(name, ".gz") {
+			if r, err = gzip.NewReader(f); err != nil {
+				t.Fatalf("reading %s: %v", name, err)
+			}
+		}
+		content, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		contents = append(contents, string(content))
+	}
+	return contents
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// write writes each of lines to r, waiting between them so that each rotation gets its own timestamp
+func write(t *testing.T, r *RotatingFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) = %v", line, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func TestRotateAtSizeThreshold(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []string
+		wantBackups []string
+		wantCurrent string
+	}{
+		{"below", []string{"12345", "6789"}, nil, "123456789"},
+		{"at the threshold", []string{"12345", "67890"}, nil, "1234567890"},
+		{"beyond the threshold", []string{"12345", "67890", "a"}, []string{"1234567890"}, "a"},
+		{"several rotations", []string{"123456", "7890a", "bcdefg"}, []string{"123456", "7890a"}, "bcdefg"},
+		// A line bigger than the limit still goes to a file of its own, rather than being split or dropped
+		{"line bigger than the threshold", []string{"1", "0123456789abc", "2"}, []string{"1", "0123456789abc"}, "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			r, err := OpenRotatingFile(path, RotateOpts{MaxSize: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(t, r, tt.lines...)
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readBackups(t, path); !slices.Equal(got, tt.wantBackups) {
+				t.Errorf("backups = %q, want %q", got, tt.wantBackups)
+			}
+			if got := readFile(t, path); got != tt.wantCurrent {
+				t.Errorf("current file = %q, want %q", got, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestRotateAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("12345678"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The size of the file is taken into account from the start
+	r, err := OpenRotatingFile(path, RotateOpts{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, r, "abc")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readBackups(t, path), []string{"12345678"}; !slices.Equal(got, want) {
+		t.Errorf("backups = %q, want %q", got, want)
+	}
+}
+
+func TestRotateRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	r, err := OpenRotatingFile(path, RotateOpts{MaxSize: 1, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, r, "a", "b", "c", "d", "e")
+	if err := r.Close(); err != nil { // Waits for the backups to be compressed and pruned
+		t.Fatal(err)
+	}
+
+	// Only the newest backups are kept, compressed
+	if got, want := readBackups(t, path), []string{"c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("backups = %q, want %q", got, want)
+	}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "app-*.log"))
+	if len(matches) > 0 {
+		t.Errorf("uncompressed backups left: %v", matches)
+	}
+}
+
+func TestReopenAfterMove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	r, err := OpenRotatingFile(path, RotateOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, r, "before")
+
+	// As logrotate does in its "create" mode, before sending SIGHUP
+	moved := filepath.Join(dir, "app.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reopen(); err != nil {
+		t.Fatalf("Reopen() = %v", err)
+	}
+	write(t, r, "after")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, moved); got != "before" {
+		t.Errorf("moved file = %q, want %q", got, "before")
+	}
+	if got := readFile(t, path); got != "after" {
+		t.Errorf("reopened file = %q, want %q", got, "after")
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	r, err := OpenRotatingFile(filepath.Join(t.TempDir(), "app.log"), RotateOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("late")); err != os.ErrClosed {
+		t.Errorf("Write() after Close = %v, want os.ErrClosed", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/metrics"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// version is set at build time, see the Makefile
+var version = "dev"
+
+// command is a command of the binary, e.g. `backend db ping`. A command either runs, or groups subcommands
+type command struct {
+	name    string
+	args    string // Synopsis of the arguments, e.g. "[-limit n] <id>"
+	summary string
+	run     func(ctx context.Context, args []string) error
+	sub     []*command
+}
+
+// rootCommand returns the commands of the binary; new commands are registered here.
+// Without a command, the server is started
+func rootCommand() *command {
+	serve := serveCommand()
+	return &command{
+		name: "backend",
+		run:  serve.run,
+		sub: []*command{
+			serve,
+			versionCommand(),
+			configCommand(),
+			dbCommand(),
+			userCommand(),
+			seedCommand(),
+		},
+	}
+}
+
+// errUsage is returned when a command is given invalid arguments, whose usage is then printed
+var errUsage = errors.New("invalid usage")
+
+// execute runs the command, or the subcommand designated by args
+func (c *command) execute(ctx context.Context, path string, args []string) error {
+	if len(c.sub) == 0 {
+		return c.run(ctx, args)
+	}
+	if len(args) == 0 {
+		if c.run != nil {
+			return c.run(ctx, args)
+		}
+		c.usage(os.Stderr, path)
+		return errUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		c.usage(os.Stdout, path)
+		return nil
+	}
+	for _, sub := range c.sub {
+		if sub.name == args[0] {
+			err := sub.execute(ctx, path+" "+sub.name, args[1:])
+			if errors.Is(err, flag.ErrHelp) {
+				return nil // The flags have printed their usage
+			}
+			if errors.Is(err, errUsage) && len(sub.sub) == 0 {
+				sub.usage(os.Stderr, path+" "+sub.name)
+			}
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+	c.usage(os.Stderr, path)
+	return errUsage
+}
+
+func (c *command) usage(w io.Writer, path string) {
+	if len(c.sub) == 0 {
+		fmt.Fprintf(w, "Usage: %s %s\n", path, c.args)
+		return
+	}
+	fmt.Fprintf(w, "Usage: %s <command>\n\nCommands:\n", path)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, sub := range c.sub {
+		synopsis := sub.args
+		if len(sub.sub) > 0 {
+			synopsis = "<command>"
+		}
+		fmt.Fprintf(tw, "  %s %s\t%s\n", sub.name, synopsis, sub.summary)
+	}
+	tw.Flush()
+}
+
+// newFlags returns a flag set for the command at path, whose errors are returned rather than exiting
+func newFlags(path string) *flag.FlagSet {
+	flags := flag.NewFlagSet(path, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	return flags
+}
+
+func serveCommand() *command {
+	return &command{
+		name:    "serve",
+		summary: "start the server (default)",
+		run: func(ctx context.Context, args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+			if err := run(ctx); err != nil {
+				return fmt.Errorf("server has run into an error: %w", err)
+			}
+			log.Println("Server has shutdown properly")
+			return nil
+		},
+	}
+}
+
+func versionCommand() *command {
+	return &command{
+		name:    "version",
+		summary: "print the version of the binary",
+		run: func(ctx context.Context, args []string) error {
+			fmt.Printf("backend %s, %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+			if info, ok := debug.ReadBuildInfo(); ok {
+				for _, setting := range info.Settings {
+					if strings.HasPrefix(setting.Key, "vcs.") {
+						fmt.Printf("%s: %s\n", setting.Key, setting.Value)
+					}
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// withStorage loads and validates the config, and connects to the storage like the server does, for maintenance
+// commands to run fn against it. Logs are written to stderr; those of the storage are limited to warnings
+func withStorage(ctx context.Context, fn func(ctx context.Context, cfg config.Config, storage *database.Storage, logger *zap.Logger) error) error {
+	return withDB(ctx, database.StartStorage, nil, fn)
+}
+
+// withConnection is withStorage for the commands which only inspect the DB, and must therefore not migrate its schema
+func withConnection(ctx context.Context, fn func(ctx context.Context, cfg config.Config, storage *database.Storage, logger *zap.Logger) error) error {
+	return withDB(ctx, database.ConnectStorage, nil, fn)
+}
+
+// withDB connects to the storage with start, once the config is loaded and has passed check, if not nil
+func withDB(
+	ctx context.Context,
+	start func(ctx context.Context, cfg config.Config, storage *database.Storage, reg *metrics.Registry, parentLogger *zap.Logger) error,
+	check func(cfg config.Config) error,
+	fn func(ctx context.Context, cfg config.Config, storage *database.Storage, logger *zap.Logger) error,
+) error {
+	var cfg config.Config
+	if err := config.LoadConfig(&cfg); err != nil {
+		return fmt.Errorf("couldn't load configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("the current configuration is not valid: %w", err)
+	}
+	if check != nil {
+		if err := check(cfg); err != nil {
+			return err
+		}
+	}
+
+	logCfg := zap.NewProductionConfig()
+	logCfg.Encoding = "console"
+	logCfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	logger, err := logCfg.Build()
+	if err != nil {
+		return fmt.Errorf("couldn't start logger: %w", err)
+	}
+	defer logger.Sync()
+
+	storage := new(database.Storage)
+	storageLogger := logger.WithOptions(zap.IncreaseLevel(zapcore.WarnLevel))
+	if err := start(ctx, cfg, storage, metrics.NewRegistry(), storageLogger); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer storage.Conns.Close.CloseConns()
+	return fn(ctx, cfg, storage, logger)
+}
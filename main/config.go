@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/charm-113c/project-zero/config"
+)
+
+func configCommand() *command {
+	return &command{
+		name:    "config",
+		summary: "inspect the configuration",
+		sub: []*command{
+			{
+				name:    "show",
+				args:    "[-json]",
+				summary: "print the effective config, secrets redacted, with the source of each value",
+				run:     configShow,
+			},
+			{
+				name:    "check",
+				summary: "validate the config without starting the server",
+				run:     configCheck,
+			},
+			{
+				name:    "example",
+				summary: "print a YAML config file documenting every setting, set to its default",
+				run:     configExample,
+			},
+		},
+	}
+}
+
+// configShow prints the effective config and the source of each value, whether it's valid or not
+func configShow(ctx context.Context, args []string) error {
+	flags := newFlags("backend config show")
+	asJSON := flags.Bool("json", false, "print the settings as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	sources, err := config.LoadConfigSources(&cfg)
+	if err != nil {
+		return fmt.Errorf("couldn't load configuration: %w", err)
+	}
+	settings := cfg.Settings(sources)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(settings)
+	}
+
+	fmt.Printf("# .env file: %s\n# YAML file: %s\n", cfg.CfgPaths.EnvPath, cfg.CfgPaths.CfgPath)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "YAML KEY\tENV VAR\tVALUE\tSOURCE")
+	for _, s := range settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.YAML, s.Env, s.Value, s.Source)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nWarning: %v\n", err)
+	}
+	return nil
+}
+
+func configCheck(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	var cfg config.Config
+	if err := config.LoadConfig(&cfg); err != nil {
+		return fmt.Errorf("couldn't load configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("the configuration is not valid: %w", err)
+	}
+	fmt.Println("The configuration is valid")
+	return nil
+}
+
+func configExample(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	example, err := config.ExampleYAML()
+	if err != nil {
+		return fmt.Errorf("couldn't generate example: %w", err)
+	}
+	_, err = os.Stdout.Write(example)
+	return err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"go.uber.org/zap"
+)
+
+func dbCommand() *command {
+	return &command{
+		name:    "db",
+		summary: "inspect the database",
+		sub: []*command{
+			{
+				name:    "ping",
+				args:    "[-timeout d]",
+				summary: "check that the database is reachable",
+				run:     dbPing,
+			},
+			{
+				name:    "stats",
+				args:    "[-json]",
+				summary: "print the state of the database and of its connections",
+				run:     dbStats,
+			},
+			{
+				name:    "outbox",
+				args:    "[-json]",
+				summary: "print the offset, lag and failures of every outbox consumer",
+				run:     dbOutbox,
+			},
+		},
+	}
+}
+
+func dbPing(ctx context.Context, args []string) error {
+	flags := newFlags("backend db ping")
+	timeout := flags.Duration("timeout", 5*time.Second, "time given to the database to answer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	return withConnection(ctx, func(ctx context.Context, cfg config.Config, storage *database.Storage, _ *zap.Logger) error {
+		start := time.Now()
+		if err := storage.Conns.Health.Ping(ctx); err != nil {
+			return fmt.Errorf("database is unreachable: %w", err)
+		}
+		fmt.Printf("Database %s at %s:%d is reachable, ping took %s\n",
+			cfg.Database.DBName, cfg.Database.Host, cfg.Database.Port, time.Since(start).Round(time.Microsecond))
+		return nil
+	})
+}
+
+func dbStats(ctx context.Context, args []string) error {
+	flags := newFlags("backend db stats")
+	asJSON := flags.Bool("json", false, "print the statistics as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withConnection(ctx, func(ctx context.Context, cfg config.Config, storage *database.Storage, _ *zap.Logger) error {
+		server, err := storage.Conns.Stats.ServerStats(ctx)
+		if err != nil {
+			return err
+		}
+		// The pool is this command's own, its statistics show how it's configured rather than the server's load
+		pool := storage.Conns.Stats.PoolStats()
+
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(struct {
+				Server database.ServerStats `json:"server"`
+				Pool   database.PoolStats   `json:"pool"`
+			}{server, pool})
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Server version\t%s\n", server.Version)
+		fmt.Fprintf(w, "Database size\t%.1f MB\n", float64(server.SizeBytes)/(1024*1024))
+		fmt.Fprintf(w, "Max connections\t%d\n", server.MaxConnections)
+		for _, state := range sortedKeys(server.Connections) {
+			fmt.Fprintf(w, "Connections %s\t%d\n", state, server.Connections[state])
+		}
+		fmt.Fprintf(w, "Pool max connections (per replica)\t%d\n", pool.MaxConns)
+		for _, table := range sortedKeys(server.TableRows) {
+			fmt.Fprintf(w, "Rows in %s (estimated)\t%d\n", table, server.TableRows[table])
+		}
+		return w.Flush()
+	})
+}
+
+func dbOutbox(ctx context.Context, args []string) error {
+	flags := newFlags("backend db outbox")
+	asJSON := flags.Bool("json", false, "print the offsets as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withConnection(ctx, func(ctx context.Context, _ config.Config, storage *database.Storage, _ *zap.Logger) error {
+		offsets, err := storage.Conns.OutboxTableOps.OutboxOffsets(ctx)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(offsets)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CONSUMER\tTXID\tEVENT\tLAG\tFAILURES\tDEAD\tUPDATED\tLAST ERROR")
+		for _, o := range offsets {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", o.Consumer, o.TxID, o.EventID, o.Lag, o.Failures, o.DeadLetters,
+				o.UpdatedAt.Format(time.RFC3339), o.LastError)
+		}
+		return w.Flush()
+	})
+}
+
+func userCommand() *command {
+	return &command{
+		name:    "user",
+		summary: "manage user accounts",
+		sub: []*command{
+			{
+				name:    "list",
+				args:    "[-limit n] [-after id] [-json]",
+				summary: "list the accounts, ordered by ID",
+				run:     userList,
+			},
+			{
+				name:    "disable",
+				args:    "<id>",
+				summary: "disable an account, its user is signed out of every device",
+				run:     func(ctx context.Context, args []string) error { return userSetDisabled(ctx, args, true) },
+			},
+			{
+				name:    "enable",
+				args:    "<id>",
+				summary: "enable a disabled account again",
+				run:     func(ctx context.Context, args []string) error { return userSetDisabled(ctx, args, false) },
+			},
+		},
+	}
+}
+
+func userList(ctx context.Context, args []string) error {
+	flags := newFlags("backend user list")
+	limit := flags.Int("limit", 50, "maximum number of accounts to list")
+	after := flags.String("after", "", "list the accounts after this ID, to get the next page")
+	asJSON := flags.Bool("json", false, "print the accounts as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return errUsage
+	}
+
+	return withStorage(ctx, func(ctx context.Context, _ config.Config, storage *database.Storage, _ *zap.Logger) error {
+		accounts, err := storage.Conns.AccTableOps.ListAccounts(ctx, *after, *limit)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(accounts)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tCREATED\tDISABLED")
+		for _, a := range accounts {
+			disabled := "-"
+			if a.DisabledAt != nil {
+				disabled = a.DisabledAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.ID, a.Username, a.Email, a.CreatedAt.Format(time.RFC3339), disabled)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if len(accounts) == *limit {
+			fmt.Fprintf(os.Stderr, "More accounts may follow, see -after %s\n", accounts[len(accounts)-1].ID)
+		}
+		return nil
+	})
+}
+
+func userSetDisabled(ctx context.Context, args []string, disabled bool) error {
+	if len(args) != 1 || args[0] == "" {
+		return errUsage
+	}
+	userID := args[0]
+
+	return withStorage(ctx, func(ctx context.Context, _ config.Config, storage *database.Storage, _ *zap.Logger) error {
+		err := storage.Conns.AccTableOps.SetAccountDisabled(ctx, userID, disabled)
+		if errors.Is(err, database.ErrNotFound) {
+			return fmt.Errorf("no account with ID %s", userID)
+		}
+		if err != nil {
+			return err
+		}
+		if disabled {
+			fmt.Printf("Account %s disabled\n", userID)
+		} else {
+			fmt.Printf("Account %s enabled\n", userID)
+		}
+		return nil
+	})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,10 +13,24 @@ import (
 	"time"
 
 	"github.com/charm-113c/project-zero/api"
+	authmw "github.com/charm-113c/project-zero/api/middleware"
+	"github.com/charm-113c/project-zero/certs"
 	"github.com/charm-113c/project-zero/config"
 	"github.com/charm-113c/project-zero/database"
-	"github.com/charm-113c/project-zero/util"
+	"github.com/charm-113c/project-zero/diagnostics"
+	"github.com/charm-113c/project-zero/health"
+	"github.com/charm-113c/project-zero/jobs"
+	"github.com/charm-113c/project-zero/lifecycle"
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/metrics"
+	"github.com/charm-113c/project-zero/notifications"
+	"github.com/charm-113c/project-zero/outbox"
+	"github.com/charm-113c/project-zero/pubsub"
+	"github.com/charm-113c/project-zero/push"
+	"github.com/charm-113c/project-zero/scheduler"
+	"github.com/charm-113c/project-zero/tracing"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // Server is the struct containing vital server data
@@ -25,19 +40,20 @@ type Server struct {
 }
 
 func main() {
-	if err := run(); err != nil {
-		log.Println("FATAL: server has run into an error: ", err)
+	err := rootCommand().execute(context.Background(), "backend", os.Args[1:])
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Println("FATAL:", err)
 		os.Exit(1)
 		// Equivalent to log.Fatal, but more explicit
 	}
-	log.Println("Server has shutdown properly")
 }
 
-// TODO: use (implement?) profiling tools to find out bottlenecks
-
 // run is an auxiliary function that initializes and effectively starts the server
-// and connects to all necessary services
-func run() error {
+// and connects to all necessary services, until ctx is done or a shutdown signal is received
+func run(ctx context.Context) error {
 	log.Println("Server starting")
 	var srv Server
 
@@ -56,27 +72,35 @@ func run() error {
 	}
 
 	// Create context that listens to interrupt signals (e.g. CTRL+C) to pass down
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
+	// Reloads are compared to the config as loaded, before startLogger adjusts it to dev mode
+	loadedCfg := srv.cfg
+
 	// Start logger
-	logger, logfile, err := startLogger(&srv.cfg)
+	logger, logFiles, logLevels, err := startLogger(&srv.cfg)
 	if err != nil {
 		log.Printf("FATAL: couldn't start logger: %v", err)
 		return err
 	}
-	defer func() {
-		// Dump remaining logger buffer into log file when shutting down
-		err := logger.Sync()
-		// BUG: Sync fails because of underlying system things (has to do with fsync)
-		// This doesn't seem to have any noticeable impact so far
-		if err != nil {
-			log.Printf("WARNING: logger failed to Sync(): %v", err)
-		}
-		// Close file
-		err = logfile.Close()
-		if err != nil {
-			log.Printf("WARNING: error while closing log file: %v", err)
+
+	reloader := config.NewReloader(loadedCfg, logger)
+
+	// On SIGHUP, reopen (or rotate) log files as expected by logrotate, and reload the config
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for range hangup {
+			if err := logFiles.Reopen(); err != nil {
+				logger.Error("Could not reopen log files", zap.Error(err))
+			} else {
+				logger.Info("Log files reopened after SIGHUP")
+			}
+			if err := reloader.Reload(); err != nil {
+				logger.Error("Could not reload configuration, keeping the running one", zap.Error(err))
+			}
 		}
 	}()
 
@@ -91,129 +115,382 @@ func run() error {
 		zap.Uint16("Server Port", srv.cfg.Server.Port),
 		zap.Bool("Server Development Mode", srv.cfg.Server.DevMode),
 		zap.String("Log file path", srv.cfg.Server.LogFile),
+		zap.String("Error log file path", srv.cfg.Log.ErrorFile),
 		zap.String("DB Host", srv.cfg.Database.Host),
 		zap.Uint16("DB Port", srv.cfg.Database.Port),
+		zap.String("Pub/sub type", srv.cfg.PubSub.Type),
+		zap.String("Push mode", srv.cfg.Push.Mode),
+		zap.String("Tracing exporter", srv.cfg.Tracing.Exporter),
 	)
 
+	// Readiness depends on the storage and, for information only, on Logto; it fails once shutdown begins
+	checker := health.NewChecker(srv.cfg.Health.CheckTimeout, logger)
+
+	// Components are stopped in reverse order of registration (dependencies aside), each within its own deadline.
+	// Components registered so far are also stopped should the startup fail
+	lc := lifecycle.NewManager(srv.cfg, checker, logger)
+	defer lc.Shutdown(false)
+
+	// The logger outlives every other component, so that their shutdown can be logged
+	lc.Register(lifecycle.Component{
+		Name: "logger",
+		Stop: func(context.Context) error {
+			// Dump remaining logger buffer into log file when shutting down
+			// BUG: Sync fails because of underlying system things (has to do with fsync)
+			// This doesn't seem to have any noticeable impact so far
+			if err := logger.Sync(); err != nil {
+				log.Printf("WARNING: logger failed to Sync(): %v", err)
+			}
+			return logFiles.Close()
+		},
+	})
+
+	// Components record spans through the global tracer, which does nothing if tracing is disabled
+	tracer, err := tracing.NewTracer(srv.cfg, logger)
+	if err != nil {
+		logger.Error("Failed to initialize tracing", zap.String("error", err.Error()))
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	tracing.SetTracer(tracer)
+	// Export the last spans once nothing records any
+	lc.Register(lifecycle.Component{Name: "tracer", Stop: tracer.Shutdown, DependsOn: []string{"logger"}})
+
+	// Components register their metrics as they start, to be scraped by Prometheus
+	reg := metrics.NewRegistry()
+	reg.RegisterRuntime()
+
 	logger.Info("Initializing storage")
 	storage := new(database.Storage)
-	// Begin storage initialization operation with retry mechanism
-	err = util.RetryOperation(ctx, logger, func() error {
-		return database.StartStorage(ctx, srv.cfg, storage, logger)
-	}, 1, 10*time.Millisecond)
+	// The DB is waited for, see database.StartStorage
+	err = database.StartStorage(ctx, srv.cfg, storage, reg, logger)
 	if err != nil {
 		logger.Error("Failed to initialize storage", zap.String("error", err.Error()))
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
+	lc.Register(lifecycle.Component{
+		Name:      "storage",
+		DependsOn: []string{"logger", "tracer"},
+		Stop: func(context.Context) error {
+			// NOTE: Since conns close automatically even without calling the methods,
+			// errors when closing them should not interrupt the program's flow
+			err := storage.Conns.Close.CloseConns()
+			if storage.Cache != nil {
+				err = errors.Join(err, storage.Cache.Close())
+			}
+			return err
+		},
+	})
+
+	// Losing the DB is noticed and logged even while idle, and readiness reports the last ping
+	dbMonitor := database.NewMonitor(storage.Conns.Health, srv.cfg.Database.MonitorInterval, reg, logger)
+	lc.Register(lifecycle.Component{
+		Name:      "database monitor",
+		DependsOn: []string{"storage"},
+		Start: func(ctx context.Context) error {
+			dbMonitor.Start(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			dbMonitor.Stop()
+			return nil
+		},
+	})
+
+	logger.Info("Initializing pub/sub bus")
+	bus, err := pubsub.NewBus(ctx, srv.cfg, logger)
+	if err != nil {
+		logger.Error("Failed to initialize pub/sub bus", zap.String("error", err.Error()))
+		return fmt.Errorf("failed to initialize pub/sub bus: %w", err)
+	}
+	// Stop fanning out messages before closing the storage they may travel through
+	lc.Register(lifecycle.Component{
+		Name:      "pubsub",
+		DependsOn: []string{"storage"},
+		Stop:      func(context.Context) error { return bus.Close() },
+	})
+
+	logger.Info("Initializing notifications")
+	dispatcher, err := push.NewDispatcher(srv.cfg, storage.Conns.DeviceTableOps, logger)
+	if err != nil {
+		logger.Error("Failed to initialize push delivery", zap.String("error", err.Error()))
+		return fmt.Errorf("failed to initialize push delivery: %w", err)
+	}
+	// Deliveries go through the job queue so that they survive restarts
+	jobManager := jobs.NewManager(srv.cfg, storage.Conns.QueueTableOps, logger)
+	deliverer := push.NewQueuedDeliverer(jobManager, dispatcher)
+	notifier := notifications.NewNotifier(storage.Conns.NotifTableOps, storage.Conns.EvTableOps, bus, deliverer, logger)
+
+	// All job handlers must be added before starting the workers.
+	// Running jobs are let complete before closing the storage they rely on
+	lc.Register(lifecycle.Component{
+		Name:      "jobs",
+		DependsOn: []string{"storage", "pubsub"},
+		Start: func(ctx context.Context) error {
+			jobManager.Start(ctx)
+			return nil
+		},
+		Stop: jobManager.Shutdown,
+	})
+
+	sched := scheduler.New(srv.cfg, storage.Conns.JobTableOps, logger)
+	reminders := scheduler.NewEventReminders(sched, storage.Conns.EvTableOps,
+		&scheduler.ParticipantNotifier{Events: storage.Conns.EvTableOps, Emitter: notifier},
+		srv.cfg.Scheduler.ReminderOffsets,
+	)
+	lc.Register(lifecycle.Component{
+		Name:      "scheduler",
+		DependsOn: []string{"storage", "jobs"},
+		Start: func(ctx context.Context) error {
+			sched.Start(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			sched.Stop()
+			return nil
+		},
+	})
+
+	// Reactions to the changes recorded in the outbox; handlers use the components above, which are
+	// stopped after the relay
+	relay := outbox.NewRelay(srv.cfg, storage.Conns.OutboxTableOps, reg, logger)
+	relay.Subscribe("notifications", notifier.HandleDomainEvent, database.UserFollowed, database.EventJoined, database.EventUpdated)
+	relay.Subscribe("reminders", reminders.HandleDomainEvent, database.EventCreated, database.EventUpdated)
+	lc.Register(lifecycle.Component{
+		Name:      "outbox",
+		DependsOn: []string{"storage", "pubsub", "jobs", "scheduler"},
+		Start: func(ctx context.Context) error {
+			relay.Start(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			relay.Stop()
+			return nil
+		},
+	})
+
+	checker.Register("database", dbMonitor.Check, true)
+	if pgBus, ok := bus.(*pubsub.PostgresBus); ok {
+		checker.Register("pubsub", pgBus.Check, false)
+	} else {
+		checker.Disable("pubsub")
+	}
+	if storage.Cache != nil {
+		checker.Register("cache", storage.Cache.Ping, true)
+	} else {
+		checker.Disable("cache")
+	}
+	// Calls to Logto are retried and fail fast while it's unavailable, see api.LogtoHTTP
+	logto := api.NewLogtoHTTP(logger)
+	if srv.cfg.Logto.Endpoint != "" {
+		checker.Register("logto", api.LogtoDiscoveryCheck(srv.cfg.Logto.Endpoint, logto), false)
+	} else {
+		checker.Disable("logto")
+	}
 
 	logger.Info("Initializing router")
-	echoRouter, err := api.InitRouter(ctx, *storage, &srv.cfg, logger)
+	timeouts := authmw.NewTimeouts(srv.cfg.Router.ReadTimeout, srv.cfg.Router.WriteTimeout)
+	echoRouter, err := api.InitRouter(ctx, *storage, api.Services{
+		Bus:       bus,
+		LogLevels: logLevels,
+		Metrics:   reg,
+		Health:    checker,
+		Timeouts:  timeouts,
+		Logto:     logto,
+	}, &srv.cfg, logger)
 	if err != nil {
 		logger.Error("Failed to initialize router", zap.String("error", err.Error()))
 		return fmt.Errorf("failed to initialize router: %w", err)
 	}
 
-	// Buffered channel to listen to server errors
-	serverErrors := make(chan error, 1)
+	// Buffered channel to listen to server errors, from the main, redirect, metrics and diagnostics listeners
+	serverErrors := make(chan error, 4)
+	// listen registers a component serving requests until it's stopped; it depends on every component
+	// handlers may use, so that in-flight requests complete before they're stopped
+	listen := func(name string, start func() error, shutdown lifecycle.Hook) {
+		lc.Register(lifecycle.Component{
+			Name:      name,
+			DependsOn: []string{"storage", "pubsub", "jobs", "scheduler"},
+			Start: func(context.Context) error {
+				go func() {
+					if err := start(); err != http.ErrServerClosed {
+						serverErrors <- fmt.Errorf("%s: %w", name, err)
+					}
+				}()
+				return nil
+			},
+			Stop: shutdown,
+		})
+	}
 
 	listenAddr := srv.cfg.Server.Host + ":" + strconv.Itoa(int(srv.cfg.Server.Port))
 	// Listen to requests
+	var server *http.Server
+	var tlsSetup *certs.Setup
 	if srv.cfg.Server.DevMode {
-		// Start HTTP server
-		go func() {
-			logger.Warn("Starting HTTP (non-secure) server")
-			// TODO: Now that we're hardcoding Echo, update this part
-			// WARN: Check what error is returned, net/http returns a ErrServerClosed which should be handled
-			// as a proper shutdown (so it isn't actually an error). For each router, check IN THE IMPLEMENTATION
-			// of Router.Listen if similar errors are thrown
-			serverErrors <- echoRouter.Start(listenAddr)
-			logger.Info("Shutting down server")
-		}()
+		logger.Warn("Starting HTTP (non-secure) server")
+		server, err = api.ConfigureServer(echoRouter, listenAddr, nil, &srv.cfg)
 	} else {
-		// START HTTPS Server
-		go func() {
-			logger.Info("Starting HTTPS server")
-			serverErrors <- echoRouter.StartAutoTLS(listenAddr) // (listenAddr, srv.cfg.Server.CertFile, srv.cfg.Server.KeyFile)
-			// AutoTLS should handle all the TLS for us? Wow, alright
-			// WARN: Same check as above
-			logger.Info("Shutting down server")
-		}()
+		// Start HTTPS server, with certificates from Let's Encrypt or from files
+		logger.Info("Starting HTTPS server", zap.String("TLS mode", srv.cfg.TLS.Mode))
+		tlsSetup, err = certs.NewSetup(ctx, srv.cfg, logger)
+		if err != nil {
+			logger.Error("Failed to configure TLS", zap.String("error", err.Error()))
+			return fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		server, err = api.ConfigureServer(echoRouter, listenAddr, tlsSetup.Config, &srv.cfg)
+	}
+	if err != nil {
+		logger.Error("Failed to configure server", zap.String("error", err.Error()))
+		return fmt.Errorf("failed to configure server: %w", err)
 	}
+	listen("server", func() error { return echoRouter.StartServer(server) }, func(ctx context.Context) error {
+		if err := echoRouter.Shutdown(ctx); err != nil {
+			logger.Sugar().Warn("Could not gracefully shutdown, forcefully closing server: ", err)
+			return errors.Join(err, echoRouter.Close())
+		}
+		return nil
+	})
 
-	// TODO: initialize all other necessary functionalities (e.g. Websocket, msg queue)
+	if tlsSetup != nil && srv.cfg.TLS.RedirectListen != "" {
+		redirectServer := &http.Server{Addr: srv.cfg.TLS.RedirectListen, Handler: tlsSetup.Redirect, ReadHeaderTimeout: 5 * time.Second}
+		logger.Info("Starting HTTP to HTTPS redirect server", zap.String("address", srv.cfg.TLS.RedirectListen))
+		listen("redirect server", redirectServer.ListenAndServe, redirectServer.Shutdown)
+	}
 
-	// Give some time to complete shut down
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Serve the metrics on their own listener if one is configured
+	if srv.cfg.Metrics.Enabled && srv.cfg.Metrics.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle(srv.cfg.Metrics.Path, reg.Handler())
+		metricsServer := &http.Server{Addr: srv.cfg.Metrics.Listen, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		logger.Info("Starting metrics server", zap.String("address", srv.cfg.Metrics.Listen))
+		listen("metrics server", metricsServer.ListenAndServe, metricsServer.Shutdown)
+	}
+
+	// Serve diagnostics (pprof, goroutine dumps...) on their own listener, if enabled
+	if srv.cfg.Diagnostics.Listen != "" {
+		profiler := diagnostics.NewProfiler(srv.cfg.Diagnostics.ProfileDir, logger)
+		diagRouter, err := api.NewDiagnosticsRouter(&srv.cfg, profiler, logger)
+		if err != nil {
+			logger.Error("Failed to initialize diagnostics listener", zap.String("error", err.Error()))
+			return fmt.Errorf("failed to initialize diagnostics listener: %w", err)
+		}
+		diagServer, err := api.ConfigureServer(diagRouter, srv.cfg.Diagnostics.Listen, nil, &srv.cfg)
+		if err != nil {
+			logger.Error("Failed to configure diagnostics server", zap.String("error", err.Error()))
+			return fmt.Errorf("failed to configure diagnostics server: %w", err)
+		}
+		// pprof refuses to stream profiles lasting longer than the WriteTimeout, which slow clients can't abuse on this listener
+		diagServer.WriteTimeout = 0
+		logger.Info("Starting diagnostics server", zap.String("address", srv.cfg.Diagnostics.Listen))
+		listen("diagnostics server", func() error { return diagRouter.StartServer(diagServer) }, diagRouter.Shutdown)
+	}
+
+	// Apply the reloadable settings to the running components, see the reload tags of config.Config
+	config.Subscribe(reloader, func(c *config.Config) string { return c.Log.Level }, func(level string) {
+		// DevMode, which the default level depends on, isn't reloadable
+		cfg := srv.cfg
+		cfg.Log.Level = level
+		logLevels.SetLevel("", rootLogLevel(cfg))
+	})
+	config.Subscribe(reloader, func(c *config.Config) [2]time.Duration {
+		return [2]time.Duration{c.Router.ReadTimeout, c.Router.WriteTimeout}
+	}, func(t [2]time.Duration) {
+		timeouts.Set(t[0], t[1])
+	})
+	config.Subscribe(reloader, func(c *config.Config) float64 { return c.Tracing.SampleRatio }, tracer.SetSampleRatio)
+	config.Subscribe(reloader, func(c *config.Config) time.Duration { return c.Health.CheckTimeout }, checker.SetTimeout)
+	lc.Register(lifecycle.Component{
+		Name: "config watcher",
+		Start: func(ctx context.Context) error {
+			go reloader.Watch(ctx, srv.cfg.Reload.WatchInterval)
+			return nil
+		},
+	})
+
+	// TODO: initialize all other necessary functionalities (e.g. Websocket)
+
+	if err := lc.Start(ctx); err != nil {
+		logger.Error("Failed to start components", zap.String("error", err.Error()))
+		return fmt.Errorf("failed to start components: %w", err)
+	}
 
 	// Listen to shutdown signals
 	select {
 	case err := <-serverErrors:
-		if err != http.ErrServerClosed {
-			logger.Sugar().Error("Fatal server error listening to API requests:", err)
-			// Log at console and return
-			// What do we need to do if a server error occurs?
-			// A graceful program exit is probably the best we can do.
-		}
-		// Else call graceful shutdown
-		if err := echoRouter.Shutdown(shutdownCtx); err != nil {
-			logger.Sugar().Error("Error calling server Shutdown(): ", err)
-			logger.Sugar().Warn("Could not gracefully shutdown, forcefully closing server")
-		}
+		// A graceful program exit is the best we can do, there's no point in draining without a server
+		logger.Sugar().Error("Fatal server error listening to API requests:", err)
+		lc.Shutdown(false)
 	case <-ctx.Done():
 		logger.Sugar().Info("Received shutdown signal. Initiating graceful shutdown")
-		// Shutdown server
-		if err := echoRouter.Shutdown(shutdownCtx); err != nil {
-			logger.Sugar().Error("Error calling server Shutdown(): ", err)
-			logger.Sugar().Warn("Could not gracefully shutdown, forcefully closing server")
-			if err = echoRouter.Close(); err != nil {
-				logger.Sugar().Error("Error during forecul shutdown: ", err)
-			}
-		}
-
+		// Fail readiness and keep serving for a while, so that load balancers stop sending traffic
+		lc.Shutdown(true)
 		// TODO: Close Websocket conns once they're set up
 	}
 
-	// Close storage conns
-	// NOTE: Since conns close automatically even without calling the methods,
-	// errors when closing them should not interrupt the program's flow
-	if err := storage.Conns.Close.CloseConns(); err != nil {
-		logger.Sugar().Error("Error closing storage connections:", err)
-	}
-	if err := storage.Cache.Close(); err != nil {
-		logger.Sugar().Error("Error closing cache connections:", err)
-	}
-
 	return nil
 }
 
 // startLogger creates a logger using the zap package and opens/creates logfiles.
 // Running the server in development mode
 // creates a zap.Development logger instead of a Production one, allowing the use of DPanic.
-func startLogger(cfg *config.Config) (*zap.Logger, *os.File, error) {
+// All logs are written to stdout and to the log file, while warn-and-above logs are also written
+// to a dedicated error file; both files rotate according to the Log config.
+// The returned Levels allow changing the log level at runtime, per component.
+func startLogger(cfg *config.Config) (*zap.Logger, logging.Files, *logging.Levels, error) {
 	config := zap.NewProductionConfig()
+	encoder := zapcore.NewJSONEncoder(config.EncoderConfig)
+	opts := []zap.Option{zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)}
 	if cfg.Server.DevMode {
-		// Set dev logger and write log file in current directory for easy visibility
+		// Set dev logger and write log files in current directory for easy visibility
 		config = zap.NewDevelopmentConfig()
+		encoder = zapcore.NewConsoleEncoder(config.EncoderConfig)
+		opts = []zap.Option{zap.AddCaller(), zap.AddStacktrace(zapcore.WarnLevel), zap.Development()}
 		cfg.Server.LogFile = "dev.log"
+		cfg.Log.ErrorFile = "dev.error.log"
 	}
-	// TODO: implement log rotation to keep log file size in check
-	// Additionally, log errors in dedicated files on top of current
 
-	// Open the file to write on, and close it in the calling func
-	logFile, err := os.OpenFile(cfg.Server.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		err = fmt.Errorf("error opening log file: %w", err)
-		return nil, nil, err
+	rotateOpts := logging.RotateOpts{
+		MaxSize:     int64(cfg.Log.MaxSizeMB) * 1024 * 1024,
+		RotateEvery: cfg.Log.RotateEvery,
+		MaxAge:      cfg.Log.MaxAge,
+		MaxBackups:  cfg.Log.MaxBackups,
+		Compress:    cfg.Log.Compress,
 	}
 
-	config.OutputPaths = []string{cfg.Server.LogFile, "stdout"}
-
-	logger, err := config.Build()
+	// Open the files to write on, and close them in the calling func
+	logFile, err := logging.OpenRotatingFile(cfg.Server.LogFile, rotateOpts)
 	if err != nil {
-		err = fmt.Errorf("error building logger: %w", err)
-		return nil, nil, err
+		return nil, nil, nil, fmt.Errorf("error opening log file: %w", err)
 	}
+	errorFile, err := logging.OpenRotatingFile(cfg.Log.ErrorFile, rotateOpts)
+	if err != nil {
+		logFile.Close()
+		return nil, nil, nil, fmt.Errorf("error opening error log file: %w", err)
+	}
+
+	// The main core lets everything through, filtering is left to the runtime adjustable levels
+	levels := logging.NewLevels(rootLogLevel(*cfg))
+	core := levels.Core(zapcore.NewTee(
+		zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(zapcore.Lock(os.Stdout), logFile), zapcore.DebugLevel),
+		zapcore.NewCore(encoder.Clone(), errorFile, zapcore.WarnLevel),
+	))
+
+	logger := zap.New(core, opts...)
+	files := logging.Files{logFile, errorFile}
+	files.SetLogger(logger.With(zap.String("component", "logging")))
+	return logger, files, levels, nil
+}
 
-	return logger, logFile, nil
+// rootLogLevel returns the root log level set in cfg, which defaults to debug in dev mode and to info otherwise.
+// The level is assumed valid, as checked by config.Validate
+func rootLogLevel(cfg config.Config) zapcore.Level {
+	if cfg.Log.Level != "" {
+		if level, err := zapcore.ParseLevel(cfg.Log.Level); err == nil {
+			return level
+		}
+	}
+	if cfg.Server.DevMode {
+		return zapcore.DebugLevel
+	}
+	return zapcore.InfoLevel
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/seed"
+	"go.uber.org/zap"
+)
+
+func seedCommand() *command {
+	return &command{
+		name:    "seed",
+		args:    "[-size " + strings.Join(sizeNames(), "|") + "] [-seed n] [-workers n]",
+		summary: "fill the database with fake data, in development mode only",
+		run:     runSeed,
+	}
+}
+
+func runSeed(ctx context.Context, args []string) error {
+	flags := newFlags("backend seed")
+	sizeName := flags.String("size", "small", "volume of data, one of "+strings.Join(sizeNames(), ", "))
+	seedValue := flags.Uint64("seed", 1, "seed of the generated data, the same seed generating the same data")
+	workers := flags.Int("workers", 8, "number of concurrent writes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	size, ok := seed.Sizes[*sizeName]
+	if !ok || flags.NArg() > 0 {
+		return errUsage
+	}
+
+	// The config is checked before connecting, as connecting migrates the schema
+	return withDB(ctx, database.StartStorage, seed.CheckConfig, func(ctx context.Context, cfg config.Config, storage *database.Storage, logger *zap.Logger) error {
+		seeder, err := seed.NewSeeder(cfg, *storage, *seedValue, *workers, logger)
+		if err != nil {
+			return err
+		}
+		report, err := seeder.Run(ctx, size)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Seeded %d users, %d follows and %d events\n", report.Users, report.Follows, report.Events)
+		return nil
+	})
+}
+
+func sizeNames() []string {
+	names := make([]string, 0, len(seed.Sizes))
+	for name := range seed.Sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
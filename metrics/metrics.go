@@ -0,0 +1,411 @@
+/* Package metrics exposes the server's metrics in the Prometheus text format.
+* It implements the few metric types the server needs (counters, gauges and histograms,
+* optionally labelled, and gauges or counters read from a function at scrape time) so that
+* the server doesn't depend on the Prometheus client library.
+ */
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// DefBuckets are histogram buckets suited to request latencies, in seconds
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// family is a set of metrics sharing a name, which writes itself in the text format
+type family interface {
+	names() []string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics of the server and writes them when scraped.
+// Metrics are created through the registry, which panics should their name be invalid
+// or clash with another metric's, as this can only be a programming error. Creating a
+// metric under the name of an existing one replaces it, so that a component whose
+// startup is retried (e.g. the storage) can register its metrics again
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	taken    map[string]bool
+}
+
+// NewRegistry instantiates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{taken: make(map[string]bool)}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.families {
+		if existing.names()[0] == f.names()[0] {
+			for _, name := range existing.names() {
+				delete(r.taken, name)
+			}
+			r.families = append(r.families[:i], r.families[i+1:]...)
+			break
+		}
+	}
+
+	for _, name := range f.names() {
+		if !metricNameRE.MatchString(name) {
+			panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+		}
+		if r.taken[name] {
+			panic(fmt.Sprintf("metrics: metric %s registered twice", name))
+		}
+		r.taken[name] = true
+	}
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes all the metrics in the text format, ordered by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].names()[0] < families[j].names()[0] })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns the HTTP handler serving the metrics to Prometheus
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// vec holds the children of a labelled metric, one per combination of label values
+type vec[T any] struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	newChild   func() *T
+
+	mu       sync.RWMutex
+	children map[string]*child[T]
+}
+
+type child[T any] struct {
+	labelValues []string
+	metric      *T
+}
+
+func newVec[T any](name, help, typ string, labelNames []string, newChild func() *T) *vec[T] {
+	for _, l := range labelNames {
+		if !labelNameRE.MatchString(l) || l == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q for metric %s", l, name))
+		}
+	}
+	v := &vec[T]{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		newChild:   newChild,
+		children:   make(map[string]*child[T]),
+	}
+	if len(labelNames) == 0 {
+		// Unlabelled metrics are exposed from the start, rather than once first updated
+		v.with(nil)
+	}
+	return v
+}
+
+func (v *vec[T]) names() []string {
+	return []string{v.name}
+}
+
+// with returns the child for the label values, creating it on first use
+func (v *vec[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.children[key]; !ok {
+		c = &child[T]{append([]string(nil), labelValues...), v.newChild()}
+		v.children[key] = c
+	}
+	return c.metric
+}
+
+// sortedChildren returns the children ordered by label values, for a stable output
+func (v *vec[T]) sortedChildren() []*child[T] {
+	v.mu.RLock()
+	children := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.RUnlock()
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].labelValues, "\xff") < strings.Join(children[j].labelValues, "\xff")
+	})
+	return children
+}
+
+// atomicFloat is a float64 that can be updated concurrently
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// Counter is a value that only goes up, e.g. a number of requests served
+type Counter struct {
+	*vec[atomicFloat]
+}
+
+// NewCounter registers a counter, labelled by labelNames
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labelNames, func() *atomicFloat { return new(atomicFloat) })}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.with(labelValues).Add(1)
+}
+
+// Add adds delta, which must not be negative, to the counter with the given label values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.name))
+	}
+	c.with(labelValues).Add(delta)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, c.typ)
+	for _, ch := range c.sortedChildren() {
+		writeSample(w, c.name, c.labelNames, ch.labelValues, "", "", ch.metric.Load())
+	}
+}
+
+// Gauge is a value that goes up and down, e.g. a number of requests in flight
+type Gauge struct {
+	*vec[atomicFloat]
+}
+
+// NewGauge registers a gauge, labelled by labelNames
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{newVec(name, help, "gauge", labelNames, func() *atomicFloat { return new(atomicFloat) })}
+	r.register(g)
+	return g
+}
+
+// Set sets the gauge with the given label values
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.with(labelValues).Set(v)
+}
+
+// Add adds delta, possibly negative, to the gauge with the given label values
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.with(labelValues).Add(delta)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, g.typ)
+	for _, ch := range g.sortedChildren() {
+		writeSample(w, g.name, g.labelNames, ch.labelValues, "", "", ch.metric.Load())
+	}
+}
+
+// Histogram counts observations (e.g. request durations) in configurable buckets
+type Histogram struct {
+	*vec[histogramValues]
+	buckets []float64
+}
+
+type histogramValues struct {
+	counts []atomic.Uint64 // Per bucket, not cumulative, the last one being +Inf
+	sum    atomicFloat
+	count  atomic.Uint64
+}
+
+// NewHistogram registers a histogram with the given upper bounds, labelled by labelNames
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	if len(buckets) > 0 && math.IsInf(buckets[len(buckets)-1], 1) {
+		buckets = buckets[:len(buckets)-1]
+	}
+	h := &Histogram{buckets: buckets}
+	h.vec = newVec(name, help, "histogram", labelNames, func() *histogramValues {
+		return &histogramValues{counts: make([]atomic.Uint64, len(buckets)+1)}
+	})
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) names() []string {
+	return []string{h.name, h.name + "_bucket", h.name + "_sum", h.name + "_count"}
+}
+
+// Observe records v in the histogram with the given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	values := h.with(labelValues)
+	values.counts[sort.SearchFloat64s(h.buckets, v)].Add(1)
+	values.sum.Add(v)
+	values.count.Add(1)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, h.typ)
+	for _, ch := range h.sortedChildren() {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += ch.metric.counts[i].Load()
+			writeSample(w, h.name+"_bucket", h.labelNames, ch.labelValues, "le", formatFloat(upper), float64(cumulative))
+		}
+		cumulative += ch.metric.counts[len(h.buckets)].Load()
+		writeSample(w, h.name+"_bucket", h.labelNames, ch.labelValues, "le", "+Inf", float64(cumulative))
+		writeSample(w, h.name+"_sum", h.labelNames, ch.labelValues, "", "", ch.metric.sum.Load())
+		writeSample(w, h.name+"_count", h.labelNames, ch.labelValues, "", "", float64(ch.metric.count.Load()))
+	}
+}
+
+// funcMetric is an unlabelled gauge or counter whose value is read at scrape time
+type funcMetric struct {
+	name string
+	help string
+	typ  string
+	fn   func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn when scraped
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name, help, "gauge", fn})
+}
+
+// NewCounterFunc registers a counter whose value is returned by fn when scraped,
+// for counters maintained elsewhere (e.g. by a library)
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name, help, "counter", fn})
+}
+
+func (f *funcMetric) names() []string {
+	return []string{f.name}
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+	writeSample(w, f.name, nil, nil, "", "", f.fn())
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// writeSample writes a sample line; extraLabel (e.g. a histogram's "le") is appended to the labels if not empty
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + escapeLabelValue(labelValues[i]) + `"`)
+		}
+		if extraLabel != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// scrape serves reg's metrics through its Handler, as Prometheus scrapes them
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+	srv := httptest.NewServer(reg.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("scraping metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scraping metrics: status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Number of requests.", "method", "status")
+	requests.Inc("GET", "200")
+	requests.Inc("GET", "200")
+	requests.Add(3, "POST", "500")
+	inFlight := reg.NewGauge("in_flight", "Requests being served.")
+	inFlight.Set(4)
+	inFlight.Add(-1)
+	duration := reg.NewHistogram("duration_seconds", "Request durations.", []float64{0.1, 1})
+	duration.Observe(0.05)
+	duration.Observe(0.5)
+	duration.Observe(2)
+	reg.NewGaugeFunc("up", "Whether the server is up.", func() float64 { return 1 })
+
+	want := `# HELP duration_seconds Request durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 2.55
+duration_seconds_count 3
+# HELP in_flight Requests being served.
+# TYPE in_flight gauge
+in_flight 3
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 2
+requests_total{method="POST",status="500"} 3
+# HELP up Whether the server is up.
+# TYPE up gauge
+up 1
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("scraped metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandlerEscaping(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("errors_total", "Errors,\nby \\ message.", "message").Inc(`say "hi"` + "\n")
+
+	want := `# HELP errors_total Errors,\nby \\ message.
+# TYPE errors_total counter
+errors_total{message="say \"hi\"\n"} 1
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("scraped metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("restarts_total", "Old help.").Inc()
+	reg.NewCounter("restarts_total", "New help.")
+
+	// The new counter starts from 0, unlabelled metrics being written even before they're set
+	want := `# HELP restarts_total New help.
+# TYPE restarts_total counter
+restarts_total 0
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("scraped metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	tests := map[string]func(reg *Registry){
+		"invalid name": func(reg *Registry) { reg.NewGauge("not-valid", "") },
+		"clashing histogram series": func(reg *Registry) {
+			reg.NewHistogram("latency", "", DefBuckets)
+			reg.NewGauge("latency_count", "")
+		},
+		"decreasing counter": func(reg *Registry) { reg.NewCounter("total", "").Add(-1) },
+	}
+	for name, register := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			register(NewRegistry())
+		})
+	}
+}
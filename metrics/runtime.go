@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bufio"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// RegisterRuntime registers the Go runtime metrics: goroutines, memory and garbage collection
+func (r *Registry) RegisterRuntime() {
+	r.register(&runtimeCollector{})
+}
+
+// runtimeCollector reads the runtime's memory statistics once per scrape, as doing so stops the world
+type runtimeCollector struct {
+	mu sync.Mutex
+}
+
+func (c *runtimeCollector) names() []string {
+	return []string{
+		"go_goroutines",
+		"go_threads",
+		"go_info",
+		"go_memstats_alloc_bytes",
+		"go_memstats_heap_alloc_bytes",
+		"go_memstats_heap_inuse_bytes",
+		"go_memstats_heap_objects",
+		"go_memstats_sys_bytes",
+		"go_memstats_mallocs_total",
+		"go_memstats_frees_total",
+		"go_memstats_next_gc_bytes",
+		"go_memstats_last_gc_time_seconds",
+		"go_gc_count_total",
+		"go_gc_pause_seconds_total",
+	}
+}
+
+func (c *runtimeCollector) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	threads, _ := runtime.ThreadCreateProfile(nil)
+
+	gauge := func(name, help string, v float64) {
+		writeHeader(w, name, help, "gauge")
+		writeSample(w, name, nil, nil, "", "", v)
+	}
+	counter := func(name, help string, v float64) {
+		writeHeader(w, name, help, "counter")
+		writeSample(w, name, nil, nil, "", "", v)
+	}
+
+	// Ordered by name, like the registry's other families
+	counter("go_gc_count_total", "Number of completed GC cycles.", float64(ms.NumGC))
+	counter("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", time.Duration(ms.PauseTotalNs).Seconds())
+	gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	writeHeader(w, "go_info", "Information about the Go environment.", "gauge")
+	writeSample(w, "go_info", []string{"version"}, []string{runtime.Version()}, "", "", 1)
+	gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(ms.Alloc))
+	counter("go_memstats_frees_total", "Total number of frees.", float64(ms.Frees))
+	gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(ms.HeapAlloc))
+	gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(ms.HeapInuse))
+	gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(ms.HeapObjects))
+	gauge("go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", float64(ms.LastGC)/1e9)
+	counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(ms.Mallocs))
+	gauge("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(ms.NextGC))
+	gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys))
+	gauge("go_threads", "Number of OS threads created.", float64(threads))
+}
@@ -0,0 +1,227 @@
+/* Package notifications defines the notification types users can receive and the Notifier,
+* through which other modules emit them. Emitted notifications are stored in their recipient's
+* inbox (grouped with similar unread ones) and published on the pub/sub bus for live delivery.
+ */
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/pubsub"
+	"github.com/charm-113c/project-zero/push"
+	"github.com/charm-113c/project-zero/util"
+	"go.uber.org/zap"
+)
+
+// Notification types. Users can opt out of each of them individually
+const (
+	TypeUserFollowed  = "user_followed"  // Someone followed the recipient
+	TypeEventJoined   = "event_joined"   // Someone joined an event created by the recipient
+	TypeEventUpdated  = "event_updated"  // An event the recipient joined was changed
+	TypeEventReminder = "event_reminder" // An event the recipient joined starts soon
+)
+
+// Types lists all the notification types
+var Types = []string{TypeUserFollowed, TypeEventJoined, TypeEventUpdated, TypeEventReminder}
+
+// IsValidType reports whether notifType is a known notification type
+func IsValidType(notifType string) bool {
+	return slices.Contains(Types, notifType)
+}
+
+// Trigger describes what happened and who should be notified about it
+type Trigger struct {
+	Type        string
+	RecipientID string
+	ActorID     string // The user whose action triggered the notification, empty if the server did
+	SubjectID   string // What the notification is about (e.g. an event ID), empty if it's about the recipient
+}
+
+// Emitter is the interface other modules use to emit notifications
+type Emitter interface {
+	Emit(ctx context.Context, t Trigger) error
+}
+
+// Topic is the pub/sub topic on which the live notifications of userID are published
+func Topic(userID string) string {
+	return "notifications:" + userID
+}
+
+// Deliverer delivers a message to all of a user's devices, e.g. push.QueuedDeliverer.
+// Emit waits for Deliver, which should therefore hand the delivery over rather than perform it
+type Deliverer interface {
+	Deliver(ctx context.Context, userID string, msg push.Message) error
+}
+
+// Notifier implements Emitter, storing notifications through the NotificationStorageHandler
+// and pushing them to the recipient's devices through the Deliverer
+type Notifier struct {
+	db        database.NotificationStorageHandler
+	events    database.EventStorageHandler
+	bus       pubsub.Bus
+	deliverer Deliverer
+	logger    *zap.Logger
+}
+
+// NewNotifier instantiates a Notifier
+func NewNotifier(db database.NotificationStorageHandler, events database.EventStorageHandler, bus pubsub.Bus, deliverer Deliverer, parentLogger *zap.Logger) *Notifier {
+	return &Notifier{
+		db,
+		events,
+		bus,
+		deliverer,
+		parentLogger.With(zap.String("component", "notifications")),
+	}
+}
+
+// Emit stores the notification described by t in the recipient's inbox, unless the recipient
+// opted out of its type or is the one who triggered it
+func (n *Notifier) Emit(ctx context.Context, t Trigger) error {
+	if !IsValidType(t.Type) {
+		return fmt.Errorf("unknown notification type %s", t.Type)
+	}
+	if t.RecipientID == t.ActorID {
+		return nil
+	}
+
+	prefs, err := n.db.GetNotificationPrefs(ctx, t.RecipientID)
+	if err != nil {
+		return err
+	}
+	// Types are enabled unless explicitly disabled
+	if enabled, ok := prefs[t.Type]; ok && !enabled {
+		return nil
+	}
+
+	actorIDs := []string{}
+	if t.ActorID != "" {
+		actorIDs = append(actorIDs, t.ActorID)
+	}
+	stored, added, err := n.db.InsertNotification(ctx, database.Notification{
+		RecipientID: t.RecipientID,
+		Type:        t.Type,
+		GroupKey:    t.Type + ":" + t.SubjectID,
+		SubjectID:   t.SubjectID,
+		ActorIDs:    actorIDs,
+	})
+	if err != nil {
+		return err
+	}
+	if !added {
+		// The actor is already part of the unread notification, e.g. the notification is emitted again as its
+		// domain event was redelivered: the recipient was told already, and isn't published nor pushed to again
+		return nil
+	}
+
+	// The notification is already in the inbox, failing to deliver it live is not an error
+	view := NewView(stored)
+	payload, err := json.Marshal(view)
+	if err == nil {
+		err = n.bus.Publish(ctx, Topic(t.RecipientID), payload)
+	}
+	if err != nil {
+		logging.FromContext(ctx, n.logger).Warn("Could not publish notification", zap.Int64("notificationID", stored.ID), zap.Error(err))
+	}
+
+	// Deliverers are expected to be quick (e.g. push.QueuedDeliverer enqueues the delivery). Failing to hand the
+	// notification over is an error, but as the notification is in the inbox already, emitting it again won't push it
+	err = n.deliverer.Deliver(ctx, t.RecipientID, push.Message{
+		Title: "Junkyard",
+		Body:  view.Summary,
+		Data: map[string]string{
+			"notificationID": fmt.Sprint(stored.ID),
+			"type":           stored.Type,
+			"subjectID":      stored.SubjectID,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error handing notification %d over for push: %w", stored.ID, err)
+	}
+	return nil
+}
+
+// HandleDomainEvent emits the notifications following from the changes recorded in the outbox,
+// as an outbox.Handler. Redelivered events are merged with their first notification while it's unread
+func (n *Notifier) HandleDomainEvent(ctx context.Context, ev database.DomainEvent) error {
+	switch ev.Type {
+	case database.UserFollowed:
+		var f database.Follow
+		if err := ev.Decode(&f); err != nil {
+			return util.Permanent(fmt.Errorf("malformed payload: %w", err))
+		}
+		return n.Emit(ctx, Trigger{Type: TypeUserFollowed, RecipientID: f.FolloweeID, ActorID: f.FollowerID})
+	case database.EventJoined:
+		var p database.Participation
+		if err := ev.Decode(&p); err != nil {
+			return util.Permanent(fmt.Errorf("malformed payload: %w", err))
+		}
+		event, err := n.events.GetEvent(ctx, p.EventID)
+		if errors.Is(err, database.ErrNotFound) {
+			// The event was deleted since, there's nothing left to tell its creator about
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Creators joining their own events aren't notified, Emit dropping self-triggered notifications
+		return n.Emit(ctx, Trigger{Type: TypeEventJoined, RecipientID: event.CreatorID, ActorID: p.UserID, SubjectID: event.ID})
+	case database.EventUpdated:
+		var event database.Event
+		if err := ev.Decode(&event); err != nil {
+			return util.Permanent(fmt.Errorf("malformed payload: %w", err))
+		}
+		participants, err := n.events.ListEventParticipants(ctx, event.ID)
+		if err != nil {
+			return err
+		}
+		// Events are only updated by their creator
+		var errs []error
+		for _, participantID := range participants {
+			err := n.Emit(ctx, Trigger{Type: TypeEventUpdated, RecipientID: participantID, ActorID: event.CreatorID, SubjectID: event.ID})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("participant %s: %w", participantID, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// View is the representation of a notification sent to clients
+type View struct {
+	database.Notification
+	Summary string `json:"summary"`
+}
+
+// NewView wraps n with its human-readable summary
+func NewView(n database.Notification) View {
+	return View{n, Summary(n)}
+}
+
+// Summary returns a human-readable description of n, accounting for grouping
+// (e.g. "5 people joined your event")
+func Summary(n database.Notification) string {
+	people := "1 person"
+	if n.Count != 1 {
+		people = fmt.Sprintf("%d people", n.Count)
+	}
+
+	switch n.Type {
+	case TypeUserFollowed:
+		return people + " followed you"
+	case TypeEventJoined:
+		return people + " joined your event"
+	case TypeEventUpdated:
+		return "An event you joined was updated"
+	case TypeEventReminder:
+		return "An event you joined starts soon"
+	default:
+		return "You have a new notification"
+	}
+}
@@ -0,0 +1,215 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/pubsub"
+	"github.com/charm-113c/project-zero/push"
+	"go.uber.org/zap"
+)
+
+// memoryInbox is an in-memory database.NotificationStorageHandler, grouping unread notifications like Postgres
+type memoryInbox struct {
+	mu     sync.Mutex
+	notifs []database.Notification
+	prefs  map[string]map[string]bool
+}
+
+func (m *memoryInbox) InsertNotification(ctx context.Context, n database.Notification) (database.Notification, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.notifs {
+		if existing.RecipientID == n.RecipientID && existing.GroupKey == n.GroupKey && existing.ReadAt == nil {
+			added := len(n.ActorIDs) == 0
+			for _, actorID := range n.ActorIDs {
+				if !slices.Contains(existing.ActorIDs, actorID) {
+					existing.ActorIDs = append(existing.ActorIDs, actorID)
+					added = true
+				}
+			}
+			existing.Count = max(len(existing.ActorIDs), 1)
+			m.notifs[i] = existing
+			return existing, added, nil
+		}
+	}
+	n.ID = int64(len(m.notifs) + 1)
+	n.Count = max(len(n.ActorIDs), 1)
+	n.CreatedAt, n.UpdatedAt = time.Now(), time.Now()
+	m.notifs = append(m.notifs, n)
+	return n, true, nil
+}
+
+func (m *memoryInbox) ListNotifications(ctx context.Context, recipientID string, unreadOnly bool, limit int) ([]database.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var notifs []database.Notification
+	for _, n := range m.notifs {
+		if n.RecipientID == recipientID && (!unreadOnly || n.ReadAt == nil) {
+			notifs = append(notifs, n)
+		}
+	}
+	return notifs, nil
+}
+
+func (m *memoryInbox) MarkNotificationRead(ctx context.Context, recipientID string, notificationID int64) error {
+	return nil
+}
+
+func (m *memoryInbox) MarkAllNotificationsRead(ctx context.Context, recipientID string) error {
+	return nil
+}
+
+func (m *memoryInbox) GetNotificationPrefs(ctx context.Context, userID string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prefs[userID], nil
+}
+
+func (m *memoryInbox) SetNotificationPrefs(ctx context.Context, userID string, prefs map[string]bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.prefs == nil {
+		m.prefs = make(map[string]map[string]bool)
+	}
+	m.prefs[userID] = prefs
+	return nil
+}
+
+// TestEmitPipeline checks that an emitted notification reaches the inbox, the live subscribers
+// and the recipient's devices, through the push sink rather than APNs or FCM
+func TestEmitPipeline(t *testing.T) {
+	inbox := &memoryInbox{}
+	bus := pubsub.NewMemoryBus(8, zap.NewNop())
+	defer bus.Close()
+	sink := push.NewMemorySink()
+	dispatcher := push.NewDispatcherWithPushers(push.NewMemoryDevices(
+		database.Device{UserID: "alice", Platform: push.PlatformIOS, Token: "alice-phone"},
+		database.Device{UserID: "carol", Platform: push.PlatformAndroid, Token: "carol-phone"},
+	), map[string]push.Pusher{push.PlatformIOS: sink, push.PlatformAndroid: sink}, zap.NewNop())
+	n := NewNotifier(inbox, nil, bus, dispatcher, zap.NewNop())
+
+	sub, err := bus.Subscribe(Topic("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	ctx := context.Background()
+	for _, follower := range []string{"bob", "carol"} {
+		if err := n.Emit(ctx, Trigger{Type: TypeUserFollowed, RecipientID: "alice", ActorID: follower}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+
+	// Both follows are grouped in a single inbox entry
+	notifs, _ := inbox.ListNotifications(ctx, "alice", true, 10)
+	if len(notifs) != 1 || notifs[0].Count != 2 {
+		t.Fatalf("inbox = %+v, want a single entry for 2 followers", notifs)
+	}
+
+	// Each follow is published live, the last one with the grouped summary
+	var view View
+	for range 2 {
+		select {
+		case msg := <-sub.C:
+			if err := json.Unmarshal(msg.Payload, &view); err != nil {
+				t.Fatalf("malformed live notification: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("notification not published live")
+		}
+	}
+	if view.Summary != "2 people followed you" {
+		t.Errorf("live summary = %q, want %q", view.Summary, "2 people followed you")
+	}
+
+	// Each follow is pushed to alice's device only
+	sent := sink.Sent()
+	if len(sent) != 2 {
+		t.Fatalf("pushed %d messages, want 2", len(sent))
+	}
+	for _, d := range sent {
+		if d.Token != "alice-phone" || d.Message.Data["type"] != TypeUserFollowed {
+			t.Errorf("pushed %+v, want a user_followed push to alice-phone", d)
+		}
+	}
+	if got := sent[1].Message.Body; got != "2 people followed you" {
+		t.Errorf("pushed body = %q, want %q", got, "2 people followed you")
+	}
+}
+
+func TestEmitRedelivered(t *testing.T) {
+	inbox := &memoryInbox{}
+	bus := pubsub.NewMemoryBus(8, zap.NewNop())
+	defer bus.Close()
+	sink := push.NewMemorySink()
+	dispatcher := push.NewDispatcherWithPushers(push.NewMemoryDevices(
+		database.Device{UserID: "alice", Platform: push.PlatformIOS, Token: "alice-phone"},
+	), map[string]push.Pusher{push.PlatformIOS: sink}, zap.NewNop())
+	n := NewNotifier(inbox, nil, bus, dispatcher, zap.NewNop())
+	ctx := context.Background()
+
+	// The same follow handed over twice by the outbox is only pushed once
+	for range 2 {
+		if err := n.Emit(ctx, Trigger{Type: TypeUserFollowed, RecipientID: "alice", ActorID: "bob"}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+	if notifs, _ := inbox.ListNotifications(ctx, "alice", true, 10); len(notifs) != 1 || notifs[0].Count != 1 {
+		t.Errorf("inbox = %+v, want a single entry for 1 follower", notifs)
+	}
+	if sent := sink.Sent(); len(sent) != 1 {
+		t.Errorf("pushed %d messages, want 1", len(sent))
+	}
+
+	// Server-triggered notifications have no actor to tell them apart, and are all pushed
+	for range 2 {
+		if err := n.Emit(ctx, Trigger{Type: TypeEventReminder, RecipientID: "alice", SubjectID: "picnic"}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+	if sent := sink.Sent(); len(sent) != 3 {
+		t.Errorf("pushed %d messages, want 3", len(sent))
+	}
+}
+
+func TestEmitSkipped(t *testing.T) {
+	inbox := &memoryInbox{}
+	bus := pubsub.NewMemoryBus(8, zap.NewNop())
+	defer bus.Close()
+	sink := push.NewMemorySink()
+	dispatcher := push.NewDispatcherWithPushers(push.NewMemoryDevices(
+		database.Device{UserID: "alice", Platform: push.PlatformIOS, Token: "alice-phone"},
+	), map[string]push.Pusher{push.PlatformIOS: sink}, zap.NewNop())
+	n := NewNotifier(inbox, nil, bus, dispatcher, zap.NewNop())
+	ctx := context.Background()
+
+	// Self-triggered notifications are dropped
+	if err := n.Emit(ctx, Trigger{Type: TypeUserFollowed, RecipientID: "alice", ActorID: "alice"}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	// As are those of the types the recipient opted out of
+	if err := inbox.SetNotificationPrefs(ctx, "alice", map[string]bool{TypeUserFollowed: false}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Emit(ctx, Trigger{Type: TypeUserFollowed, RecipientID: "alice", ActorID: "bob"}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+
+	if notifs, _ := inbox.ListNotifications(ctx, "alice", false, 10); len(notifs) != 0 {
+		t.Errorf("inbox = %+v, want it empty", notifs)
+	}
+	if sent := sink.Sent(); len(sent) != 0 {
+		t.Errorf("pushed %+v, want nothing", sent)
+	}
+
+	if err := n.Emit(ctx, Trigger{Type: "unknown", RecipientID: "alice"}); err == nil {
+		t.Error("Emit of an unknown type succeeded")
+	}
+}
@@ -0,0 +1,200 @@
+/* Package outbox relays the domain events recorded in the outbox (see database.OutboxStorageHandler)
+* to in-process subscribers. Events being recorded in the same transaction as the changes they
+* describe, the reactions to those changes (notifications, reminders, cache invalidations, webhooks...)
+* can neither be lost nor fire for changes that were rolled back. Delivery is at-least-once: each
+* subscriber is a consumer with its own offset, only moved past the events it handled successfully.
+ */
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/metrics"
+	"go.uber.org/zap"
+)
+
+// pruneInterval is how often the events handled by every consumer are pruned
+const pruneInterval = time.Hour
+
+// maxBackoff caps the delay before a failing consumer is handed its events again
+const maxBackoff = time.Minute
+
+// Handler handles a domain event. Events may be handed over more than once (e.g. should the server
+// stop before the consumer's offset is saved), so handlers must be idempotent
+type Handler func(ctx context.Context, ev database.DomainEvent) error
+
+type subscriber struct {
+	consumer string
+	types    []string // All types if empty
+	handle   Handler
+	// failures counts the consecutive failed batches, the consumer being paused until retryAt
+	failures int
+	retryAt  time.Time
+}
+
+// Relay hands the events of the outbox to its subscribers
+type Relay struct {
+	db     database.OutboxStorageHandler
+	cfg    config.Config
+	subs   []*subscriber
+	logger *zap.Logger
+
+	delivered   *metrics.Counter
+	failures    *metrics.Counter
+	lag         *metrics.Gauge
+	deadLetters *metrics.Gauge
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRelay instantiates a Relay; subscribers must be added before calling Start
+func NewRelay(cfg config.Config, db database.OutboxStorageHandler, reg *metrics.Registry, parentLogger *zap.Logger) *Relay {
+	return &Relay{
+		db:     db,
+		cfg:    cfg,
+		logger: parentLogger.With(zap.String("component", "outbox")),
+		delivered: reg.NewCounter("outbox_events_delivered_total",
+			"Number of domain events handled successfully, by consumer.", "consumer"),
+		failures: reg.NewCounter("outbox_failures_total",
+			"Number of domain events a consumer failed to handle, by consumer.", "consumer"),
+		lag: reg.NewGauge("outbox_consumer_lag",
+			"Number of domain events a consumer has yet to handle, by consumer.", "consumer"),
+		deadLetters: reg.NewGauge("outbox_consumer_dead_letters",
+			"Number of domain events a consumer gave up on, by consumer.", "consumer"),
+	}
+}
+
+// Subscribe adds h as the handler of the consumer named consumer, handed the events of the given types
+// (all of them if none). Each event is handed once to a consumer, whichever replica runs it: reactions
+// local to a replica (e.g. invalidating an in-memory cache) need a consumer per replica, named after it
+func (r *Relay) Subscribe(consumer string, h Handler, types ...string) {
+	r.subs = append(r.subs, &subscriber{consumer: consumer, types: types, handle: h})
+}
+
+// Start relays the events until Stop is called or ctx is cancelled
+func (r *Relay) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.cfg.Outbox.PollInterval)
+		defer ticker.Stop()
+		pruneTicker := time.NewTicker(pruneInterval)
+		defer pruneTicker.Stop()
+
+		r.logger.Info("Outbox relay started", zap.Duration("pollInterval", r.cfg.Outbox.PollInterval), zap.Int("consumers", len(r.subs)))
+		for {
+			r.poll(ctx)
+			select {
+			case <-ctx.Done():
+				r.logger.Info("Outbox relay stopped")
+				return
+			case <-ticker.C:
+			case <-pruneTicker.C:
+				r.prune(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops relaying events and waits for the batches being handled to complete
+func (r *Relay) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+// Offsets returns the offset of every consumer in the outbox, including those of other replicas
+func (r *Relay) Offsets(ctx context.Context) ([]database.OutboxOffset, error) {
+	return r.db.OutboxOffsets(ctx)
+}
+
+func (r *Relay) poll(ctx context.Context) {
+	for _, sub := range r.subs {
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Now().Before(sub.retryAt) {
+			continue
+		}
+		r.consume(ctx, sub)
+	}
+
+	offsets, err := r.Offsets(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Error("Could not read outbox offsets", zap.Error(err))
+		}
+		return
+	}
+	for _, o := range offsets {
+		r.lag.Set(float64(o.Lag), o.Consumer)
+		r.deadLetters.Set(float64(o.DeadLetters), o.Consumer)
+	}
+}
+
+// consume hands the consumer batches of events until it's caught up or fails
+func (r *Relay) consume(ctx context.Context, sub *subscriber) {
+	handle := func(ctx context.Context, ev database.DomainEvent) error {
+		if len(sub.types) > 0 && !slices.Contains(sub.types, ev.Type) {
+			return nil
+		}
+		if err := sub.handle(ctx, ev); err != nil {
+			return fmt.Errorf("event %d of type %s: %w", ev.ID, ev.Type, err)
+		}
+		r.delivered.Inc(sub.consumer)
+		return nil
+	}
+
+	for {
+		batch, err := r.db.ConsumeOutbox(ctx, sub.consumer, r.cfg.Outbox.BatchSize, r.cfg.Outbox.MaxAttempts, handle)
+		for _, dead := range batch.DeadLettered {
+			r.failures.Inc(sub.consumer)
+			r.logger.Error("Outbox consumer gave up on an event, moving it to the dead letters", zap.String("consumer", sub.consumer),
+				zap.Int64("eventID", dead.Event.ID), zap.String("type", dead.Event.Type), zap.Int("attempts", dead.Attempts),
+				zap.String("error", dead.Error))
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The consumer is retried with exponential backoff, from the poll interval up to maxBackoff
+			sub.failures++
+			delay := min(r.cfg.Outbox.PollInterval<<min(sub.failures-1, 10), maxBackoff)
+			sub.retryAt = time.Now().Add(delay)
+			r.failures.Inc(sub.consumer)
+			r.logger.Error("Outbox consumer failed, retrying", zap.String("consumer", sub.consumer),
+				zap.Int("failures", sub.failures), zap.Duration("delay", delay), zap.Error(err))
+			return
+		}
+		if sub.failures > 0 {
+			r.logger.Info("Outbox consumer recovered", zap.String("consumer", sub.consumer))
+			sub.failures = 0
+		}
+		// Fewer events than requested means the consumer is caught up, or being run by another replica
+		if batch.Handled < r.cfg.Outbox.BatchSize {
+			return
+		}
+	}
+}
+
+func (r *Relay) prune(ctx context.Context) {
+	n, err := r.db.PruneOutbox(ctx, r.cfg.Outbox.Retention)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Error("Could not prune the outbox", zap.Error(err))
+		}
+		return
+	}
+	if n > 0 {
+		r.logger.Info("Pruned the outbox", zap.Int64("events", n))
+	}
+}
@@ -0,0 +1,38 @@
+package pubsub
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// MemoryBus is an in-process Bus: messages only reach subscribers on the current replica.
+// It is the default, and is enough as long as a single server instance is running.
+type MemoryBus struct {
+	b *broker
+}
+
+// NewMemoryBus instantiates a MemoryBus whose subscribers buffer up to bufferSize messages
+func NewMemoryBus(bufferSize int, logger *zap.Logger) *MemoryBus {
+	return &MemoryBus{b: newBroker(bufferSize, logger)}
+}
+
+// Publish delivers the payload to all local subscribers of topic
+func (m *MemoryBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	if m.b.isClosed() {
+		return ErrClosed
+	}
+	m.b.dispatch(Message{Topic: topic, Payload: payload})
+	return nil
+}
+
+// Subscribe registers a new subscription on topic
+func (m *MemoryBus) Subscribe(topic string) (*Subscription, error) {
+	return m.b.add(topic)
+}
+
+// Close closes all subscriptions, after which the bus can no longer be used
+func (m *MemoryBus) Close() error {
+	m.b.close()
+	return nil
+}
@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// receive returns the next message of sub, failing the test if none arrives in time
+func receive(t *testing.T, sub *Subscription) Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return Message{}
+	}
+}
+
+// assertEmpty fails the test if sub has a message pending
+func assertEmpty(t *testing.T, sub *Subscription) {
+	t.Helper()
+	select {
+	case msg := <-sub.C:
+		t.Errorf("unexpected message %s on %s", msg.Payload, msg.Topic)
+	default:
+	}
+}
+
+func subscribe(t *testing.T, bus Bus, topic string) *Subscription {
+	t.Helper()
+	sub, err := bus.Subscribe(topic)
+	if err != nil {
+		t.Fatalf("Subscribe(%s) = %v", topic, err)
+	}
+	return sub
+}
+
+func TestMemoryBusPublishReachesAllSubscribers(t *testing.T) {
+	bus := NewMemoryBus(8, zap.NewNop())
+	defer bus.Close()
+	first := subscribe(t, bus, "notifications:alice")
+	second := subscribe(t, bus, "notifications:alice")
+	other := subscribe(t, bus, "notifications:bob")
+
+	if err := bus.Publish(context.Background(), "notifications:alice", []byte("hello")); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	for _, sub := range []*Subscription{first, second} {
+		msg := receive(t, sub)
+		if msg.Topic != "notifications:alice" || string(msg.Payload) != "hello" {
+			t.Errorf("received %s on %s, want hello on notifications:alice", msg.Payload, msg.Topic)
+		}
+	}
+	assertEmpty(t, other)
+}
+
+func TestMemoryBusSlowSubscriber(t *testing.T) {
+	const bufferSize = 2
+	bus := NewMemoryBus(bufferSize, zap.NewNop())
+	defer bus.Close()
+	slow := subscribe(t, bus, "topic") // Never reads until the end
+	fast := subscribe(t, bus, "topic")
+
+	const published = 10
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range published {
+			if err := bus.Publish(context.Background(), "topic", []byte(fmt.Sprint(i))); err != nil {
+				t.Errorf("Publish() = %v", err)
+			}
+			// t.Fatal mustn't be called from this goroutine, hence no receive
+			select {
+			case msg := <-fast.C:
+				if string(msg.Payload) != fmt.Sprint(i) {
+					t.Errorf("fast subscriber received %s, want %d", msg.Payload, i)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("fast subscriber didn't receive message %d", i)
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked on the slow subscriber")
+	}
+
+	// The slow subscriber got the messages fitting in its buffer, the others were dropped
+	for i := range bufferSize {
+		if msg := receive(t, slow); string(msg.Payload) != fmt.Sprint(i) {
+			t.Errorf("slow subscriber received %s, want %d", msg.Payload, i)
+		}
+	}
+	assertEmpty(t, slow)
+}
+
+func TestMemoryBusUnsubscribe(t *testing.T) {
+	bus := NewMemoryBus(8, zap.NewNop())
+	defer bus.Close()
+	sub := subscribe(t, bus, "topic")
+	remaining := subscribe(t, bus, "topic")
+
+	sub.Unsubscribe()
+	sub.Unsubscribe() // Safe to call more than once
+	if _, ok := <-sub.C; ok {
+		t.Error("channel still open after Unsubscribe")
+	}
+
+	if err := bus.Publish(context.Background(), "topic", []byte("still here")); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if msg := receive(t, remaining); string(msg.Payload) != "still here" {
+		t.Errorf("received %s, want still here", msg.Payload)
+	}
+}
+
+func TestMemoryBusClose(t *testing.T) {
+	bus := NewMemoryBus(8, zap.NewNop())
+	sub := subscribe(t, bus, "topic")
+
+	if err := bus.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("channel still open after Close")
+	}
+	sub.Unsubscribe() // Doesn't close the channel twice
+
+	if err := bus.Publish(context.Background(), "topic", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() after Close = %v, want ErrClosed", err)
+	}
+	if _, err := bus.Subscribe("topic"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Subscribe() after Close = %v, want ErrClosed", err)
+	}
+}
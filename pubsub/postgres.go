@@ -0,0 +1,199 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// maxNotifyPayload is Postgres' limit on the size of a NOTIFY payload (in bytes)
+const maxNotifyPayload = 8000
+
+// reconnectPolicy re-establishes the listener connection for as long as it takes, however it failed,
+// attempts being spaced by up to 30 seconds
+var reconnectPolicy = util.RetryPolicy{
+	BaseDelay: 100 * time.Millisecond,
+	MaxDelay:  30 * time.Second,
+	Retryable: func(error) bool { return true },
+}
+
+// PostgresBus is a Bus backed by Postgres' LISTEN/NOTIFY. Every replica listens on the
+// same channel, so a message published on one replica is received by all of them
+// (including the publisher) and dispatched to their local subscribers.
+type PostgresBus struct {
+	b       *broker
+	pool    *pgxpool.Pool
+	connStr string
+	channel string
+	logger  *zap.Logger
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	// lostErr is the error the listener connection was lost with, nil while it's up
+	mu        sync.Mutex
+	lostErr   error
+	downSince time.Time
+}
+
+// NewPostgresBus connects to the DB designated through the config and starts listening
+// on the configured channel
+func NewPostgresBus(ctx context.Context, cfg config.Config, logger *zap.Logger) (*PostgresBus, error) {
+	connStr := database.PostgresURL(cfg)
+
+	// Publishing is cheap and infrequent compared to regular queries, a tiny pool is enough
+	poolCfg, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing connection string: %w", err)
+	}
+	poolCfg.MaxConns = 2
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating publisher connection pool: %w", err)
+	}
+
+	// LISTEN requires a dedicated connection that lives as long as the bus
+	conn, err := listen(ctx, connStr, cfg.PubSub.Channel)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	bus := &PostgresBus{
+		b:       newBroker(cfg.PubSub.BufferSize, logger),
+		pool:    pool,
+		connStr: connStr,
+		channel: cfg.PubSub.Channel,
+		logger:  logger,
+		cancel:  cancel,
+	}
+
+	bus.wg.Add(1)
+	go bus.receive(listenCtx, conn)
+	logger.Info("Listening for notifications", zap.String("channel", cfg.PubSub.Channel))
+
+	return bus, nil
+}
+
+// listen opens a new connection and issues LISTEN on channel
+func listen(ctx context.Context, connStr, channel string) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("error opening listener connection: %w", err)
+	}
+	if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("error listening on channel %s: %w", channel, err)
+	}
+	return conn, nil
+}
+
+// Publish sends the payload to every replica listening on the channel
+func (p *PostgresBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	if p.b.isClosed() {
+		return ErrClosed
+	}
+
+	data, err := json.Marshal(Message{Topic: topic, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("error encoding message: %w", err)
+	}
+	if len(data) > maxNotifyPayload {
+		return fmt.Errorf("message of %d bytes exceeds the %d bytes NOTIFY limit", len(data), maxNotifyPayload)
+	}
+
+	if _, err = p.pool.Exec(ctx, "SELECT pg_notify($1, $2)", p.channel, string(data)); err != nil {
+		return fmt.Errorf("error publishing message: %w", err)
+	}
+	return nil
+}
+
+// Subscribe registers a new subscription on topic
+func (p *PostgresBus) Subscribe(topic string) (*Subscription, error) {
+	return p.b.add(topic)
+}
+
+// Close stops listening, closes all subscriptions and the bus' connections
+func (p *PostgresBus) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	p.b.close()
+	p.pool.Close()
+	return nil
+}
+
+// Check implements health.Check, failing while the listener connection is down
+func (p *PostgresBus) Check(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lostErr != nil {
+		return fmt.Errorf("listener connection lost since %s, messages from other replicas are missed: %w",
+			p.downSince.Format(time.RFC3339), p.lostErr)
+	}
+	return nil
+}
+
+func (p *PostgresBus) setLost(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil && p.lostErr == nil {
+		p.downSince = time.Now()
+	}
+	p.lostErr = err
+}
+
+// receive waits for notifications and dispatches them to local subscribers until ctx is cancelled.
+// Should the listener connection be lost, it is re-established with capped exponential backoff until
+// it succeeds; messages published in the meantime are lost, as NOTIFY does not persist them.
+func (p *PostgresBus) receive(ctx context.Context, conn *pgx.Conn) {
+	defer p.wg.Done()
+	defer func() {
+		if conn != nil {
+			closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			conn.Close(closeCtx)
+		}
+	}()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.logger.Error("Lost listener connection, reconnecting", zap.Error(err))
+			p.setLost(err)
+			conn.Close(ctx)
+			conn = nil
+
+			err = util.Retry(ctx, p.logger, reconnectPolicy, func(ctx context.Context) error {
+				var err error
+				conn, err = listen(ctx, p.connStr, p.channel)
+				return err
+			})
+			if err != nil {
+				// Only ever interrupted by Close
+				return
+			}
+			p.setLost(nil)
+			p.logger.Info("Listener connection re-established")
+			continue
+		}
+
+		var msg Message
+		if err := json.Unmarshal([]byte(notification.Payload), &msg); err != nil {
+			p.logger.Warn("Received malformed notification", zap.Error(err))
+			continue
+		}
+		p.b.dispatch(msg)
+	}
+}
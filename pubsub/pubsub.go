@@ -0,0 +1,155 @@
+/* Package pubsub defines the internal publish/subscribe bus used by real-time features.
+* Publishing goes through the Bus rather than directly to local connections, so that
+* when several server replicas are running, a message produced on one replica
+* reaches the subscribers connected to every other replica.
+ */
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/charm-113c/project-zero/config"
+	"go.uber.org/zap"
+)
+
+// ErrClosed is returned when publishing to or subscribing on a Bus that has been closed
+var ErrClosed = errors.New("pubsub: bus is closed")
+
+// Message is a single payload published on a topic
+type Message struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+}
+
+// Bus is the interface that real-time features publish to and subscribe on.
+// Implementations decide how far a message travels: the in-process bus only reaches
+// the current replica, while the Postgres bus reaches all replicas sharing the DB.
+type Bus interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(topic string) (*Subscription, error)
+	Close() error
+}
+
+// NewBus instantiates the Bus implementation selected in the config
+func NewBus(ctx context.Context, cfg config.Config, parentLogger *zap.Logger) (Bus, error) {
+	logger := parentLogger.With(zap.String("component", "pubsub"))
+	logger.Info("Pub/sub type: " + cfg.PubSub.Type)
+
+	switch cfg.PubSub.Type {
+	case "memory", "":
+		return NewMemoryBus(cfg.PubSub.BufferSize, logger), nil
+	case "postgres":
+		bus, err := NewPostgresBus(ctx, cfg, logger)
+		if err != nil {
+			return nil, fmt.Errorf("could not start postgres pub/sub: %w", err)
+		}
+		return bus, nil
+	default:
+		return nil, fmt.Errorf("pub/sub of type %s is unsupported", cfg.PubSub.Type)
+	}
+}
+
+// Subscription receives the messages published on a single topic through its C channel.
+// C is closed once Unsubscribe is called or the Bus is closed.
+type Subscription struct {
+	C     <-chan Message
+	topic string
+	ch    chan Message
+	b     *broker
+}
+
+// Unsubscribe detaches the subscription from the bus and closes its channel.
+// It is safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	s.b.remove(s)
+}
+
+// broker fans out messages to the local subscribers of each topic.
+// It is shared by all Bus implementations, which only differ in how
+// messages reach the broker.
+type broker struct {
+	mu         sync.RWMutex
+	subs       map[string]map[*Subscription]struct{}
+	bufferSize int
+	closed     bool
+	logger     *zap.Logger
+}
+
+func newBroker(bufferSize int, logger *zap.Logger) *broker {
+	if bufferSize <= 0 {
+		bufferSize = 64
+	}
+	return &broker{
+		subs:       make(map[string]map[*Subscription]struct{}),
+		bufferSize: bufferSize,
+		logger:     logger,
+	}
+}
+
+func (b *broker) add(topic string) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	ch := make(chan Message, b.bufferSize)
+	sub := &Subscription{C: ch, topic: topic, ch: ch, b: b}
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[*Subscription]struct{})
+	}
+	b.subs[topic][sub] = struct{}{}
+	return sub, nil
+}
+
+func (b *broker) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub.topic][sub]; !ok {
+		return
+	}
+	delete(b.subs[sub.topic], sub)
+	if len(b.subs[sub.topic]) == 0 {
+		delete(b.subs, sub.topic)
+	}
+	close(sub.ch)
+}
+
+// dispatch delivers msg to every local subscriber of its topic.
+// Subscribers that are too slow to keep up have the message dropped
+// rather than blocking the whole bus.
+func (b *broker) dispatch(msg Message) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs[msg.Topic] {
+		select {
+		case sub.ch <- msg:
+		default:
+			b.logger.Warn("Subscriber buffer full, dropping message", zap.String("topic", msg.Topic))
+		}
+	}
+}
+
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for topic, subs := range b.subs {
+		for sub := range subs {
+			close(sub.ch)
+		}
+		delete(b.subs, topic)
+	}
+}
+
+func (b *broker) isClosed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.closed
+}
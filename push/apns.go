@@ -0,0 +1,154 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+)
+
+const (
+	apnsProductionURL = "https://api.push.apple.com"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com"
+	// Apple rejects provider tokens older than an hour, and throttles those refreshed more than every 20 minutes
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsPusher sends push notifications to iOS devices through Apple's HTTP/2 provider API,
+// authenticating with a token signed by the team's .p8 key
+type APNsPusher struct {
+	client  *http.Client
+	baseURL string
+	topic   string
+	keyID   string
+	teamID  string
+	key     crypto.Signer
+
+	mu         sync.Mutex
+	authToken  string
+	authIssued time.Time
+}
+
+// NewAPNsPusher instantiates an APNsPusher with the key and app identifiers of the config
+func NewAPNsPusher(cfg config.Config) (*APNsPusher, error) {
+	keyData, err := os.ReadFile(cfg.Push.APNs.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading APNs key file: %w", err)
+	}
+	key, err := parsePKCS8Key(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs key: %w", err)
+	}
+
+	baseURL := apnsProductionURL
+	if cfg.Push.APNs.Sandbox {
+		baseURL = apnsSandboxURL
+	}
+
+	return &APNsPusher{
+		// Go's client negotiates HTTP/2 over TLS, as required by APNs
+		client:  &http.Client{Timeout: 10 * time.Second},
+		baseURL: baseURL,
+		topic:   cfg.Push.APNs.Topic,
+		keyID:   cfg.Push.APNs.KeyID,
+		teamID:  cfg.Push.APNs.TeamID,
+		key:     key,
+	}, nil
+}
+
+// apnsPayload is the JSON body expected by APNs: the aps dictionary and custom keys side by side
+func apnsPayload(msg Message) map[string]any {
+	payload := map[string]any{
+		"aps": map[string]any{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		if k != "aps" {
+			payload[k] = v
+		}
+	}
+	return payload
+}
+
+// Push sends msg to the device identified by token
+func (a *APNsPusher) Push(ctx context.Context, token string, msg Message) error {
+	body, err := json.Marshal(apnsPayload(msg))
+	if err != nil {
+		return fmt.Errorf("%w: error encoding APNs payload: %v", ErrPermanent, err)
+	}
+	authToken, err := a.providerToken()
+	if err != nil {
+		return fmt.Errorf("%w: error signing APNs provider token: %v", ErrPermanent, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	req.Header.Set("authorization", "bearer "+authToken)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting APNs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var apnsErr struct {
+		Reason string `json:"reason"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(respBody, &apnsErr)
+
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		apnsErr.Reason == "BadDeviceToken", apnsErr.Reason == "Unregistered", apnsErr.Reason == "DeviceTokenNotForTopic":
+		return fmt.Errorf("%w: APNs responded %d %s", ErrInvalidToken, resp.StatusCode, apnsErr.Reason)
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("APNs responded %d %s", resp.StatusCode, apnsErr.Reason)
+	default:
+		if apnsErr.Reason == "ExpiredProviderToken" {
+			a.mu.Lock()
+			a.authToken = ""
+			a.mu.Unlock()
+			return fmt.Errorf("APNs responded %d %s", resp.StatusCode, apnsErr.Reason)
+		}
+		return fmt.Errorf("%w: APNs responded %d %s", ErrPermanent, resp.StatusCode, apnsErr.Reason)
+	}
+}
+
+// providerToken returns the JWT authenticating the server with APNs, signing a new one when it's about to expire
+func (a *APNsPusher) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.authToken != "" && time.Since(a.authIssued) < apnsTokenLifetime {
+		return a.authToken, nil
+	}
+
+	now := time.Now()
+	token, err := signJWT(
+		map[string]any{"alg": "ES256", "kid": a.keyID},
+		map[string]any{"iss": a.teamID, "iat": now.Unix()},
+		a.key,
+	)
+	if err != nil {
+		return "", err
+	}
+	a.authToken, a.authIssued = token, now
+	return token, nil
+}
@@ -0,0 +1,200 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+)
+
+const (
+	fcmSendURL = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+	fcmScope   = "https://www.googleapis.com/auth/firebase.messaging"
+)
+
+// FCMPusher sends push notifications to Android devices through Firebase Cloud Messaging's
+// HTTP v1 API, authenticating with an OAuth2 token obtained for a service account
+type FCMPusher struct {
+	client      *http.Client
+	sendURL     string
+	clientEmail string
+	tokenURI    string
+	key         crypto.Signer
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMPusher instantiates an FCMPusher with the service account credentials file of the config
+func NewFCMPusher(cfg config.Config) (*FCMPusher, error) {
+	data, err := os.ReadFile(cfg.Push.FCM.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading FCM credentials file: %w", err)
+	}
+	var creds struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("error parsing FCM credentials file: %w", err)
+	}
+	key, err := parsePKCS8Key([]byte(creds.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM service account key: %w", err)
+	}
+
+	projectID := cfg.Push.FCM.ProjectID
+	if projectID == "" {
+		projectID = creds.ProjectID
+	}
+
+	return &FCMPusher{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		sendURL:     fmt.Sprintf(fcmSendURL, projectID),
+		clientEmail: creds.ClientEmail,
+		tokenURI:    creds.TokenURI,
+		key:         key,
+	}, nil
+}
+
+// fcmPayload is the JSON body expected by the FCM v1 send endpoint
+func fcmPayload(token string, msg Message) map[string]any {
+	return map[string]any{
+		"message": map[string]any{
+			"token":        token,
+			"notification": map[string]string{"title": msg.Title, "body": msg.Body},
+			"data":         msg.Data,
+			"android":      map[string]string{"priority": "high"},
+		},
+	}
+}
+
+// Push sends msg to the device identified by token
+func (f *FCMPusher) Push(ctx context.Context, token string, msg Message) error {
+	body, err := json.Marshal(fcmPayload(token, msg))
+	if err != nil {
+		return fmt.Errorf("%w: error encoding FCM payload: %v", ErrPermanent, err)
+	}
+	accessToken, err := f.oauthToken(ctx)
+	if err != nil {
+		return fmt.Errorf("error obtaining FCM access token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.sendURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting FCM: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var fcmErr struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(respBody, &fcmErr)
+	errorCode := fcmErr.Error.Status
+	for _, d := range fcmErr.Error.Details {
+		if d.ErrorCode != "" {
+			errorCode = d.ErrorCode
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound, errorCode == "UNREGISTERED":
+		return fmt.Errorf("%w: FCM responded %d %s", ErrInvalidToken, resp.StatusCode, errorCode)
+	case resp.StatusCode == http.StatusUnauthorized:
+		// The access token may have been revoked early, fetch a new one on retry
+		f.mu.Lock()
+		f.accessToken = ""
+		f.mu.Unlock()
+		return fmt.Errorf("FCM responded %d %s", resp.StatusCode, errorCode)
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("FCM responded %d %s", resp.StatusCode, errorCode)
+	default:
+		return fmt.Errorf("%w: FCM responded %d %s: %s", ErrPermanent, resp.StatusCode, errorCode, fcmErr.Error.Message)
+	}
+}
+
+// oauthToken returns a Google OAuth2 access token for the service account,
+// exchanging a freshly signed JWT assertion when the cached one is about to expire
+func (f *FCMPusher) oauthToken(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Until(f.expiresAt) > time.Minute {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(
+		map[string]any{"alg": "RS256", "typ": "JWT"},
+		map[string]any{
+			"iss":   f.clientEmail,
+			"scope": fcmScope,
+			"aud":   f.tokenURI,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		},
+		f.key,
+	)
+	if err != nil {
+		return "", fmt.Errorf("%w: error signing JWT assertion: %v", ErrPermanent, err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint responded %d", resp.StatusCode)
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("error decoding token response: %w", err)
+	}
+	f.accessToken = tok.AccessToken
+	f.expiresAt = now.Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return f.accessToken, nil
+}
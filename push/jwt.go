@@ -0,0 +1,71 @@
+package push
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// signJWT encodes header and claims and signs them with key, which must be an
+// ECDSA P-256 key (ES256, used by APNs) or an RSA key (RS256, used by Google OAuth)
+func signJWT(header, claims map[string]any, key crypto.Signer) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS expects the raw, fixed-size r||s concatenation rather than ASN.1
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case *rsa.PrivateKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported JWT signing key of type %T", key)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parsePKCS8Key parses a PEM encoded PKCS#8 private key, as distributed by Apple (.p8) and Google (service accounts)
+func parsePKCS8Key(pemData []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key of type %T", key)
+	}
+	if ec, ok := signer.(*ecdsa.PrivateKey); ok && ec.Curve.Params().BitSize != 256 {
+		return nil, errors.New("ECDSA private key must use the P-256 curve")
+	}
+	return signer, nil
+}
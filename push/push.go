@@ -0,0 +1,175 @@
+/* Package push delivers notifications to users' devices while the app is closed.
+* A Pusher sends a single message to a single device token; APNs (iOS) and FCM (Android)
+* are the live implementations, while MemorySink and FileSink allow checking the whole
+* pipeline without contacting Apple or Google. The Dispatcher fans a message out to all
+* of a user's devices and prunes dead tokens, failed deliveries being retried by the job queue.
+ */
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/logging"
+	"github.com/charm-113c/project-zero/util"
+	"go.uber.org/zap"
+)
+
+// Device platforms, each served by its own Pusher
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+var (
+	// ErrInvalidToken is returned by Pushers when the push service reports the token as dead
+	// (e.g. the app was uninstalled): such tokens are pruned and never retried
+	ErrInvalidToken = errors.New("push: device token is no longer valid")
+	// ErrPermanent is wrapped by Pushers' errors that retrying cannot fix (e.g. malformed payload, bad credentials)
+	ErrPermanent = errors.New("push: permanent failure")
+)
+
+// IsValidPlatform reports whether platform is supported
+func IsValidPlatform(platform string) bool {
+	return platform == PlatformIOS || platform == PlatformAndroid
+}
+
+// Message is the content of a push notification
+type Message struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"` // Custom key/values handed to the app
+}
+
+// Pusher sends a message to a single device
+type Pusher interface {
+	Push(ctx context.Context, token string, msg Message) error
+}
+
+// Dispatcher delivers messages to all the devices registered by a user
+type Dispatcher struct {
+	db      database.DeviceStorageHandler
+	pushers map[string]Pusher // By platform
+	logger  *zap.Logger
+}
+
+// NewDispatcher instantiates a Dispatcher with the Pushers selected by the config's push mode
+func NewDispatcher(cfg config.Config, db database.DeviceStorageHandler, parentLogger *zap.Logger) (*Dispatcher, error) {
+	logger := parentLogger.With(zap.String("component", "push"))
+	logger.Info("Push mode: " + cfg.Push.Mode)
+
+	pushers := make(map[string]Pusher)
+	switch cfg.Push.Mode {
+	case "memory", "":
+		sink := NewMemorySink()
+		pushers[PlatformIOS] = sink
+		pushers[PlatformAndroid] = sink
+	case "file":
+		sink := NewFileSink(cfg.Push.SinkFile)
+		pushers[PlatformIOS] = sink
+		pushers[PlatformAndroid] = sink
+	case "live":
+		if cfg.Push.APNs.KeyFile != "" {
+			apns, err := NewAPNsPusher(cfg)
+			if err != nil {
+				return nil, err
+			}
+			pushers[PlatformIOS] = apns
+		} else {
+			logger.Warn("APNs is not configured, iOS devices will not receive push notifications")
+		}
+		if cfg.Push.FCM.CredentialsFile != "" {
+			fcm, err := NewFCMPusher(cfg)
+			if err != nil {
+				return nil, err
+			}
+			pushers[PlatformAndroid] = fcm
+		} else {
+			logger.Warn("FCM is not configured, Android devices will not receive push notifications")
+		}
+	default:
+		return nil, fmt.Errorf("push mode %s is unsupported", cfg.Push.Mode)
+	}
+
+	return NewDispatcherWithPushers(db, pushers, logger), nil
+}
+
+// NewDispatcherWithPushers instantiates a Dispatcher with the given Pushers, indexed by platform
+func NewDispatcherWithPushers(db database.DeviceStorageHandler, pushers map[string]Pusher, logger *zap.Logger) *Dispatcher {
+	return &Dispatcher{
+		db,
+		pushers,
+		logger,
+	}
+}
+
+// PendingError is returned by Deliver when pushing to some of the user's devices failed, Tokens being theirs
+type PendingError struct {
+	Tokens []string
+	Err    error
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("could not push to %d of the user's devices: %v", len(e.Tokens), e.Err)
+}
+
+func (e *PendingError) Unwrap() error { return e.Err }
+
+// Deliver pushes msg to every device of userID, making a single attempt per device: failed deliveries
+// are retried by the job queue (see QueuedDeliverer). Tokens reported as dead are removed from storage.
+// Should pushing to some devices fail, a *PendingError listing the tokens worth retrying is returned, or,
+// if retrying can't fix any of the failures, one listing the failed tokens and marked util.Permanent
+func (d *Dispatcher) Deliver(ctx context.Context, userID string, msg Message) error {
+	return d.DeliverTo(ctx, userID, nil, msg)
+}
+
+// DeliverTo is Deliver restricted to the devices of userID whose token is in tokens, or to all of them if tokens is nil
+func (d *Dispatcher) DeliverTo(ctx context.Context, userID string, tokens []string, msg Message) error {
+	devices, err := d.db.ListDevices(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	logger := logging.FromContext(ctx, d.logger)
+	var failed []error
+	var pending, unfixable []string
+	for _, device := range devices {
+		if tokens != nil && !slices.Contains(tokens, device.Token) {
+			continue
+		}
+		pusher, ok := d.pushers[device.Platform]
+		if !ok {
+			continue
+		}
+
+		err := pusher.Push(ctx, device.Token, msg)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrInvalidToken):
+			logger.Info("Pruning dead device token", zap.String("platform", device.Platform))
+			if err := d.db.DeleteDevice(ctx, device.Token); err != nil {
+				logger.Error("Could not prune dead device token", zap.Error(err))
+			}
+		default:
+			logger.Error("Could not push to device", zap.String("platform", device.Platform), zap.Error(err))
+			failed = append(failed, err)
+			if errors.Is(err, ErrPermanent) {
+				unfixable = append(unfixable, device.Token)
+			} else {
+				pending = append(pending, device.Token)
+			}
+		}
+	}
+
+	switch {
+	case len(pending) > 0:
+		return &PendingError{pending, errors.Join(failed...)}
+	case len(unfixable) > 0:
+		return util.Permanent(&PendingError{unfixable, errors.Join(failed...)})
+	}
+	return nil
+}
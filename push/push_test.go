@@ -0,0 +1,163 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/util"
+	"go.uber.org/zap"
+)
+
+// failingPusher fails every push with err
+type failingPusher struct {
+	err error
+}
+
+func (f failingPusher) Push(ctx context.Context, token string, msg Message) error {
+	return f.err
+}
+
+func newTestDispatcher(t *testing.T, pushers map[string]Pusher, devices ...database.Device) (*Dispatcher, *MemoryDevices) {
+	t.Helper()
+	db := NewMemoryDevices(devices...)
+	return NewDispatcherWithPushers(db, pushers, zap.NewNop()), db
+}
+
+func sentTokens(sink *MemorySink) []string {
+	var tokens []string
+	for _, d := range sink.Sent() {
+		tokens = append(tokens, d.Token)
+	}
+	slices.Sort(tokens)
+	return tokens
+}
+
+func TestDeliverReachesEveryDevice(t *testing.T) {
+	sink := NewMemorySink()
+	d, _ := newTestDispatcher(t, map[string]Pusher{PlatformIOS: sink, PlatformAndroid: sink},
+		database.Device{UserID: "alice", Platform: PlatformIOS, Token: "alice-phone"},
+		database.Device{UserID: "alice", Platform: PlatformAndroid, Token: "alice-tablet"},
+		database.Device{UserID: "bob", Platform: PlatformIOS, Token: "bob-phone"},
+	)
+
+	msg := Message{Title: "Junkyard", Body: "1 person followed you", Data: map[string]string{"type": "user_followed"}}
+	if err := d.Deliver(context.Background(), "alice", msg); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	if got, want := sentTokens(sink), []string{"alice-phone", "alice-tablet"}; !slices.Equal(got, want) {
+		t.Errorf("pushed to %v, want %v", got, want)
+	}
+	for _, delivery := range sink.Sent() {
+		if delivery.Message.Body != msg.Body || delivery.Message.Data["type"] != "user_followed" {
+			t.Errorf("pushed %+v, want %+v", delivery.Message, msg)
+		}
+	}
+}
+
+func TestDeliverPrunesDeadTokens(t *testing.T) {
+	sink := NewMemorySink()
+	sink.MarkInvalid("alice-old-phone")
+	d, db := newTestDispatcher(t, map[string]Pusher{PlatformIOS: sink},
+		database.Device{UserID: "alice", Platform: PlatformIOS, Token: "alice-old-phone"},
+		database.Device{UserID: "alice", Platform: PlatformIOS, Token: "alice-phone"},
+	)
+
+	if err := d.Deliver(context.Background(), "alice", Message{Body: "hello"}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if got, want := sentTokens(sink), []string{"alice-phone"}; !slices.Equal(got, want) {
+		t.Errorf("pushed to %v, want %v", got, want)
+	}
+	if got, want := db.Tokens("alice"), []string{"alice-phone"}; !slices.Equal(got, want) {
+		t.Errorf("devices left %v, want %v", got, want)
+	}
+}
+
+func TestDeliverFailures(t *testing.T) {
+	transient := errors.New("connection reset")
+	permanent := fmt.Errorf("%w: bad credentials", ErrPermanent)
+
+	tests := map[string]struct {
+		ios, android  Pusher
+		wantPending   []string // The tokens of the returned PendingError, nil for no error
+		wantPermanent bool
+	}{
+		"all devices reached": {
+			ios: NewMemorySink(), android: NewMemorySink(),
+		},
+		"some devices reached": {
+			ios: failingPusher{transient}, android: NewMemorySink(),
+			wantPending: []string{"alice-phone"},
+		},
+		"transient and permanent failures": {
+			ios: failingPusher{transient}, android: failingPusher{permanent},
+			wantPending: []string{"alice-phone"},
+		},
+		"permanent failures": {
+			ios: failingPusher{permanent}, android: failingPusher{permanent},
+			wantPending: []string{"alice-phone", "alice-tablet"}, wantPermanent: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, _ := newTestDispatcher(t, map[string]Pusher{PlatformIOS: tt.ios, PlatformAndroid: tt.android},
+				database.Device{UserID: "alice", Platform: PlatformIOS, Token: "alice-phone"},
+				database.Device{UserID: "alice", Platform: PlatformAndroid, Token: "alice-tablet"},
+			)
+
+			err := d.Deliver(context.Background(), "alice", Message{Body: "hello"})
+			var pending *PendingError
+			if errors.As(err, &pending) != (tt.wantPending != nil) {
+				t.Fatalf("Deliver error = %v, want pending tokens %v", err, tt.wantPending)
+			}
+			if pending != nil && !slices.Equal(pending.Tokens, tt.wantPending) {
+				t.Errorf("pending tokens = %v, want %v", pending.Tokens, tt.wantPending)
+			}
+			if util.IsPermanent(err) != tt.wantPermanent {
+				t.Errorf("Deliver error %v permanent: %t, want %t", err, util.IsPermanent(err), tt.wantPermanent)
+			}
+		})
+	}
+}
+
+func TestDeliverToPendingTokens(t *testing.T) {
+	sink := NewMemorySink()
+	d, _ := newTestDispatcher(t, map[string]Pusher{PlatformIOS: sink, PlatformAndroid: sink},
+		database.Device{UserID: "alice", Platform: PlatformIOS, Token: "alice-phone"},
+		database.Device{UserID: "alice", Platform: PlatformAndroid, Token: "alice-tablet"},
+	)
+
+	// Retrying a delivery only reaches the devices left, the others having the message already
+	if err := d.DeliverTo(context.Background(), "alice", []string{"alice-tablet"}, Message{Body: "hello"}); err != nil {
+		t.Fatalf("DeliverTo: %v", err)
+	}
+	if got, want := sentTokens(sink), []string{"alice-tablet"}; !slices.Equal(got, want) {
+		t.Errorf("pushed to %v, want %v", got, want)
+	}
+}
+
+func TestMemorySinkTransientFailures(t *testing.T) {
+	sink := NewMemorySink()
+	sink.FailNext("alice-phone", 2)
+	d, _ := newTestDispatcher(t, map[string]Pusher{PlatformIOS: sink},
+		database.Device{UserID: "alice", Platform: PlatformIOS, Token: "alice-phone"},
+	)
+
+	// Each delivery makes a single attempt, retries being left to the job queue
+	for range 2 {
+		if err := d.Deliver(context.Background(), "alice", Message{Body: "hello"}); err == nil || util.IsPermanent(err) {
+			t.Fatalf("Deliver error = %v, want a transient error", err)
+		}
+	}
+	if err := d.Deliver(context.Background(), "alice", Message{Body: "hello"}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if got := len(sink.Sent()); got != 1 {
+		t.Errorf("pushed %d messages, want 1", got)
+	}
+}
@@ -0,0 +1,48 @@
+package push
+
+import (
+	"context"
+	"errors"
+
+	"github.com/charm-113c/project-zero/jobs"
+	"github.com/charm-113c/project-zero/util"
+)
+
+// deliveryQueue is the job queue push deliveries are enqueued in
+const deliveryQueue = "notifications"
+
+// DeliverArgs are the args of the job delivering a message to a user's devices
+type DeliverArgs struct {
+	UserID  string   `json:"userID"`
+	Message Message  `json:"message"`
+	Tokens  []string `json:"tokens,omitempty"` // The devices left to reach, all of the user's if empty
+}
+
+// Kind implements jobs.JobArgs
+func (DeliverArgs) Kind() string { return "push.deliver" }
+
+// QueuedDeliverer delivers messages through the durable job queue rather than directly,
+// so that deliveries survive restarts and failed ones are retried later
+type QueuedDeliverer struct {
+	m *jobs.Manager
+}
+
+// NewQueuedDeliverer registers the delivery job on m, which runs it with d
+func NewQueuedDeliverer(m *jobs.Manager, d *Dispatcher) *QueuedDeliverer {
+	jobs.AddHandler(m, func(ctx context.Context, args DeliverArgs) error {
+		err := d.DeliverTo(ctx, args.UserID, args.Tokens, args.Message)
+		var pending *PendingError
+		if errors.As(err, &pending) && !util.IsPermanent(err) {
+			// Only the devices which weren't reached are retried, so that the others don't get the message twice
+			return jobs.Remaining(DeliverArgs{args.UserID, args.Message, pending.Tokens}, err)
+		}
+		return err
+	})
+	return &QueuedDeliverer{m}
+}
+
+// Deliver enqueues the delivery of msg to all the devices of userID
+func (q *QueuedDeliverer) Deliver(ctx context.Context, userID string, msg Message) error {
+	_, err := q.m.Enqueue(ctx, DeliverArgs{UserID: userID, Message: msg}, jobs.EnqueueOpts{Queue: deliveryQueue})
+	return err
+}
@@ -0,0 +1,169 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/database"
+)
+
+// Delivery is a push recorded by a sink
+type Delivery struct {
+	Time    time.Time `json:"time"`
+	Token   string    `json:"token"`
+	Message Message   `json:"message"`
+}
+
+// MemorySink is a Pusher that records pushes in memory instead of sending them.
+// Tokens can be marked as invalid to exercise dead token pruning.
+type MemorySink struct {
+	mu       sync.Mutex
+	sent     []Delivery
+	invalid  map[string]bool
+	failures map[string]int
+}
+
+// NewMemorySink instantiates a MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{
+		invalid:  make(map[string]bool),
+		failures: make(map[string]int),
+	}
+}
+
+// Push records the delivery, or fails if the token was marked as invalid or failing
+func (m *MemorySink) Push(ctx context.Context, token string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.invalid[token] {
+		return ErrInvalidToken
+	}
+	if m.failures[token] > 0 {
+		m.failures[token]--
+		return fmt.Errorf("simulated transient failure for token %s", token)
+	}
+	m.sent = append(m.sent, Delivery{time.Now(), token, msg})
+	return nil
+}
+
+// MarkInvalid makes the following pushes to token fail with ErrInvalidToken
+func (m *MemorySink) MarkInvalid(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invalid[token] = true
+}
+
+// FailNext makes the next n pushes to token fail with a transient error
+func (m *MemorySink) FailNext(token string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[token] = n
+}
+
+// Sent returns a copy of the deliveries recorded so far
+func (m *MemorySink) Sent() []Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Delivery(nil), m.sent...)
+}
+
+// MemoryDevices is an in-memory database.DeviceStorageHandler, to go with MemorySink
+// in tests and development. Registering a token already registered moves it to the new user
+type MemoryDevices struct {
+	mu      sync.Mutex
+	devices []database.Device
+}
+
+// NewMemoryDevices instantiates a MemoryDevices with devices registered
+func NewMemoryDevices(devices ...database.Device) *MemoryDevices {
+	m := &MemoryDevices{}
+	for _, d := range devices {
+		_ = m.RegisterDevice(context.Background(), d)
+	}
+	return m
+}
+
+// RegisterDevice registers d, replacing any device with the same token
+func (m *MemoryDevices) RegisterDevice(ctx context.Context, d database.Device) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices = slices.DeleteFunc(m.devices, func(existing database.Device) bool { return existing.Token == d.Token })
+	m.devices = append(m.devices, d)
+	return nil
+}
+
+// UnregisterDevice removes the device of userID with token, if any
+func (m *MemoryDevices) UnregisterDevice(ctx context.Context, userID, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices = slices.DeleteFunc(m.devices, func(d database.Device) bool { return d.UserID == userID && d.Token == token })
+	return nil
+}
+
+// ListDevices returns the devices registered by userID
+func (m *MemoryDevices) ListDevices(ctx context.Context, userID string) ([]database.Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var devices []database.Device
+	for _, d := range m.devices {
+		if d.UserID == userID {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// DeleteDevice removes the device with token, whoever registered it
+func (m *MemoryDevices) DeleteDevice(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices = slices.DeleteFunc(m.devices, func(d database.Device) bool { return d.Token == token })
+	return nil
+}
+
+// Tokens returns the tokens of the devices registered by userID, e.g. to check that dead ones were pruned
+func (m *MemoryDevices) Tokens(userID string) []string {
+	devices, _ := m.ListDevices(context.Background(), userID)
+	var tokens []string
+	for _, d := range devices {
+		tokens = append(tokens, d.Token)
+	}
+	return tokens
+}
+
+// FileSink is a Pusher that appends pushes to a file as JSON lines instead of sending them
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSink instantiates a FileSink writing to path
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Push appends the delivery to the sink file
+func (f *FileSink) Push(ctx context.Context, token string, msg Message) error {
+	line, err := json.Marshal(Delivery{time.Now(), token, msg})
+	if err != nil {
+		return fmt.Errorf("%w: error encoding delivery: %v", ErrPermanent, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening push sink file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing to push sink file: %w", err)
+	}
+	return nil
+}
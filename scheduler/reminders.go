@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charm-113c/project-zero/database"
+	"github.com/charm-113c/project-zero/notifications"
+	"github.com/charm-113c/project-zero/util"
+)
+
+// KindEventReminder is the kind of the jobs reminding participants of an upcoming event
+const KindEventReminder = "event_reminder"
+
+// Reminder is the payload of an event reminder job
+type Reminder struct {
+	EventID  string        `json:"eventID"`
+	StartsAt time.Time     `json:"startsAt"` // The event's start time when the reminder was scheduled
+	Before   time.Duration `json:"before"`
+}
+
+// ReminderNotifier delivers event reminders to their recipients
+type ReminderNotifier interface {
+	NotifyReminder(ctx context.Context, r Reminder) error
+}
+
+// EventReminders schedules reminders for the participants of events,
+// some time before they start (e.g. 24 hours and 1 hour)
+type EventReminders struct {
+	sched    *Scheduler
+	events   database.EventStorageHandler
+	notifier ReminderNotifier
+	offsets  []time.Duration
+}
+
+// NewEventReminders instantiates EventReminders and registers its job handler on sched
+func NewEventReminders(sched *Scheduler, events database.EventStorageHandler, notifier ReminderNotifier, offsets []time.Duration) *EventReminders {
+	r := &EventReminders{sched, events, notifier, offsets}
+	sched.Register(KindEventReminder, r.run)
+	return r
+}
+
+func eventGroupKey(eventID string) string {
+	return "event:" + eventID
+}
+
+// ScheduleFor (re)schedules the reminders of the event starting at startsAt, replacing any
+// reminder previously scheduled for it. It must be called when an event is created or rescheduled,
+// which HandleDomainEvent does when subscribed to the outbox
+func (r *EventReminders) ScheduleFor(ctx context.Context, eventID string, startsAt time.Time) error {
+	jobs, err := r.reminderJobs(eventID, startsAt)
+	if err != nil {
+		return err
+	}
+	return r.sched.Schedule(ctx, KindEventReminder, eventGroupKey(eventID), jobs...)
+}
+
+func (r *EventReminders) reminderJobs(eventID string, startsAt time.Time) ([]Job, error) {
+	var jobs []Job
+	for _, before := range r.offsets {
+		runAt := startsAt.Add(-before)
+		if runAt.Before(time.Now()) {
+			continue
+		}
+		payload, err := json.Marshal(Reminder{eventID, startsAt, before})
+		if err != nil {
+			return nil, fmt.Errorf("error encoding reminder: %w", err)
+		}
+		jobs = append(jobs, Job{RunAt: runAt, Payload: payload})
+	}
+	return jobs, nil
+}
+
+// HandleDomainEvent reschedules the reminders of the events created or updated, as an outbox.Handler
+// subscribed to database.EventCreated and database.EventUpdated
+func (r *EventReminders) HandleDomainEvent(ctx context.Context, ev database.DomainEvent) error {
+	var event database.Event
+	if err := ev.Decode(&event); err != nil {
+		return util.Permanent(fmt.Errorf("malformed payload: %w", err))
+	}
+	return r.ScheduleFor(ctx, event.ID, event.StartsAt)
+}
+
+// run handles a reminder job. Should the event have been rescheduled without its reminders
+// being updated, they are rescheduled instead of being sent at the wrong time, within the job's
+// transaction so that this reminder is replaced along with the others
+func (r *EventReminders) run(ctx context.Context, job database.ScheduledJob, tx Tx) error {
+	var rem Reminder
+	if err := json.Unmarshal(job.Payload, &rem); err != nil {
+		return fmt.Errorf("malformed reminder payload: %w", err)
+	}
+
+	ev, err := r.events.GetEvent(ctx, rem.EventID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !ev.StartsAt.Equal(rem.StartsAt) {
+		jobs, err := r.reminderJobs(ev.ID, ev.StartsAt)
+		if err != nil {
+			return err
+		}
+		return tx.Schedule(ctx, KindEventReminder, eventGroupKey(ev.ID), jobs...)
+	}
+
+	return r.notifier.NotifyReminder(ctx, rem)
+}
+
+// ParticipantNotifier is the default ReminderNotifier: it emits a notification
+// to each participant of the event
+type ParticipantNotifier struct {
+	Events  database.EventStorageHandler
+	Emitter notifications.Emitter
+}
+
+// NotifyReminder emits an event reminder notification to every participant of the event
+func (p *ParticipantNotifier) NotifyReminder(ctx context.Context, r Reminder) error {
+	participants, err := p.Events.ListEventParticipants(ctx, r.EventID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, userID := range participants {
+		err := p.Emitter.Emit(ctx, notifications.Trigger{
+			Type:        notifications.TypeEventReminder,
+			RecipientID: userID,
+			SubjectID:   r.EventID,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
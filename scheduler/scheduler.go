@@ -0,0 +1,169 @@
+/* Package scheduler runs jobs at a given time. Jobs are persisted through the
+* database.ScheduleStorageHandler so that they survive restarts, and every replica
+* polls for due jobs: each job is nonetheless run by a single replica, the storage
+* guaranteeing exclusivity (with advisory locks in the case of Postgres).
+ */
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"go.uber.org/zap"
+)
+
+// dueJobsBatch is the maximum number of due jobs fetched at each poll
+const dueJobsBatch = 100
+
+// Handler runs a job of the kind it is registered for. The jobs it schedules through tx are
+// committed along with the job's outcome
+type Handler func(ctx context.Context, job database.ScheduledJob, tx Tx) error
+
+// Job is a job to be scheduled
+type Job struct {
+	RunAt   time.Time
+	Payload []byte
+}
+
+// Scheduler polls for due jobs and runs them with the Handler registered for their kind
+type Scheduler struct {
+	db           database.ScheduleStorageHandler
+	handlers     map[string]Handler
+	pollInterval time.Duration
+	maxAttempts  int
+	logger       *zap.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New instantiates a Scheduler; handlers must be registered before calling Start
+func New(cfg config.Config, db database.ScheduleStorageHandler, parentLogger *zap.Logger) *Scheduler {
+	pollInterval := cfg.Scheduler.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = 10 * time.Second
+	}
+	return &Scheduler{
+		db:           db,
+		handlers:     make(map[string]Handler),
+		pollInterval: pollInterval,
+		maxAttempts:  max(cfg.Scheduler.MaxAttempts, 1),
+		logger:       parentLogger.With(zap.String("component", "scheduler")),
+	}
+}
+
+// Register sets the Handler running the jobs of kind
+func (s *Scheduler) Register(kind string, h Handler) {
+	s.handlers[kind] = h
+}
+
+// Schedule replaces all the jobs of kind in groupKey with jobs.
+// Scheduling no jobs cancels the group's pending jobs
+func (s *Scheduler) Schedule(ctx context.Context, kind, groupKey string, jobs ...Job) error {
+	return s.schedule(ctx, s.db, kind, groupKey, jobs)
+}
+
+// Tx schedules jobs within the transaction of the job being run, see Handler
+type Tx struct {
+	s  *Scheduler
+	db database.ScheduleStorageHandler
+}
+
+// Schedule is Scheduler.Schedule, run in the transaction of the job being run
+func (tx Tx) Schedule(ctx context.Context, kind, groupKey string, jobs ...Job) error {
+	return tx.s.schedule(ctx, tx.db, kind, groupKey, jobs)
+}
+
+func (s *Scheduler) schedule(ctx context.Context, db database.ScheduleStorageHandler, kind, groupKey string, jobs []Job) error {
+	if _, ok := s.handlers[kind]; !ok {
+		return fmt.Errorf("no handler registered for jobs of kind %s", kind)
+	}
+	scheduled := make([]database.ScheduledJob, 0, len(jobs))
+	for _, job := range jobs {
+		scheduled = append(scheduled, database.ScheduledJob{
+			Kind:     kind,
+			GroupKey: groupKey,
+			RunAt:    job.RunAt,
+			Payload:  job.Payload,
+		})
+	}
+	return db.ReplaceJobs(ctx, kind, groupKey, scheduled)
+}
+
+// Start polls for due jobs in the background until Stop is called or ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		s.logger.Info("Scheduler started", zap.Duration("pollInterval", s.pollInterval))
+		for {
+			s.runDueJobs(ctx)
+			select {
+			case <-ctx.Done():
+				s.logger.Info("Scheduler stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops polling and waits for the jobs being run to complete
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// retryAt pushes failed jobs back exponentially, from 1 minute up to 1 hour
+func retryAt(attempts int) time.Time {
+	delay := time.Minute << min(attempts-1, 6)
+	return time.Now().Add(min(delay, time.Hour))
+}
+
+func (s *Scheduler) runDueJobs(ctx context.Context) {
+	jobs, err := s.db.DueJobs(ctx, time.Now(), dueJobsBatch)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Error("Could not fetch due jobs", zap.Error(err))
+		}
+		return
+	}
+
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		handler, ok := s.handlers[job.Kind]
+		if !ok {
+			s.logger.Warn("No handler registered for due job, skipping", zap.String("kind", job.Kind), zap.Int64("jobID", job.ID))
+			continue
+		}
+
+		_, err := s.db.RunJobExclusively(ctx, job.ID, s.maxAttempts, retryAt, func(ctx context.Context, job database.ScheduledJob, tx database.ScheduleStorageHandler) error {
+			if err := handler(ctx, job, Tx{s, tx}); err != nil {
+				s.logger.Error("Scheduled job failed",
+					zap.String("kind", job.Kind),
+					zap.Int64("jobID", job.ID),
+					zap.Int("attempt", job.Attempts+1),
+					zap.Bool("givingUp", job.Attempts+1 >= s.maxAttempts),
+					zap.Error(err),
+				)
+				return err
+			}
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			s.logger.Error("Could not run scheduled job", zap.Int64("jobID", job.ID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package seed
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// city is a center events cluster around; the larger its population, the more events it gets
+type city struct {
+	name       string
+	lat, lon   float64
+	population float64 // Millions, approximately
+	radiusKm   float64 // Standard deviation of the distance of events to the center
+}
+
+var cities = []city{
+	{"Paris", 48.8566, 2.3522, 11, 6},
+	{"London", 51.5074, -0.1278, 9.5, 8},
+	{"Berlin", 52.5200, 13.4050, 3.7, 6},
+	{"Madrid", 40.4168, -3.7038, 6.7, 5},
+	{"Rome", 41.9028, 12.4964, 4.3, 5},
+	{"Milan", 45.4642, 9.1900, 3.2, 4},
+	{"Amsterdam", 52.3676, 4.9041, 1.2, 3},
+	{"Brussels", 50.8503, 4.3517, 1.2, 3},
+	{"Lisbon", 38.7223, -9.1393, 2.9, 4},
+	{"Vienna", 48.2082, 16.3738, 1.9, 4},
+	{"New York", 40.7128, -74.0060, 19, 10},
+	{"Toronto", 43.6532, -79.3832, 6.2, 8},
+	{"Tokyo", 35.6762, 139.6503, 37, 12},
+	{"Seoul", 37.5665, 126.9780, 9.9, 7},
+	{"Sydney", -33.8688, 151.2093, 5.3, 10},
+	{"São Paulo", -23.5505, -46.6333, 22, 10},
+}
+
+// totalPopulation is the sum of the cities' populations, for pickCity
+var totalPopulation = func() float64 {
+	var total float64
+	for _, c := range cities {
+		total += c.population
+	}
+	return total
+}()
+
+// pickCity draws a city according to its population
+func pickCity(rng *rand.Rand) city {
+	target := rng.Float64() * totalPopulation
+	for _, c := range cities {
+		if target -= c.population; target < 0 {
+			return c
+		}
+	}
+	return cities[len(cities)-1]
+}
+
+// kmPerDegree is the length of a degree of latitude, or of longitude at the equator
+const kmPerDegree = 111.32
+
+// scatter returns a location around the city's center, at a normally distributed distance
+func scatter(rng *rand.Rand, c city) (lat, lon float64) {
+	lat = c.lat + rng.NormFloat64()*c.radiusKm/kmPerDegree
+	lon = c.lon + rng.NormFloat64()*c.radiusKm/(kmPerDegree*math.Cos(c.lat*math.Pi/180))
+	return lat, lon
+}
+
+var firstNames = []string{
+	"alice", "bob", "chloe", "david", "emma", "farid", "giulia", "hugo", "ines", "jonas",
+	"kenji", "lea", "mateo", "nora", "oscar", "priya", "quentin", "rosa", "sami", "tomas",
+	"ugo", "valentina", "wei", "ximena", "yusuf", "zoe",
+}
+
+var lastNames = []string{
+	"martin", "smith", "muller", "garcia", "rossi", "silva", "kim", "tanaka", "dubois", "jansen",
+	"novak", "cohen", "nguyen", "haddad", "costa", "schmidt", "lopez", "bianchi", "peeters", "sato",
+}
+
+var activities = []string{
+	"Picnic", "Board games night", "Running club", "Jazz concert", "Photo walk", "Book club",
+	"Street food tour", "Climbing session", "Language exchange", "Open-air cinema", "Flea market", "Beach volleyball",
+}
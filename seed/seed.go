@@ -0,0 +1,252 @@
+/* Package seed fills a storage with fake yet realistic data, for development and load testing:
+* users, a follow graph whose degrees follow a power law (a few users are followed by many,
+* most by few), and events clustered around cities. The data is deterministic: the same seed
+* and size always produce the same records (events' start times aside, which are relative to
+* the seeding), and seeding again only adds the missing ones.
+ */
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"github.com/charm-113c/project-zero/database"
+	"go.uber.org/zap"
+)
+
+// ErrProduction is returned when trying to seed a storage configured for production
+var ErrProduction = errors.New("seed: refusing to seed a storage outside of development mode")
+
+// Size is the volume of data to generate
+type Size struct {
+	Users int
+	// AvgFollowing is the average number of users a user follows
+	AvgFollowing int
+	Events       int
+}
+
+// Sizes are the presets of Size, by name
+var Sizes = map[string]Size{
+	"small":  {Users: 200, AvgFollowing: 15, Events: 100},
+	"medium": {Users: 10_000, AvgFollowing: 40, Events: 5_000},
+	"huge":   {Users: 100_000, AvgFollowing: 60, Events: 50_000},
+}
+
+// Report counts the records written, existing ones included
+type Report struct {
+	Users   int
+	Follows int
+	Events  int
+}
+
+// Seeder writes the generated data through the storage's handlers, so that it works with any backend
+type Seeder struct {
+	storage database.Storage
+	seed    uint64
+	workers int
+	logger  *zap.Logger
+}
+
+// CheckConfig returns ErrProduction unless cfg is in development mode, the only one seeding is allowed in.
+// It's meant to be called before connecting to the storage, rather than relying on NewSeeder's check
+func CheckConfig(cfg config.Config) error {
+	if !cfg.Server.DevMode {
+		return ErrProduction
+	}
+	return nil
+}
+
+// NewSeeder instantiates a Seeder generating data from seed, writing it with workers concurrent
+// operations. It returns ErrProduction unless cfg is in development mode
+func NewSeeder(cfg config.Config, storage database.Storage, seed uint64, workers int, parentLogger *zap.Logger) (*Seeder, error) {
+	if err := CheckConfig(cfg); err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return &Seeder{storage, seed, workers, parentLogger.With(zap.String("component", "seed"))}, nil
+}
+
+// Run generates and writes the users, their follows and the events, in this order
+func (s *Seeder) Run(ctx context.Context, size Size) (Report, error) {
+	// Consumers mustn't react to fake data, e.g. by sending thousands of follow notifications
+	ctx = database.WithoutOutbox(ctx)
+	var report Report
+	start := time.Now()
+
+	err := s.parallel(ctx, size.Users, func(ctx context.Context, i int) error {
+		return s.storage.Conns.AccTableOps.CreateAccount(ctx, s.user(i))
+	})
+	if err != nil {
+		return report, fmt.Errorf("error seeding users: %w", err)
+	}
+	report.Users = size.Users
+	s.logger.Info("Users seeded", zap.Int("count", report.Users), zap.Duration("elapsed", time.Since(start)))
+
+	popularity := s.popularity(size.Users)
+	var follows atomic.Int64
+	err = s.parallel(ctx, size.Users, func(ctx context.Context, i int) error {
+		for _, followee := range s.following(i, size, popularity) {
+			if err := s.storage.Conns.SocialTableOps.FollowUser(ctx, s.userID(i), s.userID(followee)); err != nil {
+				return err
+			}
+			follows.Add(1)
+		}
+		return nil
+	})
+	report.Follows = int(follows.Load())
+	if err != nil {
+		return report, fmt.Errorf("error seeding follows: %w", err)
+	}
+	s.logger.Info("Follows seeded", zap.Int("count", report.Follows), zap.Duration("elapsed", time.Since(start)))
+
+	err = s.parallel(ctx, size.Events, func(ctx context.Context, i int) error {
+		// Creators take part in their events, which mustn't exist without them
+		return s.storage.WithTx(ctx, func(tx database.Storage) error {
+			event, err := tx.Conns.EvTableOps.CreateEvent(ctx, s.event(i, size, popularity))
+			if err != nil {
+				return err
+			}
+			return tx.Conns.EvTableOps.JoinEvent(ctx, event.ID, event.CreatorID)
+		})
+	})
+	if err != nil {
+		return report, fmt.Errorf("error seeding events: %w", err)
+	}
+	report.Events = size.Events
+	s.logger.Info("Events seeded", zap.Int("count", report.Events), zap.Duration("elapsed", time.Since(start)))
+
+	return report, nil
+}
+
+// parallel calls fn for every i in [0, n) with the Seeder's workers, stopping at the first error
+func (s *Seeder) parallel(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var next atomic.Int64
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for range min(s.workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < n && ctx.Err() == nil; i = int(next.Add(1) - 1) {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() { firstErr = err })
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// rng returns the random generator of the entity i of kind, so that each entity is generated
+// the same way whatever the order, or the number of entities of larger sizes
+func (s *Seeder) rng(kind uint64, i int) *rand.Rand {
+	return rand.New(rand.NewPCG(s.seed, kind<<48|uint64(i)))
+}
+
+// Kinds of the generated entities, for rng
+const (
+	kindUser uint64 = iota + 1
+	kindFollow
+	kindEvent
+	kindPopularity
+)
+
+func (s *Seeder) userID(i int) string {
+	return fmt.Sprintf("seed-%d-user-%06d", s.seed, i)
+}
+
+func (s *Seeder) user(i int) database.Account {
+	rng := s.rng(kindUser, i)
+	first := firstNames[rng.IntN(len(firstNames))]
+	last := lastNames[rng.IntN(len(lastNames))]
+	username := fmt.Sprintf("%s.%s%d", first, last, i)
+	return database.Account{
+		ID:       s.userID(i),
+		Username: username,
+		Email:    username + "@example.com",
+	}
+}
+
+// popularity returns the cumulative weights of the users as followees. Weights follow Zipf's law
+// over a random ranking of the users, so that popular users are spread over the IDs
+func (s *Seeder) popularity(users int) []float64 {
+	rank := s.rng(kindPopularity, 0).Perm(users)
+	cumulative := make([]float64, users)
+	var total float64
+	for i := range cumulative {
+		total += 1 / math.Pow(float64(rank[i]+1), zipfExponent)
+		cumulative[i] = total
+	}
+	return cumulative
+}
+
+// zipfExponent shapes the in-degrees: the higher, the more the follows concentrate on popular users
+const zipfExponent = 0.9
+
+// pick returns a user drawn according to their popularity
+func pick(rng *rand.Rand, popularity []float64) int {
+	return sort.SearchFloat64s(popularity, rng.Float64()*popularity[len(popularity)-1])
+}
+
+// following returns the users that the user i follows. Out-degrees follow a log-normal
+// distribution around size.AvgFollowing, while followees are drawn by popularity
+func (s *Seeder) following(i int, size Size, popularity []float64) []int {
+	if size.Users < 2 {
+		return nil
+	}
+	rng := s.rng(kindFollow, i)
+	const sigma = 1.0
+	mu := math.Log(float64(size.AvgFollowing)) - sigma*sigma/2
+	degree := min(int(math.Exp(mu+sigma*rng.NormFloat64())), size.Users-1)
+
+	followees := make([]int, 0, degree)
+	seen := map[int]bool{i: true}
+	// Popular users may be drawn repeatedly, so give up on reaching the degree after a while
+	for attempts := 0; len(followees) < degree && attempts < 10*degree; attempts++ {
+		if followee := pick(rng, popularity); !seen[followee] {
+			seen[followee] = true
+			followees = append(followees, followee)
+		}
+	}
+	return followees
+}
+
+func (s *Seeder) event(i int, size Size, popularity []float64) database.Event {
+	rng := s.rng(kindEvent, i)
+	city := pickCity(rng)
+	lat, lon := scatter(rng, city)
+	// Popular users are more likely to organise events
+	creator := pick(rng, popularity)
+	// Events start on the hour, within the coming two months from the first seeding
+	startsAt := time.Now().Truncate(time.Hour).Add(time.Duration(1+rng.IntN(60*24)) * time.Hour)
+
+	return database.Event{
+		ID:          fmt.Sprintf("seed-%d-event-%06d", s.seed, i),
+		CreatorID:   s.userID(creator),
+		Title:       fmt.Sprintf("%s in %s", activities[rng.IntN(len(activities))], city.name),
+		Description: "Generated by the seed command",
+		StartsAt:    startsAt,
+		Latitude:    lat,
+		Longitude:   lon,
+	}
+}
@@ -0,0 +1,212 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterExporter writes spans as JSON lines, e.g. to stdout or to a file
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer // nil if the writer mustn't be closed
+}
+
+// NewStdoutExporter instantiates a WriterExporter writing to stdout
+func NewStdoutExporter() *WriterExporter {
+	return &WriterExporter{w: os.Stdout}
+}
+
+// NewFileExporter instantiates a WriterExporter appending to the file at path
+func NewFileExporter(path string) (*WriterExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating trace file directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening trace file: %w", err)
+	}
+	return &WriterExporter{w: file, closer: file}, nil
+}
+
+func (e *WriterExporter) Export(ctx context.Context, spans []SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, span := range spans {
+		if err := enc.Encode(span); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+func (e *WriterExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector (or any backend accepting OTLP,
+// such as Jaeger or Tempo) through OTLP/HTTP, using its JSON encoding
+type OTLPExporter struct {
+	client      *http.Client
+	url         string
+	serviceName string
+}
+
+// NewOTLPExporter instantiates an OTLPExporter sending to the collector at endpoint, e.g. http://localhost:4318
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+	}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("error encoding spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting OTLP collector: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP collector responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// The types below follow the JSON encoding of OTLP's ExportTraceServiceRequest,
+// in which IDs are hex encoded and 64-bit integers are strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0 unset, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "github.com/charm-113c/project-zero/tracing"
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = []otlpAttribute{{"service.name", otlpValue(e.serviceName)}}
+	return otlpRequest{[]otlpResourceSpans{resource}}
+}
+
+func otlpAttributes(attrs map[string]any) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	// Sorted for a stable output
+	sort.Strings(keys)
+	out := make([]otlpAttribute, 0, len(attrs))
+	for _, key := range keys {
+		out = append(out, otlpAttribute{key, otlpValue(attrs[key])})
+	}
+	return out
+}
+
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int32:
+		return map[string]any{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOTLPExportPayload(t *testing.T) {
+	var path, contentType string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	start := time.Unix(1700000000, 123)
+	spans := []SpanData{{
+		TraceID:      testTraceID,
+		SpanID:       testSpanID,
+		ParentSpanID: "b7ad6b7169203331",
+		Name:         "GET /events",
+		Kind:         KindServer,
+		Start:        start,
+		End:          start.Add(time.Second),
+		Attributes: map[string]any{
+			"http.status_code": 500,
+			"http.method":      "GET",
+			"cache.hit":        false,
+			"db.rows":          int64(3),
+			"sample.ratio":     0.5,
+			"peer":             time.Second, // Neither of the OTLP value types
+		},
+		Error: "storage unavailable",
+	}, {
+		TraceID: testTraceID,
+		SpanID:  "b7ad6b7169203331",
+		Name:    "root",
+		Kind:    KindInternal,
+		Start:   start,
+		End:     start,
+	}}
+
+	exporter := NewOTLPExporter(srv.URL+"/", "junkyard")
+	if err := exporter.Export(context.Background(), spans); err != nil {
+		t.Fatalf("Export() = %v", err)
+	}
+	if path != "/v1/traces" || contentType != "application/json" {
+		t.Errorf("posted %s to %s, want application/json to /v1/traces", contentType, path)
+	}
+
+	// The JSON encoding of OTLP's ExportTraceServiceRequest, with hex IDs and 64-bit integers as strings
+	want := `{"resourceSpans": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "junkyard"}}]},
+		"scopeSpans": [{
+			"scope": {"name": "github.com/charm-113c/project-zero/tracing"},
+			"spans": [{
+				"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"spanId": "00f067aa0ba902b7",
+				"parentSpanId": "b7ad6b7169203331",
+				"name": "GET /events",
+				"kind": 2,
+				"startTimeUnixNano": "1700000000000000123",
+				"endTimeUnixNano": "1700000001000000123",
+				"attributes": [
+					{"key": "cache.hit", "value": {"boolValue": false}},
+					{"key": "db.rows", "value": {"intValue": "3"}},
+					{"key": "http.method", "value": {"stringValue": "GET"}},
+					{"key": "http.status_code", "value": {"intValue": "500"}},
+					{"key": "peer", "value": {"stringValue": "1s"}},
+					{"key": "sample.ratio", "value": {"doubleValue": 0.5}}
+				],
+				"status": {"code": 2, "message": "storage unavailable"}
+			}, {
+				"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"spanId": "b7ad6b7169203331",
+				"name": "root",
+				"kind": 1,
+				"startTimeUnixNano": "1700000000000000123",
+				"endTimeUnixNano": "1700000000000000123",
+				"status": {"code": 0}
+			}]
+		}]
+	}]}`
+	var got, wanted any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("decoding payload %s: %v", body, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wanted); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("payload = %s\nwant %s", body, want)
+	}
+}
+
+func TestOTLPExportFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := NewOTLPExporter(srv.URL, "junkyard").Export(context.Background(), []SpanData{{Name: "span"}})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Export() = %v, want the collector's status", err)
+	}
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper recording a client span for each request,
+// and propagating it to the remote service through the traceparent header
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+	// Parent is the context whose span the requests' spans are children of, for libraries
+	// that don't pass a context to their requests (e.g. Logto's); requests whose own
+	// context carries a span are children of that span instead
+	Parent context.Context
+}
+
+// NewHTTPClient returns an http.Client tracing its requests as children of the span carried by ctx
+func NewHTTPClient(ctx context.Context) *http.Client {
+	return &http.Client{Transport: &Transport{Parent: ctx}}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx := req.Context()
+	if SpanFromContext(ctx) == nil && t.Parent != nil {
+		if parent := SpanFromContext(t.Parent); parent != nil {
+			ctx = context.WithValue(ctx, spanCtxKey{}, parent)
+		}
+	}
+	ctx, span := Start(ctx, "HTTP "+req.Method+" "+req.URL.Host, KindClient)
+	if span == nil {
+		return base.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttr("http.request.method", req.Method)
+	span.SetAttr("server.address", req.URL.Host)
+	span.SetAttr("url.path", req.URL.Path)
+
+	// RoundTrippers must not modify the request they're given
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return resp, err
+	}
+	span.SetAttr("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.RecordError(fmt.Errorf("%s responded %d", req.URL.Host, resp.StatusCode))
+	}
+	return resp, nil
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C Trace Context header carrying the parent span across services
+const TraceparentHeader = "traceparent"
+
+// Extract parses the traceparent header of h, returning false if it's missing or malformed.
+// See https://www.w3.org/TR/trace-context/#traceparent-header
+func Extract(h http.Header) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h.Get(TraceparentHeader)), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	// Future versions may append fields, but version 00 has exactly four
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flagByte [1]byte
+	if !decodeHex(sc.TraceID[:], traceID) || !decodeHex(sc.SpanID[:], spanID) || !decodeHex(flagByte[:], flags) {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flagByte[0]&0x01 == 1
+	return sc, sc.IsValid()
+}
+
+// Inject sets the traceparent header of h to the span carried by ctx, if any,
+// so that the service receiving the request records its spans as children of it
+func Inject(ctx context.Context, h http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	flags := "00"
+	if span.sc.Sampled {
+		flags = "01"
+	}
+	h.Set(TraceparentHeader, "00-"+span.sc.TraceID.String()+"-"+span.sc.SpanID.String()+"-"+flags)
+}
+
+// decodeHex decodes the lowercase hex string s into dst, which it must fill exactly
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testSpanID + "-01", true, true},
+		{"not sampled", "00-" + testTraceID + "-" + testSpanID + "-00", true, false},
+		{"other flags set", "00-" + testTraceID + "-" + testSpanID + "-03", true, true},
+		{"surrounding spaces", " 00-" + testTraceID + "-" + testSpanID + "-01 ", true, true},
+		{"future version with more fields", "01-" + testTraceID + "-" + testSpanID + "-01-extra", true, true},
+		{"missing", "", false, false},
+		{"too few fields", "00-" + testTraceID + "-" + testSpanID, false, false},
+		{"version 00 with more fields", "00-" + testTraceID + "-" + testSpanID + "-01-extra", false, false},
+		{"invalid version", "ff-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"long version", "000-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + testSpanID + "-01", false, false},
+		{"short trace ID", "00-4bf92f3577b34da6-" + testSpanID + "-01", false, false},
+		{"short span ID", "00-" + testTraceID + "-00f067aa-01", false, false},
+		{"not hex", "00-" + testTraceID + "-00f067aa0ba902zz-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + testSpanID + "-01", false, false},
+		{"zero span ID", "00-" + testTraceID + "-0000000000000000-01", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			h.Set(TraceparentHeader, tt.traceparent)
+			sc, ok := Extract(h)
+			if ok != tt.wantOK {
+				t.Fatalf("Extract(%q) ok = %v, want %v", tt.traceparent, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID || sc.Sampled != tt.wantSampled {
+				t.Errorf("Extract(%q) = %s-%s sampled %v, want %s-%s sampled %v", tt.traceparent,
+					sc.TraceID, sc.SpanID, sc.Sampled, testTraceID, testSpanID, tt.wantSampled)
+			}
+		})
+	}
+}
+
+// memoryExporter records the spans it's handed
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memoryExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Close() error { return nil }
+
+func TestPropagationRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampled bool
+		flags   string
+		ratio   float64 // Of the local tracer, which the remote parent's sampling decision prevails over
+	}{
+		{"sampled", true, "01", 0},
+		{"not sampled", false, "00", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := &memoryExporter{}
+			tracer := NewTracerWithExporter(exporter, "junkyard", tt.ratio, zap.NewNop())
+
+			incoming := http.Header{}
+			incoming.Set(TraceparentHeader, "00-"+testTraceID+"-"+testSpanID+"-"+tt.flags)
+			remote, ok := Extract(incoming)
+			if !ok {
+				t.Fatal("Extract failed")
+			}
+			ctx, span := tracer.Start(ContextWithRemoteParent(context.Background(), remote), "GET /events", KindServer)
+
+			outgoing := http.Header{}
+			Inject(ctx, outgoing)
+			want := "00-" + testTraceID + "-" + span.Context().SpanID.String() + "-" + tt.flags
+			if got := outgoing.Get(TraceparentHeader); got != want {
+				t.Errorf("injected %q, want %q", got, want)
+			}
+			child, ok := Extract(outgoing)
+			if !ok || child != span.Context() {
+				t.Errorf("extracted %+v, want %+v", child, span.Context())
+			}
+
+			span.End()
+			if err := tracer.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.sampled {
+				if len(exporter.spans) != 0 {
+					t.Errorf("exported %d spans of an unsampled trace", len(exporter.spans))
+				}
+				return
+			}
+			if len(exporter.spans) != 1 {
+				t.Fatalf("exported %d spans, want 1", len(exporter.spans))
+			}
+			if got := exporter.spans[0]; got.TraceID != testTraceID || got.ParentSpanID != testSpanID {
+				t.Errorf("exported span of trace %s with parent %s, want %s and %s", got.TraceID, got.ParentSpanID, testTraceID, testSpanID)
+			}
+		})
+	}
+}
+
+func TestInjectWithoutSpan(t *testing.T) {
+	h := http.Header{}
+	Inject(context.Background(), h)
+	if got := h.Get(TraceparentHeader); got != "" {
+		t.Errorf("injected %q without a span", got)
+	}
+}
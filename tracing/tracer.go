@@ -0,0 +1,171 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charm-113c/project-zero/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// queueSize is the number of ended spans waiting to be exported beyond which spans are dropped
+	queueSize = 2048
+	// batchSize is the maximum number of spans exported at once
+	batchSize = 512
+	// flushInterval is how often spans are exported when there are fewer than batchSize of them
+	flushInterval = 5 * time.Second
+)
+
+// Exporter sends completed spans to where they are stored or displayed
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Close() error
+}
+
+// Tracer starts spans and exports them in batches, in the background,
+// so that recording a span never waits on the exporter
+type Tracer struct {
+	exporter    Exporter
+	serviceName string
+	sampleRatio atomic.Uint64 // math.Float64bits of the ratio
+	logger      *zap.Logger
+
+	// mu guards the queue against spans ending while the tracer is shut down
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan SpanData
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewTracer instantiates a Tracer with the exporter selected in the config.
+// It returns nil if tracing is disabled (exporter "none"), which SetTracer accepts
+func NewTracer(cfg config.Config, parentLogger *zap.Logger) (*Tracer, error) {
+	logger := parentLogger.With(zap.String("component", "tracing"))
+	logger.Info("Tracing exporter: " + cfg.Tracing.Exporter)
+
+	var exporter Exporter
+	switch cfg.Tracing.Exporter {
+	case "none", "":
+		return nil, nil
+	case "stdout":
+		exporter = NewStdoutExporter()
+	case "file":
+		fileExporter, err := NewFileExporter(cfg.Tracing.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fileExporter
+	case "otlp":
+		exporter = NewOTLPExporter(cfg.Tracing.OTLPEndpoint, cfg.Tracing.ServiceName)
+	default:
+		return nil, fmt.Errorf("tracing exporter %s is unsupported", cfg.Tracing.Exporter)
+	}
+
+	return NewTracerWithExporter(exporter, cfg.Tracing.ServiceName, cfg.Tracing.SampleRatio, logger), nil
+}
+
+// NewTracerWithExporter instantiates a Tracer exporting to exporter. Traces started by this
+// service are recorded with probability sampleRatio, while the others follow their parent's choice
+func NewTracerWithExporter(exporter Exporter, serviceName string, sampleRatio float64, logger *zap.Logger) *Tracer {
+	t := &Tracer{
+		exporter:    exporter,
+		serviceName: serviceName,
+		logger:      logger,
+		queue:       make(chan SpanData, queueSize),
+		done:        make(chan struct{}),
+	}
+	t.SetSampleRatio(sampleRatio)
+	go t.run()
+	return t
+}
+
+// SetSampleRatio changes the share of the traces started by this service that are recorded.
+// It does nothing on a nil Tracer
+func (t *Tracer) SetSampleRatio(ratio float64) {
+	if t != nil {
+		t.sampleRatio.Store(math.Float64bits(ratio))
+	}
+}
+
+// export queues a completed span, dropping it if the exporter can't keep up
+func (t *Tracer) export(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run exports the queued spans in batches, until the queue is closed
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	flush := func() {
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			t.logger.Warn("Spans were dropped, the exporter cannot keep up", zap.Int64("dropped", dropped))
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			t.logger.Warn("Could not export spans", zap.Int("spans", len(batch)), zap.Error(err))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports the spans not exported yet and closes the exporter. Spans ended afterwards
+// are lost, so it should be called once the components recording spans are stopped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	global.CompareAndSwap(t, nil)
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return errors.Join(fmt.Errorf("spans were not all exported in time: %w", ctx.Err()), t.exporter.Close())
+	}
+	return t.exporter.Close()
+}
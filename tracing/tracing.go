@@ -0,0 +1,249 @@
+/* Package tracing records distributed traces of the server's work: a span is recorded for
+* each request served, each query run and each call made to other services, and spans are
+* linked to their parent through the context.Context, as well as across services through
+* the W3C traceparent header. It follows OpenTelemetry's model and exports spans with the
+* OTLP/HTTP JSON encoding, without depending on the OpenTelemetry SDK.
+*
+* Like OpenTelemetry's, the tracer is global: Start records nothing until SetTracer is called,
+* so that packages can record spans without the tracer being handed down to them.
+ */
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace, i.e. all the spans recorded for the same request across services
+type TraceID [16]byte
+
+// IsValid reports whether the ID is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// IsValid reports whether the ID is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is what identifies a span to its children, possibly in other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool // Whether the trace is recorded, which children follow
+}
+
+// IsValid reports whether both IDs are valid
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship between a span and the remote side of the operation, if any
+type SpanKind int
+
+// Span kinds, with the same values as OTLP's
+const (
+	KindInternal SpanKind = iota + 1
+	KindServer
+	KindClient
+)
+
+// Span is an operation being recorded. All its methods are safe to call on a nil Span,
+// which Start returns when no tracer is set
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span's SpanContext
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttr sets an attribute of the span, value being a string, bool, integer or float
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed because of err, which is ignored if nil
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+// End completes the span and hands it over to the exporter. Calls after the first one are ignored
+func (s *Span) End() {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.export(data)
+}
+
+type spanCtxKey struct{}
+type remoteCtxKey struct{}
+
+// SpanFromContext returns the span carried by ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanCtxKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a copy of ctx in which the next span started will be a child
+// of sc, a span of another service (see Extract)
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteCtxKey{}, sc)
+}
+
+// parentContext returns the SpanContext of the span that the next span started from ctx is a child of
+func parentContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.sc, true
+	}
+	sc, ok := ctx.Value(remoteCtxKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+var global atomic.Pointer[Tracer]
+
+// SetTracer sets the tracer used by Start; a nil tracer disables tracing
+func SetTracer(t *Tracer) {
+	global.Store(t)
+}
+
+// Start starts a span as a child of the span carried by ctx, or as the root of a new trace.
+// It returns a copy of ctx carrying the new span, which the caller must End
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := global.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, name, kind)
+}
+
+// Start is like the package's Start, with t as the tracer
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{tracer: t}
+	if parent, ok := parentContext(ctx); ok {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+		span.sc.Sampled = t.sample(span.sc.TraceID)
+	}
+	span.sc.SpanID = newSpanID()
+
+	span.data = SpanData{
+		TraceID: span.sc.TraceID.String(),
+		SpanID:  span.sc.SpanID.String(),
+		Name:    name,
+		Kind:    kind,
+		Start:   time.Now(),
+		Service: t.serviceName,
+	}
+	if span.parent.IsValid() {
+		span.data.ParentSpanID = span.parent.String()
+	}
+	return context.WithValue(ctx, spanCtxKey{}, span), span
+}
+
+// sample decides whether a new trace is recorded, consistently for a given trace ID
+func (t *Tracer) sample(id TraceID) bool {
+	ratio := math.Float64frombits(t.sampleRatio.Load())
+	switch {
+	case ratio >= 1:
+		return true
+	case ratio <= 0:
+		return false
+	}
+	// The lower 8 bytes of trace IDs are random, so they're uniformly distributed
+	return float64(binary.BigEndian.Uint64(id[8:])>>1) < ratio*float64(uint64(1)<<63)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// SpanData is a completed span, as handed to exporters
+type SpanData struct {
+	TraceID      string         `json:"traceID"`
+	SpanID       string         `json:"spanID"`
+	ParentSpanID string         `json:"parentSpanID,omitempty"`
+	Name         string         `json:"name"`
+	Kind         SpanKind       `json:"kind"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	Service      string         `json:"service"`
+}
+
+// Duration returns how long the span lasted
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
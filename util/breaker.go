@@ -0,0 +1,123 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker.Do while the circuit is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// States of a CircuitBreaker
+const (
+	CircuitClosed   = "closed"    // Calls go through
+	CircuitOpen     = "open"      // Calls fail fast with ErrCircuitOpen
+	CircuitHalfOpen = "half-open" // A single trial call goes through, to probe whether the dependency recovered
+)
+
+// CircuitBreaker stops calling a failing dependency for a while, so that callers fail fast instead of
+// piling up on timeouts, and the dependency isn't hammered while it recovers. The circuit opens after
+// threshold consecutive failures, and lets a trial call through after cooldown: the circuit closes if
+// it succeeds, and opens again otherwise. Only retryable errors (see IsRetryable) count as failures,
+// as the others (e.g. a 404 response) don't tell that the dependency is unavailable. Neither do calls
+// interrupted by their caller, which leave the state unchanged
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker instantiates a closed CircuitBreaker guarding the dependency name
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration, parentLogger *zap.Logger) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:      name,
+		threshold: max(threshold, 1),
+		cooldown:  cooldown,
+		logger:    parentLogger.With(zap.String("component", "circuit breaker"), zap.String("dependency", name)),
+		state:     CircuitClosed,
+	}
+}
+
+// Do calls fn unless the circuit is open, in which case it returns ErrCircuitOpen
+// ctx is the context of the call, which tells whether fn failed because its caller gave up
+func (b *CircuitBreaker) Do(ctx context.Context, fn func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		b.release()
+		return err
+	}
+	b.record(err)
+	return err
+}
+
+// State returns the state of the circuit, see the Circuit* constants
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		return false // A trial call is in flight
+	}
+	return true
+}
+
+// release ends a call that tells nothing about the dependency: an interrupted trial call
+// reopens the circuit as it was, so that the next call is let through as the trial instead
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitHalfOpen {
+		b.state = CircuitOpen
+	}
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || !IsRetryable(err) {
+		if b.state != CircuitClosed {
+			b.logger.Info("Dependency recovered, circuit closed")
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		if b.state != CircuitOpen {
+			b.logger.Warn("Dependency failing, circuit opened", zap.Int("consecutiveFailures", b.failures),
+				zap.Duration("cooldown", b.cooldown), zap.Error(err))
+		}
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,121 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	unavailable := syscall.ECONNREFUSED
+	notFound := &HTTPStatusError{"GET", "http://logto", 404}
+	tests := []struct {
+		name      string
+		cooldown  time.Duration
+		calls     []error // Returned by the successive calls
+		wantErrs  []error // Returned by Do for each call, the call's own error if nil
+		wantState string
+	}{
+		{"closed below the threshold", time.Hour, []error{unavailable, unavailable}, nil, CircuitClosed},
+		{"opens at the threshold", time.Hour, []error{unavailable, unavailable, unavailable}, nil, CircuitOpen},
+		{"fails fast while open", time.Hour, []error{unavailable, unavailable, unavailable, nil},
+			[]error{nil, nil, nil, ErrCircuitOpen}, CircuitOpen},
+		{"success resets the failures", time.Hour, []error{unavailable, unavailable, nil, unavailable, unavailable}, nil, CircuitClosed},
+		{"non-retryable errors don't count", time.Hour, []error{unavailable, unavailable, notFound, unavailable}, nil, CircuitClosed},
+		{"half-open after the cooldown", 0, []error{unavailable, unavailable, unavailable}, nil, CircuitHalfOpen},
+		{"trial success closes", 0, []error{unavailable, unavailable, unavailable, nil}, nil, CircuitClosed},
+		{"trial failure reopens", 0, []error{unavailable, unavailable, unavailable, unavailable}, nil, CircuitHalfOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", 3, tt.cooldown, zap.NewNop())
+			for i, callErr := range tt.calls {
+				err := b.Do(context.Background(), func() error { return callErr })
+				want := callErr
+				if i < len(tt.wantErrs) && tt.wantErrs[i] != nil {
+					want = tt.wantErrs[i]
+				}
+				if !errors.Is(err, want) {
+					t.Errorf("call %d: Do() = %v, want %v", i+1, err, want)
+				}
+			}
+			if got := b.State(); got != tt.wantState {
+				t.Errorf("State() = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	b := NewCircuitBreaker("test", 1, 0, zap.NewNop())
+	_ = b.Do(context.Background(), func() error { return syscall.ECONNREFUSED })
+
+	err := b.Do(context.Background(), func() error {
+		// The trial call is in flight: other calls fail fast until it ends
+		if err := b.Do(context.Background(), func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("concurrent call: Do() = %v, want ErrCircuitOpen", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("trial call: Do() = %v, want nil", err)
+	}
+	if got := b.State(); got != CircuitClosed {
+		t.Errorf("State() = %s, want %s", got, CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerCancelled(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		failures  int // Before the cancelled call
+		wantState string
+	}{
+		// A cancelled call doesn't count as a failure, the circuit staying closed
+		{"closed", 1, 0, CircuitClosed},
+		// A cancelled trial call leaves the circuit open, letting the next call through as the trial
+		{"half-open", 1, 1, CircuitHalfOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", tt.threshold, 0, zap.NewNop())
+			for range tt.failures {
+				_ = b.Do(context.Background(), func() error { return syscall.ECONNREFUSED })
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			err := b.Do(ctx, func() error {
+				cancel()
+				return ctx.Err()
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Do() = %v, want context.Canceled", err)
+			}
+			if got := b.State(); got != tt.wantState {
+				t.Errorf("State() = %s, want %s", got, tt.wantState)
+			}
+
+			called := false
+			if err := b.Do(context.Background(), func() error { called = true; return nil }); err != nil || !called {
+				t.Errorf("next call: Do() = %v, called = %v, want it let through", err, called)
+			}
+			if got := b.State(); got != CircuitClosed {
+				t.Errorf("State() after the next call = %s, want %s", got, CircuitClosed)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerDeadlineExceeded(t *testing.T) {
+	// A call timing out on its own (the caller's context being still alive) is a failure
+	b := NewCircuitBreaker("test", 1, time.Hour, zap.NewNop())
+	_ = b.Do(context.Background(), func() error { return context.DeadlineExceeded })
+	if got := b.State(); got != CircuitOpen {
+		t.Errorf("State() = %s, want %s", got, CircuitOpen)
+	}
+}
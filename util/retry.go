@@ -0,0 +1,184 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// RetryPolicy configures how Retry retries an operation. Delays grow exponentially from BaseDelay,
+// and each actual delay is drawn at random between 0 and the current one ("full jitter"), so that
+// replicas retrying at the same time spread their attempts instead of hitting the dependency together
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, unlimited if 0 (the Deadline should then be set)
+	Attempts int
+	// BaseDelay is the upper bound of the delay before the second attempt, doubled after each attempt
+	BaseDelay time.Duration
+	// MaxDelay caps the delays, uncapped if 0
+	MaxDelay time.Duration
+	// Deadline bounds the total time spent, attempts included, unbounded if 0
+	Deadline time.Duration
+	// Retryable reports whether an error is worth retrying, IsRetryable if nil
+	Retryable func(err error) bool
+}
+
+// backoff returns the delay before the attempt following attempt n (starting from 1)
+func (p RetryPolicy) backoff(n int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < n && (p.MaxDelay == 0 || ceiling < p.MaxDelay); i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 {
+		ceiling = min(ceiling, p.MaxDelay)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
+
+// Retry calls operation until it succeeds, fails with an error that isn't retryable, or the policy's
+// attempts or deadline are exhausted. operation is given a context bounded by the deadline.
+// Retries are logged as warnings, the final error is returned for the caller to log
+func Retry(ctx context.Context, logger *zap.Logger, policy RetryPolicy, operation func(ctx context.Context) error) error {
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	start := time.Now()
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Deadline)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := operation(ctx)
+		if err == nil {
+			return nil
+		}
+		if IsPermanent(err) || !retryable(err) {
+			return err
+		}
+		if policy.Attempts > 0 && attempt >= policy.Attempts {
+			return fmt.Errorf("operation failed after %d attempts: %w", attempt, err)
+		}
+
+		delay := policy.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return fmt.Errorf("operation failed after %d attempts in %s: %w", attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+		logger.Warn("Operation failed, retrying", zap.Int("attempt", attempt), zap.Error(err), zap.Duration("delay", delay))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation interrupted after %d attempts: %w (last error: %v)", attempt, ctx.Err(), err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// permanentError marks an error as not worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that Retry returns it without retrying, whatever the policy
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, was marked Permanent
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// HTTPStatusError is the error of an HTTP response whose status signals a failure
+type HTTPStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s %s responded %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryableStatus reports whether an HTTP status signals a transient failure
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRetryable reports whether err is likely transient, i.e. whether the same operation may succeed later:
+// network errors, Postgres errors signalling an unavailable server, a lost connection or a conflict between
+// transactions, and HTTP statuses signalling an overloaded or unavailable server (see HTTPStatusError).
+// Errors marked Permanent, cancellations, open circuit breakers and any other error aren't retryable
+func IsRetryable(err error) bool {
+	if err == nil || IsPermanent(err) || errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	// Postgres errors are checked first, as they may be wrapped in network-level errors (e.g. when authenticating)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// SQLSTATE codes are 5 characters long, the first 2 being the class, but nothing enforces it client-side
+		if len(pgErr.Code) >= 2 {
+			switch pgErr.Code[:2] {
+			case "08", // Connection exception
+				"53": // Insufficient resources, e.g. too many connections
+				return true
+			}
+		}
+		switch pgErr.Code {
+		case "40001", // Serialization failure
+			"40P01", // Deadlock detected
+			"57P01", // Admin shutdown
+			"57P02", // Crash shutdown
+			"57P03": // Cannot connect now, e.g. the server is starting
+			return true
+		}
+		return false
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		// Includes hosts not resolvable yet, e.g. a container that isn't started
+		return true
+	case errors.As(err, &netErr):
+		return true
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF), errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// RetryTransport is an http.RoundTripper retrying the idempotent requests (GET, HEAD, OPTIONS) according
+// to Policy, other requests being sent once. Every attempt goes through Breaker, if set.
+// Responses with a status signalling a transient failure (e.g. 503) are retried too; should the last
+// attempt still get one, it's returned as an *HTTPStatusError rather than as a response.
+// The policy's Deadline only bounds the retries, each attempt being bounded by the request's context
+type RetryTransport struct {
+	Base    http.RoundTripper // http.DefaultTransport if nil
+	Policy  RetryPolicy
+	Breaker *CircuitBreaker
+	Logger  *zap.Logger
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	policy := t.Policy
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		policy.Attempts = 1
+	}
+
+	var resp *http.Response
+	attempt := func() error {
+		r, err := base.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		if retryableStatus(r.StatusCode) {
+			io.Copy(io.Discard, io.LimitReader(r.Body, 64*1024))
+			r.Body.Close()
+			return &HTTPStatusError{Method: req.Method, URL: req.URL.Redacted(), StatusCode: r.StatusCode}
+		}
+		resp = r
+		return nil
+	}
+	err := Retry(req.Context(), t.Logger, policy, func(ctx context.Context) error {
+		if t.Breaker != nil {
+			return t.Breaker.Do(ctx, attempt)
+		}
+		return attempt()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,163 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		ceiling time.Duration
+	}{
+		{"first attempt", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 1, 100 * time.Millisecond},
+		{"doubled", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 3, 400 * time.Millisecond},
+		{"uncapped", RetryPolicy{BaseDelay: time.Second}, 11, 1024 * time.Second},
+		{"capped", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 10, time.Second},
+		{"cap below the base delay", RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Millisecond}, 1, 10 * time.Millisecond},
+		{"no delay", RetryPolicy{}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 1000 {
+				delay := tt.policy.backoff(tt.attempt)
+				if tt.ceiling == 0 && delay != 0 {
+					t.Fatalf("backoff(%d) = %s, want 0", tt.attempt, delay)
+				}
+				if tt.ceiling > 0 && (delay <= 0 || delay > tt.ceiling) {
+					t.Fatalf("backoff(%d) = %s, want within (0, %s]", tt.attempt, delay, tt.ceiling)
+				}
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	transient := fmt.Errorf("dialing: %w", syscall.ECONNREFUSED)
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		errs      []error // Returned by the successive calls, nil once exhausted
+		wantCalls int
+		wantErr   error
+	}{
+		{"success", RetryPolicy{Attempts: 3}, nil, 1, nil},
+		{"transient then success", RetryPolicy{Attempts: 3}, []error{transient, transient}, 3, nil},
+		{"attempts exhausted", RetryPolicy{Attempts: 3}, []error{transient, transient, transient, transient}, 3, syscall.ECONNREFUSED},
+		{"not retryable", RetryPolicy{Attempts: 3}, []error{io.ErrClosedPipe}, 1, io.ErrClosedPipe},
+		{"permanent", RetryPolicy{Attempts: 3, Retryable: func(error) bool { return true }}, []error{Permanent(transient)}, 1, syscall.ECONNREFUSED},
+		{"custom classification", RetryPolicy{Attempts: 3, Retryable: func(error) bool { return true }}, []error{io.ErrClosedPipe}, 2, nil},
+		{"deadline shorter than the delay", RetryPolicy{BaseDelay: time.Hour, Deadline: time.Millisecond}, []error{transient, transient}, 1, syscall.ECONNREFUSED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Retry(context.Background(), zap.NewNop(), tt.policy, func(context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Retry() = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Retry() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("operation called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Retry(ctx, zap.NewNop(), RetryPolicy{BaseDelay: time.Hour}, func(context.Context) error {
+		return syscall.ECONNRESET
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Retry() = %v, want context.Canceled", err)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	base := errors.New("malformed payload")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", base, false},
+		{"permanent", Permanent(base), true},
+		{"wrapped permanent", fmt.Errorf("handling event: %w", Permanent(base)), true},
+		{"joined permanent", errors.Join(base, Permanent(base)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("IsPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) != nil")
+	}
+	if err := Permanent(base); !errors.Is(err, base) {
+		t.Errorf("Permanent(err) doesn't wrap err")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	pgError := func(code string) error {
+		return fmt.Errorf("querying: %w", &pgconn.PgError{Code: code})
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", errors.New("boom"), false},
+		{"connection refused", fmt.Errorf("dialing: %w", syscall.ECONNREFUSED), true},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+		{"circuit open", fmt.Errorf("calling Logto: %w", ErrCircuitOpen), false},
+		{"permanent network error", Permanent(syscall.ECONNREFUSED), false},
+		{"pg connection exception", pgError("08006"), true},
+		{"pg too many connections", pgError("53300"), true},
+		{"pg serialization failure", pgError("40001"), true},
+		{"pg deadlock", pgError("40P01"), true},
+		{"pg cannot connect now", pgError("57P03"), true},
+		{"pg unique violation", pgError("23505"), false},
+		{"pg empty code", pgError(""), false},
+		{"pg short code", pgError("0"), false},
+		{"HTTP 503", &HTTPStatusError{http.MethodGet, "http://logto", http.StatusServiceUnavailable}, true},
+		{"HTTP 429", &HTTPStatusError{http.MethodGet, "http://logto", http.StatusTooManyRequests}, true},
+		{"HTTP 404", &HTTPStatusError{http.MethodGet, "http://logto", http.StatusNotFound}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}